@@ -0,0 +1,500 @@
+// Package admin implements subcommands of the driver binary that help with troubleshooting. They
+// show networks and endpoints managed by the driver, joining what docker, HNS and Contrail know
+// about them, and remove orphans: HNS objects that docker no longer knows about.
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/driver"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/hnsManager"
+)
+
+// DefaultOrphanGracePeriod is how long Cleanup waits before removing orphans by default. The
+// driver creates HNS networks and endpoints before docker lists them, so every object that is
+// being created looks like an orphan for a moment.
+const DefaultOrphanGracePeriod = 30 * time.Second
+
+// Kinds of orphans.
+const (
+	OrphanNetwork  = "network"
+	OrphanEndpoint = "endpoint"
+)
+
+// Network is a Contrail network used by docker. IDs are empty where it's missing.
+type Network struct {
+	Domain       string `json:"domain"`
+	Tenant       string `json:"tenant"`
+	Name         string `json:"name"`
+	DockerID     string `json:"dockerID,omitempty"`
+	DockerName   string `json:"dockerName,omitempty"`
+	HNSID        string `json:"hnsID,omitempty"`
+	ContrailUUID string `json:"contrailUUID,omitempty"`
+}
+
+// byDomainTenantName sorts networks by their Contrail FQ name.
+type byDomainTenantName []Network
+
+func (n byDomainTenantName) Len() int      { return len(n) }
+func (n byDomainTenantName) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byDomainTenantName) Less(i, j int) bool {
+	if n[i].Domain != n[j].Domain {
+		return n[i].Domain < n[j].Domain
+	}
+	if n[i].Tenant != n[j].Tenant {
+		return n[i].Tenant < n[j].Tenant
+	}
+	return n[i].Name < n[j].Name
+}
+
+// Endpoint is a docker endpoint in a Contrail network. Its ID is used as name of HNS endpoint and
+// of Contrail virtual machine. IDs are empty where it's missing.
+type Endpoint struct {
+	ID              string `json:"id"`
+	Domain          string `json:"domain"`
+	Tenant          string `json:"tenant"`
+	Network         string `json:"network"`
+	DockerNetworkID string `json:"dockerNetworkID,omitempty"`
+	ContainerID     string `json:"containerID,omitempty"`
+	ContainerName   string `json:"containerName,omitempty"`
+	HNSID           string `json:"hnsID,omitempty"`
+	IPAddress       string `json:"ipAddress,omitempty"`
+	MacAddress      string `json:"macAddress,omitempty"`
+	GatewayAddress  string `json:"gatewayAddress,omitempty"`
+	ContrailUUID    string `json:"contrailUUID,omitempty"`
+	// ContrailInterfaceUUIDs are filled in only by Show.
+	ContrailInterfaceUUIDs []string `json:"contrailInterfaceUUIDs,omitempty"`
+}
+
+// byID sorts endpoints by their docker ID.
+type byID []Endpoint
+
+func (e byID) Len() int           { return len(e) }
+func (e byID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byID) Less(i, j int) bool { return e[i].ID < e[j].ID }
+
+// Orphan is an HNS network or endpoint in a Contrail network that docker doesn't know about,
+// e.g. because docker daemon was reinstalled or the driver crashed while handling a request.
+type Orphan struct {
+	Kind    string `json:"kind"`
+	HNSID   string `json:"hnsID"`
+	Name    string `json:"name"`
+	Domain  string `json:"domain"`
+	Tenant  string `json:"tenant"`
+	Network string `json:"network"`
+	Reason  string `json:"reason"`
+}
+
+// Removed lists what Reset removed.
+type Removed struct {
+	Networks  []Network  `json:"networks"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Admin inspects and repairs resources managed by the driver.
+type Admin struct {
+	controller      controller.Controller
+	hns             hns.HNS
+	hnsMgr          *hnsManager.HNSManager
+	docker          driver.DockerClient
+	rootNetworkName string
+	// defaultDomain is the Contrail domain of docker networks without the "domain" option.
+	defaultDomain string
+	// OrphanGracePeriod is how long an object has to remain an orphan before Cleanup removes it.
+	OrphanGracePeriod time.Duration
+}
+
+func New(c controller.Controller, h hns.HNS, docker driver.DockerClient,
+	rootNetworkName, defaultDomain string) *Admin {
+	hnsMgr := hnsManager.NewHNSManager(h)
+	hnsMgr.DefaultDomain = defaultDomain
+	return &Admin{
+		controller:        c,
+		hns:               h,
+		hnsMgr:            hnsMgr,
+		docker:            docker,
+		rootNetworkName:   rootNetworkName,
+		defaultDomain:     defaultDomain,
+		OrphanGracePeriod: DefaultOrphanGracePeriod,
+	}
+}
+
+// Networks returns Contrail networks that exist in docker or HNS, sorted by domain, tenant and
+// name.
+func (a *Admin) Networks(ctx context.Context) ([]Network, error) {
+	networks := make(map[hnsManager.NetworkName]*Network)
+	get := func(name hnsManager.NetworkName) *Network {
+		if networks[name] == nil {
+			networks[name] = &Network{Domain: name.Domain, Tenant: name.Tenant, Name: name.Network}
+		}
+		return networks[name]
+	}
+
+	dockerNets, err := a.docker.NetworkList()
+	if err != nil {
+		return nil, err
+	}
+	for _, dockerNet := range dockerNets {
+		tenant, tenantExists := dockerNet.Options["tenant"]
+		name, networkExists := dockerNet.Options["network"]
+		if tenantExists && networkExists {
+			domain := dockerNet.Options["domain"]
+			if domain == "" {
+				domain = a.defaultDomain
+			}
+			net := get(hnsManager.NetworkName{Domain: domain, Tenant: tenant, Network: name})
+			net.DockerID = dockerNet.ID
+			net.DockerName = dockerNet.Name
+		}
+	}
+
+	hnsNets, err := a.hnsMgr.ListNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hnsNet := range hnsNets {
+		name, _ := hnsManager.DecodeHNSNetworkName(hnsNet.Name, a.defaultDomain)
+		get(name).HNSID = hnsNet.Id
+	}
+
+	var result []Network
+	for _, net := range networks {
+		contrailNet, err := a.controller.GetNetwork(ctx, net.Domain, net.Tenant, net.Name)
+		if err == nil {
+			net.ContrailUUID = contrailNet.GetUuid()
+		}
+		result = append(result, *net)
+	}
+	sort.Sort(byDomainTenantName(result))
+	return result, nil
+}
+
+// Endpoints returns endpoints of Contrail networks that exist in docker or HNS, sorted by ID.
+func (a *Admin) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	networks, err := a.Networks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]*Endpoint)
+
+	hnsNetworks := make(map[string]Network)
+	for _, net := range networks {
+		if net.HNSID != "" {
+			hnsNetworks[net.HNSID] = net
+		}
+		if net.DockerID == "" {
+			continue
+		}
+		// Only inspecting a network returns its endpoints.
+		dockerNet, err := a.docker.NetworkInspect(net.DockerID)
+		if err != nil {
+			return nil, err
+		}
+		for containerID, container := range dockerNet.Containers {
+			endpoints[container.EndpointID] = &Endpoint{
+				ID:              container.EndpointID,
+				Domain:          net.Domain,
+				Tenant:          net.Tenant,
+				Network:         net.Name,
+				DockerNetworkID: net.DockerID,
+				ContainerID:     containerID,
+				ContainerName:   container.Name,
+			}
+		}
+	}
+
+	hnsEndpoints, err := a.hnsMgr.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hnsEp := range hnsEndpoints {
+		ep := endpoints[hnsEp.Name]
+		if ep == nil {
+			net := hnsNetworks[hnsEp.VirtualNetwork]
+			ep = &Endpoint{
+				ID:      hnsEp.Name,
+				Domain:  net.Domain,
+				Tenant:  net.Tenant,
+				Network: net.Name,
+			}
+			endpoints[hnsEp.Name] = ep
+		}
+		fillFromHNS(ep, &hnsEp)
+	}
+
+	var result []Endpoint
+	for _, ep := range endpoints {
+		if instance, err := a.controller.GetInstance(ctx, ep.ID); err == nil {
+			ep.ContrailUUID = instance.GetUuid()
+		}
+		result = append(result, *ep)
+	}
+	sort.Sort(byID(result))
+	return result, nil
+}
+
+func fillFromHNS(ep *Endpoint, hnsEp *hns.Endpoint) {
+	ep.HNSID = hnsEp.Id
+	if hnsEp.IPAddress != nil {
+		ep.IPAddress = hnsEp.IPAddress.String()
+	}
+	ep.MacAddress = hnsEp.MacAddress
+	ep.GatewayAddress = hnsEp.GatewayAddress
+}
+
+// Show returns details of a single endpoint, including UUIDs of its Contrail interfaces.
+func (a *Admin) Show(ctx context.Context, endpointID string) (*Endpoint, error) {
+	endpoints, err := a.Endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ep := range endpoints {
+		if ep.ID != endpointID && ep.HNSID != endpointID {
+			continue
+		}
+		if instance, err := a.controller.GetInstance(ctx, ep.ID); err == nil {
+			refs, err := instance.GetVirtualMachineInterfaceBackRefs()
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range refs {
+				ep.ContrailInterfaceUUIDs = append(ep.ContrailInterfaceUUIDs, ref.Uuid)
+			}
+		}
+		return &ep, nil
+	}
+	return nil, fmt.Errorf("Endpoint %s not found in docker nor HNS", endpointID)
+}
+
+// Orphans returns Contrail HNS networks and endpoints that docker doesn't know about.
+func (a *Admin) Orphans(ctx context.Context) ([]Orphan, error) {
+	networks, err := a.Networks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := a.Endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	for _, ep := range endpoints {
+		if ep.HNSID != "" && ep.DockerNetworkID == "" {
+			orphans = append(orphans, Orphan{
+				Kind:    OrphanEndpoint,
+				HNSID:   ep.HNSID,
+				Name:    ep.ID,
+				Domain:  ep.Domain,
+				Tenant:  ep.Tenant,
+				Network: ep.Network,
+				Reason:  "no docker container is attached to it",
+			})
+		}
+	}
+	for _, net := range networks {
+		if net.HNSID != "" && net.DockerID == "" {
+			orphans = append(orphans, Orphan{
+				Kind:    OrphanNetwork,
+				HNSID:   net.HNSID,
+				Name:    fmt.Sprintf("%s/%s/%s", net.Domain, net.Tenant, net.Name),
+				Domain:  net.Domain,
+				Tenant:  net.Tenant,
+				Network: net.Name,
+				Reason:  "no docker network refers to it",
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// Cleanup removes orphans with given HNS IDs or names, or all orphans if all is true. It refuses
+// to remove anything that isn't an orphan. Endpoints are removed before networks, together with
+// their Contrail virtual machines. Networks that still have endpoints aren't removed. With dryRun,
+// nothing is removed. Returns orphans that were (or would be) removed.
+//
+// An endpoint that the driver is creating right now exists in HNS before docker lists it, so
+// Cleanup waits for OrphanGracePeriod and checks again right before each removal whether the
+// object is still an orphan. Objects that stopped being orphans in the meantime are skipped.
+func (a *Admin) Cleanup(ctx context.Context, ids []string, all, dryRun bool) ([]Orphan, error) {
+	orphans, err := a.Orphans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []Orphan
+	if all {
+		selected = orphans
+	} else {
+		for _, id := range ids {
+			found := false
+			for _, orphan := range orphans {
+				if orphan.HNSID == id || orphan.Name == id {
+					selected = append(selected, orphan)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%s is not an orphan, refusing to remove it", id)
+			}
+		}
+	}
+	if dryRun {
+		return selected, nil
+	}
+	if err := sleep(ctx, a.OrphanGracePeriod); err != nil {
+		return nil, err
+	}
+
+	var removed []Orphan
+	for _, orphan := range selected {
+		if orphan.Kind == OrphanEndpoint {
+			isOrphan, err := a.isStillOrphan(ctx, orphan)
+			if err != nil {
+				return removed, err
+			}
+			if !isOrphan {
+				continue
+			}
+			if err := a.removeEndpoint(ctx, orphan); err != nil {
+				return removed, err
+			}
+			removed = append(removed, orphan)
+		}
+	}
+	for _, orphan := range selected {
+		if orphan.Kind == OrphanNetwork {
+			isOrphan, err := a.isStillOrphan(ctx, orphan)
+			if err != nil {
+				return removed, err
+			}
+			if !isOrphan {
+				continue
+			}
+			name := hnsManager.NetworkName{
+				Domain:  orphan.Domain,
+				Tenant:  orphan.Tenant,
+				Network: orphan.Network,
+			}
+			if err := a.hnsMgr.DeleteNetwork(ctx, name); err != nil {
+				return removed, fmt.Errorf("Failed to remove network %s: %v", orphan.Name, err)
+			}
+			removed = append(removed, orphan)
+		}
+	}
+	return removed, nil
+}
+
+// isStillOrphan checks docker and HNS again to tell whether orphan found earlier is still one.
+func (a *Admin) isStillOrphan(ctx context.Context, orphan Orphan) (bool, error) {
+	orphans, err := a.Orphans(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, current := range orphans {
+		if current.Kind == orphan.Kind && current.HNSID == orphan.HNSID {
+			return true, nil
+		}
+	}
+	common.Logger(ctx).Infoln("Skipping", orphan.Kind, orphan.Name,
+		"which is no longer an orphan")
+	return false, nil
+}
+
+// sleep waits for duration unless ctx is done first.
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (a *Admin) removeEndpoint(ctx context.Context, orphan Orphan) error {
+	if instance, err := a.controller.GetInstance(ctx, orphan.Name); err == nil {
+		if err := a.controller.DeleteElementRecursive(ctx, instance); err != nil {
+			return fmt.Errorf("Failed to remove Contrail instance of endpoint %s: %v",
+				orphan.Name, err)
+		}
+	}
+	if err := a.hns.DeleteEndpoint(ctx, orphan.HNSID); err != nil {
+		return fmt.Errorf("Failed to remove endpoint %s: %v", orphan.Name, err)
+	}
+	return nil
+}
+
+// Reset removes everything the driver created on this host: Contrail HNS networks with their
+// endpoints, Contrail virtual machines of these endpoints and, if withRoot is true, the root
+// network. Unlike common.HardResetHNS, it leaves HNS networks of other owners intact. With dryRun,
+// nothing is removed. Returns what was (or would be) removed.
+func (a *Admin) Reset(ctx context.Context, withRoot, dryRun bool) (*Removed, error) {
+	rootNetworkName := ""
+	if withRoot {
+		rootNetworkName = a.rootNetworkName
+	}
+	// Virtual machines are found by names of HNS endpoints, so they are removed first.
+	planned, err := a.hnsMgr.Cleanup(ctx, rootNetworkName, true)
+	if err != nil {
+		return nil, err
+	}
+	instanceUUIDs := make(map[string]string)
+	for _, ep := range planned.Endpoints {
+		instance, err := a.controller.GetInstance(ctx, ep.Name)
+		if err != nil {
+			continue
+		}
+		instanceUUIDs[ep.Name] = instance.GetUuid()
+		if dryRun {
+			continue
+		}
+		common.Logger(ctx).Infoln("Removing Contrail instance", ep.Name, instance.GetUuid())
+		if err := a.controller.DeleteElementRecursive(ctx, instance); err != nil {
+			return nil, fmt.Errorf("Failed to remove Contrail instance of endpoint %s: %v",
+				ep.Name, err)
+		}
+	}
+
+	removed := planned
+	if !dryRun {
+		removed, err = a.hnsMgr.Cleanup(ctx, rootNetworkName, false)
+		if removed == nil {
+			return nil, err
+		}
+	}
+
+	result := &Removed{}
+	hnsNetworks := make(map[string]Network)
+	for _, hnsNet := range removed.Networks {
+		net := Network{Name: hnsNet.Name, HNSID: hnsNet.Id}
+		if name, ok := hnsManager.DecodeHNSNetworkName(hnsNet.Name, a.defaultDomain); ok {
+			net.Domain, net.Tenant, net.Name = name.Domain, name.Tenant, name.Network
+		}
+		hnsNetworks[hnsNet.Id] = net
+		result.Networks = append(result.Networks, net)
+	}
+	for _, hnsEp := range removed.Endpoints {
+		net := hnsNetworks[hnsEp.VirtualNetwork]
+		ep := Endpoint{
+			ID:           hnsEp.Name,
+			Domain:       net.Domain,
+			Tenant:       net.Tenant,
+			Network:      net.Name,
+			ContrailUUID: instanceUUIDs[hnsEp.Name],
+		}
+		fillFromHNS(&ep, &hnsEp)
+		result.Endpoints = append(result.Endpoints, ep)
+	}
+	return result, err
+}
@@ -0,0 +1,348 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/driver"
+	"github.com/codilime/contrail-windows-docker/hns"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestAdmin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("admin_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Admin test suite", []Reporter{junitReporter})
+}
+
+var ctx = context.Background()
+
+var _ = Describe("Admin commands", func() {
+
+	const (
+		tenantName  = "agatka"
+		networkName = "test_net"
+		subnetCIDR  = "10.0.0.0/24"
+		dockerNetID = "1234"
+		endpointID  = "5678"
+		containerID = "abcd"
+	)
+
+	var fakeController *controller.FakeController
+	var fakeHNS *hns.FakeHNS
+	var fakeDocker *driver.FakeDockerClient
+	var dockerNet dockerTypes.NetworkResource
+	var d *driver.ContrailDriver
+	var a *Admin
+
+	BeforeEach(func() {
+		fakeController = controller.NewFakeController()
+		project := new(types.Project)
+		project.SetFQName("domain", []string{common.DomainName, tenantName})
+		Expect(fakeController.ApiClient.Create(project)).To(Succeed())
+		controller.CreateMockedNetworkWithSubnet(fakeController.ApiClient, networkName,
+			subnetCIDR, project)
+
+		fakeDocker = driver.NewFakeDockerClient()
+		dockerNet = dockerTypes.NetworkResource{
+			ID:         dockerNetID,
+			Name:       "contrail_net",
+			Options:    map[string]string{"tenant": tenantName, "network": networkName},
+			Containers: map[string]dockerTypes.EndpointResource{},
+		}
+		fakeDocker.AddNetwork(dockerNet)
+
+		fakeHNS = hns.NewFakeHNS()
+		d = driver.NewDriver(common.DefaultConfig(), fakeController, fakeHNS, fakeDocker)
+		err := d.CreateNetwork(&network.CreateNetworkRequest{
+			NetworkID: dockerNetID,
+			Options: map[string]interface{}{
+				netlabel.GenericData: map[string]interface{}{
+					"tenant":  tenantName,
+					"network": networkName,
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = d.CreateEndpoint(&network.CreateEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		dockerNet.Containers[containerID] = dockerTypes.EndpointResource{
+			Name:       "web",
+			EndpointID: endpointID,
+		}
+		fakeDocker.AddNetwork(dockerNet)
+
+		a = New(fakeController, fakeHNS, fakeDocker, common.RootNetworkName, common.DomainName)
+		a.OrphanGracePeriod = 0
+	})
+
+	// forgetEndpoint makes docker forget about the endpoint, leaving it in HNS and Contrail.
+	forgetEndpoint := func() {
+		delete(dockerNet.Containers, containerID)
+		fakeDocker.AddNetwork(dockerNet)
+	}
+
+	hnsEndpointID := func() string {
+		ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep).ToNot(BeNil())
+		return ep.Id
+	}
+
+	It("lists networks known to docker, HNS and Contrail", func() {
+		networks, err := a.Networks(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(networks).To(HaveLen(1))
+		Expect(networks[0].Domain).To(Equal(common.DomainName))
+		Expect(networks[0].Tenant).To(Equal(tenantName))
+		Expect(networks[0].Name).To(Equal(networkName))
+		Expect(networks[0].DockerID).To(Equal(dockerNetID))
+		Expect(networks[0].DockerName).To(Equal("contrail_net"))
+		Expect(networks[0].HNSID).ToNot(BeEmpty())
+		Expect(networks[0].ContrailUUID).ToNot(BeEmpty())
+	})
+
+	It("lists endpoints joined across docker, HNS and Contrail", func() {
+		endpoints, err := a.Endpoints(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(endpoints).To(HaveLen(1))
+		ep := endpoints[0]
+		Expect(ep.ID).To(Equal(endpointID))
+		Expect(ep.ContainerID).To(Equal(containerID))
+		Expect(ep.ContainerName).To(Equal("web"))
+		Expect(ep.HNSID).To(Equal(hnsEndpointID()))
+		Expect(ep.IPAddress).ToNot(BeEmpty())
+		Expect(ep.ContrailUUID).ToNot(BeEmpty())
+	})
+
+	It("shows Contrail interfaces of endpoint", func() {
+		ep, err := a.Show(ctx, hnsEndpointID())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep.ID).To(Equal(endpointID))
+		Expect(ep.ContrailInterfaceUUIDs).To(HaveLen(1))
+
+		_, err = a.Show(ctx, "nonexistent")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports no orphans when docker knows about everything", func() {
+		orphans, err := a.Orphans(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(BeEmpty())
+	})
+
+	It("reports endpoints and networks that docker doesn't know about", func() {
+		forgetEndpoint()
+		fakeDocker.RemoveNetwork(dockerNetID)
+
+		orphans, err := a.Orphans(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(HaveLen(2))
+		Expect(orphans[0].Kind).To(Equal(OrphanEndpoint))
+		Expect(orphans[0].Name).To(Equal(endpointID))
+		Expect(orphans[1].Kind).To(Equal(OrphanNetwork))
+		Expect(orphans[1].Name).To(Equal(common.DomainName + "/" + tenantName + "/" + networkName))
+		Expect(orphans[1].Tenant).To(Equal(tenantName))
+		Expect(orphans[1].Network).To(Equal(networkName))
+	})
+
+	It("matches HNS networks named in the older format to networks in the default domain", func() {
+		a = New(fakeController, fakeHNS, fakeDocker, common.RootNetworkName, "k8s")
+		dockerNet.Options["domain"] = common.DomainName
+		fakeDocker.AddNetwork(dockerNet)
+		fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+			ID:      "4321",
+			Name:    "legacy_net",
+			Options: map[string]string{"tenant": tenantName, "network": "legacy_net"},
+		})
+		hns.MockHNSNetwork(fakeHNS, "Contrail:"+tenantName+":legacy_net", "Ethernet0",
+			"10.1.0.0/24", "10.1.0.1")
+
+		orphans, err := a.Orphans(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(BeEmpty())
+	})
+
+	Context("cleanup", func() {
+		BeforeEach(func() {
+			forgetEndpoint()
+			fakeDocker.RemoveNetwork(dockerNetID)
+		})
+
+		It("refuses to remove anything that isn't an orphan", func() {
+			fakeDocker.AddNetwork(dockerNet)
+			networks, err := a.Networks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.Cleanup(ctx, []string{networks[0].HNSID}, false, false)
+			Expect(err).To(HaveOccurred())
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+		})
+
+		It("doesn't remove anything in dry run", func() {
+			removed, err := a.Cleanup(ctx, nil, true, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(HaveLen(2))
+
+			orphans, err := a.Orphans(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(HaveLen(2))
+		})
+
+		It("removes selected endpoint with its Contrail instance", func() {
+			removed, err := a.Cleanup(ctx, []string{endpointID}, false, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(HaveLen(1))
+
+			ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ep).To(BeNil())
+			_, err = fakeController.GetInstance(ctx, endpointID)
+			Expect(err).To(HaveOccurred())
+			_, err = fakeController.GetNetwork(ctx, common.DomainName, tenantName, networkName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("refuses to remove network that still has endpoints", func() {
+			name := common.DomainName + "/" + tenantName + "/" + networkName
+			_, err := a.Cleanup(ctx, []string{name}, false, false)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("skips orphans that docker learned about during the grace period", func() {
+			a.OrphanGracePeriod = 100 * time.Millisecond
+			// Docker lists the endpoint once the driver finishes creating it.
+			time.AfterFunc(10*time.Millisecond, func() {
+				fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+					ID:      dockerNetID,
+					Name:    dockerNet.Name,
+					Options: dockerNet.Options,
+					Containers: map[string]dockerTypes.EndpointResource{
+						containerID: {Name: "web", EndpointID: endpointID},
+					},
+				})
+			})
+
+			removed, err := a.Cleanup(ctx, nil, true, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(BeEmpty())
+			Expect(hnsEndpointID()).ToNot(BeEmpty())
+			_, err = fakeController.GetInstance(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes all orphans, endpoints first", func() {
+			removed, err := a.Cleanup(ctx, nil, true, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(HaveLen(2))
+
+			orphans, err := a.Orphans(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(BeEmpty())
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(BeEmpty())
+		})
+	})
+
+	Context("reset", func() {
+		var rootNetID, natNetID string
+
+		BeforeEach(func() {
+			rootNetID = hns.MockHNSNetwork(fakeHNS, common.RootNetworkName, "Ethernet0", "", "")
+			natNetID = hns.MockHNSNetwork(fakeHNS, "nat", "Ethernet0", "172.16.0.0/24",
+				"172.16.0.1")
+		})
+
+		networkIDs := func() []string {
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			var ids []string
+			for _, net := range nets {
+				ids = append(ids, net.Id)
+			}
+			return ids
+		}
+
+		It("lists what would be removed in dry run", func() {
+			removed, err := a.Reset(ctx, true, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed.Networks).To(HaveLen(2))
+			Expect(removed.Endpoints).To(HaveLen(1))
+			Expect(removed.Endpoints[0].ID).To(Equal(endpointID))
+			Expect(removed.Endpoints[0].Tenant).To(Equal(tenantName))
+			Expect(removed.Endpoints[0].ContrailUUID).ToNot(BeEmpty())
+
+			Expect(networkIDs()).To(HaveLen(3))
+			_, err = fakeController.GetInstance(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes Contrail networks, endpoints and instances only", func() {
+			removed, err := a.Reset(ctx, false, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed.Networks).To(HaveLen(1))
+			Expect(removed.Networks[0].Tenant).To(Equal(tenantName))
+			Expect(removed.Networks[0].Name).To(Equal(networkName))
+
+			Expect(networkIDs()).To(ConsistOf(rootNetID, natNetID))
+			_, err = fakeController.GetInstance(ctx, endpointID)
+			Expect(err).To(HaveOccurred())
+			_, err = fakeController.GetNetwork(ctx, common.DomainName, tenantName, networkName)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes the root network when requested", func() {
+			_, err := a.Reset(ctx, true, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(networkIDs()).To(ConsistOf(natNetID))
+		})
+	})
+
+	Context("run as subcommand", func() {
+		var out *bytes.Buffer
+
+		BeforeEach(func() {
+			out = &bytes.Buffer{}
+		})
+
+		It("prints table of networks", func() {
+			Expect(a.Run(ctx, []string{"networks"}, out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("TENANT"))
+			Expect(out.String()).To(ContainSubstring(networkName))
+		})
+
+		It("prints endpoints as JSON", func() {
+			Expect(a.Run(ctx, []string{"endpoints", "-json"}, out)).To(Succeed())
+			var endpoints []Endpoint
+			Expect(json.Unmarshal(out.Bytes(), &endpoints)).To(Succeed())
+			Expect(endpoints).To(HaveLen(1))
+		})
+
+		It("requires orphans to clean up", func() {
+			Expect(a.Run(ctx, []string{"cleanup"}, out)).ToNot(Succeed())
+			Expect(a.Run(ctx, []string{"cleanup", "-all", endpointID}, out)).ToNot(Succeed())
+		})
+
+		It("rejects unknown subcommands", func() {
+			Expect(a.Run(ctx, []string{"frobnicate"}, out)).ToNot(Succeed())
+		})
+	})
+})
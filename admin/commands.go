@@ -0,0 +1,165 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Usage describes the subcommands.
+const Usage = `Subcommands:
+  networks                      list Contrail networks in docker, HNS and Contrail
+  endpoints                     list endpoints of Contrail networks
+  orphans                       list HNS networks and endpoints that docker doesn't know about
+  cleanup [-dry-run] [-grace D] -all|ID...
+                                remove selected orphans, by HNS ID or name, that remain
+                                orphans for duration D (30s by default)
+  show ENDPOINT                 show details of endpoint, by docker or HNS ID
+  reset [-dry-run] [-root]      remove all Contrail HNS networks, their endpoints and their
+                                Contrail instances, and the root network with -root
+Each of them accepts -json flag.
+`
+
+// IsCommand tells whether name is one of the subcommands.
+func IsCommand(name string) bool {
+	switch name {
+	case "networks", "endpoints", "orphans", "cleanup", "show", "reset":
+		return true
+	}
+	return false
+}
+
+// Run runs the subcommand given as the first argument and writes its result to out.
+func (a *Admin) Run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || !IsCommand(args[0]) {
+		return fmt.Errorf("Unknown subcommand %q\n%s", strings.Join(args, " "), Usage)
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(out)
+	asJSON := flags.Bool("json", false, "print result as JSON")
+	dryRun := flags.Bool("dry-run", false, "only list what would be removed")
+	all := flags.Bool("all", false, "remove all orphans")
+	withRoot := flags.Bool("root", false, "remove the root network as well")
+	grace := flags.Duration("grace", DefaultOrphanGracePeriod,
+		"how long objects have to remain orphans before cleanup removes them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var result interface{}
+	var err error
+	switch args[0] {
+	case "networks":
+		result, err = a.Networks(ctx)
+	case "endpoints":
+		result, err = a.Endpoints(ctx)
+	case "orphans":
+		result, err = a.Orphans(ctx)
+	case "cleanup":
+		if *all == (flags.NArg() > 0) {
+			return fmt.Errorf("cleanup requires either -all or IDs of orphans")
+		}
+		a.OrphanGracePeriod = *grace
+		result, err = a.Cleanup(ctx, flags.Args(), *all, *dryRun)
+	case "show":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("show requires ID of an endpoint")
+		}
+		result, err = a.Show(ctx, flags.Arg(0))
+	case "reset":
+		result, err = a.Reset(ctx, *withRoot, *dryRun)
+	}
+	// Cleanup and reset return what they managed to remove even if they fail, so it's printed
+	// anyway.
+	if err != nil && args[0] != "cleanup" && args[0] != "reset" {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(result); encodeErr != nil {
+			return encodeErr
+		}
+		return err
+	}
+	if printErr := printText(out, result); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// orDash makes empty values visible in tables.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func printText(out io.Writer, result interface{}) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	switch result := result.(type) {
+	case []Network:
+		fmt.Fprintln(w, "DOMAIN\tTENANT\tNETWORK\tDOCKER ID\tDOCKER NAME\tHNS ID\tCONTRAIL UUID")
+		for _, net := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", net.Domain, net.Tenant, net.Name,
+				orDash(net.DockerID), orDash(net.DockerName), orDash(net.HNSID),
+				orDash(net.ContrailUUID))
+		}
+	case []Endpoint:
+		fmt.Fprintln(w,
+			"ENDPOINT\tDOMAIN\tTENANT\tNETWORK\tCONTAINER\tIP\tHNS ID\tCONTRAIL UUID")
+		for _, ep := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ep.ID, ep.Domain, ep.Tenant,
+				ep.Network, orDash(ep.ContainerName), orDash(ep.IPAddress), orDash(ep.HNSID),
+				orDash(ep.ContrailUUID))
+		}
+	case []Orphan:
+		fmt.Fprintln(w, "KIND\tNAME\tHNS ID\tREASON")
+		for _, orphan := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orphan.Kind, orphan.Name, orphan.HNSID,
+				orphan.Reason)
+		}
+	case *Removed:
+		if result == nil {
+			break
+		}
+		fmt.Fprintln(w, "KIND\tNAME\tHNS ID\tCONTRAIL UUID")
+		for _, ep := range result.Endpoints {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", OrphanEndpoint, ep.ID, ep.HNSID,
+				orDash(ep.ContrailUUID))
+		}
+		for _, net := range result.Networks {
+			name := net.Name
+			if net.Tenant != "" {
+				name = fmt.Sprintf("%s/%s/%s", net.Domain, net.Tenant, net.Name)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\n", OrphanNetwork, name, net.HNSID)
+		}
+	case *Endpoint:
+		fields := []struct{ name, value string }{
+			{"Endpoint", result.ID},
+			{"Domain", result.Domain},
+			{"Tenant", result.Tenant},
+			{"Network", result.Network},
+			{"Docker network", result.DockerNetworkID},
+			{"Container ID", result.ContainerID},
+			{"Container name", result.ContainerName},
+			{"HNS ID", result.HNSID},
+			{"IP address", result.IPAddress},
+			{"MAC address", result.MacAddress},
+			{"Gateway", result.GatewayAddress},
+			{"Contrail instance", result.ContrailUUID},
+			{"Contrail interfaces", strings.Join(result.ContrailInterfaceUUIDs, ", ")},
+		}
+		for _, field := range fields {
+			fmt.Fprintf(w, "%s:\t%s\n", field.name, orDash(field.value))
+		}
+	}
+	return w.Flush()
+}
@@ -29,4 +29,6 @@ cd C:\go_workspace\src\github.com\codilime\contrail-windows-docker
 
 Start-Transcript -path C:\testresults.txt
 C:\go_workspace\bin\ginkgo.exe -r .
+# Specs that use in-memory fakes instead of HNS, docker and Contrail are a separate suite.
+C:\go_workspace\bin\ginkgo.exe -tags fakes controller driver hns hnsManager
 Stop-Transcript
@@ -3,29 +3,43 @@ package common
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
 const (
-	// DomainName specifies domain name in Contrail
+	// DomainName is the default domain in Contrail. Networks created before the driver supported
+	// other domains belong to it.
 	DomainName = "default-domain"
 
-	// DriverName is name of the driver that is to be specified during docker network creation
+	// DriverName is default name of the driver that is to be specified during docker network
+	// creation
 	DriverName = "Contrail"
 
 	// HNSNetworkPrefix is a prefix given too all HNS network names managed by the driver
 	HNSNetworkPrefix = "Contrail"
 
-	// RootNetworkName is a name of root HNS network created solely for the purpose of
+	// RootNetworkName is default name of root HNS network created solely for the purpose of
 	// having a virtual switch
 	RootNetworkName = "ContrailRootNetwork"
 )
 
 // PluginSpecDir returns path to directory where docker daemon looks for plugin spec files.
 func PluginSpecDir() string {
+	if runtime.GOOS != "windows" {
+		return "/etc/docker/plugins"
+	}
 	return filepath.Join(os.Getenv("programdata"), "docker", "plugins")
 }
 
-// PluginSpecFilePath returns path to plugin spec file.
-func PluginSpecFilePath() string {
-	return filepath.Join(PluginSpecDir(), DriverName+".spec")
+// PluginSocketPath returns default path of Unix socket of plugin with given name.
+func PluginSocketPath(pluginName string) string {
+	if runtime.GOOS != "windows" {
+		return filepath.Join("/run/docker/plugins", pluginName+".sock")
+	}
+	return filepath.Join(PluginSpecDir(), pluginName+".sock")
+}
+
+// PluginSpecFilePath returns path to spec file of plugin with given name.
+func PluginSpecFilePath(pluginName string) string {
+	return filepath.Join(PluginSpecDir(), pluginName+".spec")
 }
@@ -0,0 +1,726 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds settings of the driver daemon. They are read from a YAML file, e.g.:
+//
+//	pluginName: Contrail
+//	adapter: Ethernet0
+//	defaultDomain: default-domain
+//	listener:
+//	  type: tcp
+//	  address: 10.7.0.10:9275
+//	  readyTimeout: 5s
+//	  tls:
+//	    certFile: C:\ProgramData\Contrail\plugin.pem
+//	    keyFile: C:\ProgramData\Contrail\plugin-key.pem
+//	    clientCAFile: C:\ProgramData\Contrail\docker-ca.pem
+//	  specTLS:
+//	    caFile: C:\ProgramData\Contrail\ca.pem
+//	    certFile: C:\ProgramData\Contrail\docker.pem
+//	    keyFile: C:\ProgramData\Contrail\docker-key.pem
+//	controller:
+//	  endpoints: [10.7.0.54, 10.7.0.55, 10.7.0.56]
+//	  port: 8082
+//	  retry:
+//	    maxAttempts: 4
+//	    callTimeout: 30s
+//	  https: true
+//	  tls:
+//	    caFile: C:\ProgramData\Contrail\ca.pem
+//	keystone:
+//	  authURL: https://10.7.0.54:5000/v2.0
+//	  username: admin
+//	  tenantName: admin
+//	  password: secret123
+//	  tokenRefreshMargin: 5m
+//	docker:
+//	  host: tcp://127.0.0.1:2376
+//	  apiVersion: "1.24"
+//	  tlsCACert: C:\ProgramData\docker\certs.d\ca.pem
+//	  tlsCert: C:\ProgramData\docker\certs.d\cert.pem
+//	  tlsKey: C:\ProgramData\docker\certs.d\key.pem
+//	  tlsVerify: true
+//	log:
+//	  level: debug
+//	  format: json
+//	  file: C:\ProgramData\Contrail\var\log\contrail-windows-docker.log
+//	  maxSizeMB: 100
+//	  maxBackups: 5
+//	metrics:
+//	  address: 127.0.0.1:9273
+//	health:
+//	  address: 127.0.0.1:9274
+//	hns:
+//	  networkReadyTimeout: 10s
+//	  mode: overlay
+//	  managementIP: 10.7.0.10
+//	  sourceMac: 00-15-5D-01-02-03
+//	  macPools:
+//	  - start: 00-15-5D-10-00-00
+//	    end: 00-15-5D-10-FF-FF
+//	rootNetwork:
+//	  name: ContrailRootNetwork
+//	  type: transparent
+//	  adapter: Ethernet1
+//	  subnet: 0.0.0.0/24
+//	  gateway: 0.0.0.0
+//	features:
+//	  createRootNetwork: true
+//	  deleteContrailInstances: true
+//	policy:
+//	  requiredLabel: com.example.contrail=allowed
+//	  tenants:
+//	  - name: admin
+//	    networks: [web-*, db]
+//	  - domain: k8s
+//	    name: default
+//	shutdownTimeout: 30s
+//
+// Settings missing from the file keep their default values. Environment variables and command
+// line flags override settings from the file.
+type Config struct {
+	// PluginName is the name of the driver in docker. It's also used to name the named pipe
+	// and the plugin spec file.
+	PluginName string `yaml:"pluginName"`
+	// Adapter is the physical network adapter that HNS switches are connected to.
+	Adapter string `yaml:"adapter"`
+	// DefaultDomain is the Contrail domain of networks created without the "domain" option.
+	DefaultDomain string            `yaml:"defaultDomain"`
+	Listener      ListenerConfig    `yaml:"listener"`
+	Controller    ControllerConfig  `yaml:"controller"`
+	Keystone      KeystoneConfig    `yaml:"keystone"`
+	Docker        DockerConfig      `yaml:"docker"`
+	Log           LogConfig         `yaml:"log"`
+	Metrics       MetricsConfig     `yaml:"metrics"`
+	Health        HealthConfig      `yaml:"health"`
+	HNS           HNSConfig         `yaml:"hns"`
+	RootNetwork   RootNetworkConfig `yaml:"rootNetwork"`
+	Features      FeaturesConfig    `yaml:"features"`
+	Policy        PolicyConfig      `yaml:"policy"`
+	// ShutdownTimeout limits how long the driver waits for plugin requests in flight to finish
+	// when it's interrupted. Stopping the Windows service doesn't wait for them.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// ControllerConfig specifies Contrail config API endpoints.
+type ControllerConfig struct {
+	IP   string `yaml:"ip"`
+	Port int    `yaml:"port"`
+	// Endpoints lists addresses of Contrail config API nodes, as "host" or "host:port". Port is
+	// used for addresses without one. If the list is empty, IP is the only endpoint.
+	Endpoints []string `yaml:"endpoints"`
+	// HealthCheckInterval is how often endpoints are checked when there's more than one.
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	Retry               RetryConfig   `yaml:"retry"`
+	// HTTPS makes the driver connect to Contrail API over TLS.
+	HTTPS bool      `yaml:"https"`
+	TLS   TLSConfig `yaml:"tls"`
+}
+
+// RetryConfig describes how failed Contrail API calls are retried. Connection errors and timeouts
+// are always retryable.
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt, so 1 disables retries.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Backoff starts at InitialBackoff and doubles after each attempt, up to MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	// Jitter is the fraction of backoff that is randomized, between 0 and 1.
+	Jitter float64 `yaml:"jitter"`
+	// CallTimeout limits a single attempt of an API call. Zero means no limit.
+	CallTimeout time.Duration `yaml:"callTimeout"`
+	// RetryableStatusCodes lists HTTP statuses of Contrail API responses that are retried.
+	RetryableStatusCodes []int `yaml:"retryableStatusCodes"`
+}
+
+// Addresses returns "host:port" addresses of all Contrail config API endpoints.
+func (c *ControllerConfig) Addresses() []string {
+	if len(c.Endpoints) == 0 {
+		return []string{net.JoinHostPort(c.IP, strconv.Itoa(c.Port))}
+	}
+	var addresses []string
+	for _, endpoint := range c.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			endpoint = net.JoinHostPort(endpoint, strconv.Itoa(c.Port))
+		}
+		addresses = append(addresses, endpoint)
+	}
+	return addresses
+}
+
+// KeystoneConfig holds credentials used to authenticate to Contrail API. Each of them can be
+// overriden by the corresponding OS_* environment variable.
+type KeystoneConfig struct {
+	AuthURL string `yaml:"authURL"`
+	// IdentityAPIVersion is either "2.0" or "3". If it's empty, v3 is used when auth URL ends
+	// with "/v3".
+	IdentityAPIVersion string `yaml:"identityAPIVersion"`
+	Username           string `yaml:"username"`
+	// TenantName is project name in identity API v3. OS_PROJECT_NAME overrides it too.
+	TenantName string `yaml:"tenantName"`
+	Password   string `yaml:"password"`
+	Token      string `yaml:"token"`
+
+	// Settings from here to applicationCredentialSecret are used only by identity API v3.
+	UserDomainName              string `yaml:"userDomainName"`
+	ProjectDomainName           string `yaml:"projectDomainName"`
+	ApplicationCredentialID     string `yaml:"applicationCredentialID"`
+	ApplicationCredentialName   string `yaml:"applicationCredentialName"`
+	ApplicationCredentialSecret string `yaml:"applicationCredentialSecret"`
+
+	// TokenRefreshMargin is how long before expiry the token is replaced with a new one, e.g.
+	// "5m". It applies to both identity API versions. Tokens that Contrail API rejects earlier
+	// are replaced right away.
+	TokenRefreshMargin time.Duration `yaml:"tokenRefreshMargin"`
+
+	// TLS is used when auth URL is an https one. OS_CACERT, OS_CERT and OS_KEY override its files.
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// DockerConfig specifies how to connect to docker daemon. If it's empty, DOCKER_HOST,
+// DOCKER_API_VERSION, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY environment variables are used,
+// like in docker CLI.
+type DockerConfig struct {
+	// Host is docker daemon address, e.g. npipe:////./pipe/docker_engine or
+	// tcp://10.0.0.1:2376.
+	Host       string `yaml:"host"`
+	APIVersion string `yaml:"apiVersion"`
+
+	// TLS is enabled if any of the following is set.
+	TLSCACertPath string `yaml:"tlsCACert"`
+	TLSCertPath   string `yaml:"tlsCert"`
+	TLSKeyPath    string `yaml:"tlsKey"`
+	// TLSVerify enables verification of docker daemon certificate against system CAs. It's
+	// always verified against TLSCACertPath if it's set.
+	TLSVerify bool `yaml:"tlsVerify"`
+}
+
+// ListenerConfig specifies where the driver listens for network plugin requests from docker
+// daemon. The driver writes a spec file that points docker to the listener.
+type ListenerConfig struct {
+	// Type is "npipe", "tcp" or "unix".
+	Type string `yaml:"type"`
+	// Address is path of the named pipe, "host:port" of TCP listener or path of Unix socket.
+	// Named pipe and Unix socket are named after the plugin if it's empty.
+	Address string `yaml:"address"`
+	// TLS makes TCP listener serve over TLS if its certificate is set.
+	TLS ListenerTLSConfig `yaml:"tls"`
+	// SpecTLS is written to the spec file of a TLS listener. Docker daemon uses it to verify
+	// the driver and to authenticate itself.
+	SpecTLS TLSConfig `yaml:"specTLS"`
+	// ReadyTimeout limits how long the driver waits on startup for the listener to accept
+	// connections.
+	ReadyTimeout time.Duration `yaml:"readyTimeout"`
+}
+
+type LogConfig struct {
+	// Level is one of logrus levels: debug, info, warning, error, fatal or panic.
+	Level string `yaml:"level"`
+	// Format is either "text" or "json".
+	Format string `yaml:"format"`
+	// File is path of the log file. Logs are written to stderr if it's empty.
+	File string `yaml:"file"`
+	// The file is rotated when it grows over MaxSizeMB megabytes. At most MaxBackups rotated
+	// files are kept.
+	MaxSizeMB  int `yaml:"maxSizeMB"`
+	MaxBackups int `yaml:"maxBackups"`
+}
+
+// MetricsConfig configures HTTP listener that serves metrics of the driver in Prometheus text
+// format on /metrics.
+type MetricsConfig struct {
+	// Address is TCP address to listen on, e.g. "127.0.0.1:9273". Metrics aren't served if it's
+	// empty.
+	Address string `yaml:"address"`
+}
+
+// HealthConfig configures HTTP listener that serves liveness of the driver on /healthz and its
+// readiness on /readyz.
+type HealthConfig struct {
+	// Address is TCP address to listen on, e.g. "127.0.0.1:9274". Health endpoints aren't
+	// served if it's empty.
+	Address string `yaml:"address"`
+	// CheckTimeout limits time of a single check, after which it's reported as failing.
+	CheckTimeout time.Duration `yaml:"checkTimeout"`
+}
+
+// HNSConfig configures how the driver uses HNS.
+type HNSConfig struct {
+	// NetworkReadyTimeout limits how long the driver waits for a new HNS network to get its
+	// network adapter. Connections of the host may fail until it does.
+	NetworkReadyTimeout time.Duration `yaml:"networkReadyTimeout"`
+	// Mode is the mode of HNS networks of Contrail networks created without the "mode" option:
+	// transparent, l2bridge, l2tunnel or overlay.
+	Mode string `yaml:"mode"`
+	// ManagementIP is the address of the host on the adapter. HNS networks in modes other than
+	// transparent keep it for the host, and overlay endpoints use it as their provider address.
+	// It's required in overlay mode.
+	ManagementIP string `yaml:"managementIP"`
+	// SourceMac is the MAC address that HNS networks in modes other than transparent use for
+	// traffic of the host, e.g. "00-15-5D-01-02-03".
+	SourceMac string `yaml:"sourceMac"`
+	// MacPools are ranges of MAC addresses of endpoints in modes other than transparent.
+	MacPools []MacPoolConfig `yaml:"macPools"`
+}
+
+// MacPoolConfig is a range of MAC addresses, e.g. from "00-15-5D-10-00-00" to
+// "00-15-5D-10-FF-FF".
+type MacPoolConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// RootNetworkConfig describes HNS network that is created solely for the purpose of having
+// a virtual switch on the adapter. An existing root network that doesn't match it is recreated on
+// startup, unless it has endpoints.
+type RootNetworkConfig struct {
+	Name string `yaml:"name"`
+	// Type is the HNS network type: transparent, l2bridge or l2tunnel.
+	Type string `yaml:"type"`
+	// Adapter is the network adapter of the virtual switch. The driver's adapter is used if it's
+	// empty.
+	Adapter string `yaml:"adapter"`
+	Subnet  string `yaml:"subnet"`
+	Gateway string `yaml:"gateway"`
+}
+
+// RootNetworkAdapter returns the network adapter that the root network should be bound to.
+func (c *Config) RootNetworkAdapter() string {
+	if c.RootNetwork.Adapter != "" {
+		return c.RootNetwork.Adapter
+	}
+	return c.Adapter
+}
+
+// FeaturesConfig toggles optional behaviour of the driver.
+type FeaturesConfig struct {
+	// CreateRootNetwork makes the driver create the root network on startup if it's missing.
+	CreateRootNetwork bool `yaml:"createRootNetwork"`
+	// DeleteContrailInstances makes the driver remove Contrail virtual machine, together with
+	// its interfaces and instance IPs, when docker endpoint is deleted.
+	DeleteContrailInstances bool `yaml:"deleteContrailInstances"`
+}
+
+// PolicyConfig restricts Contrail networks that users of docker can bind the host to. Any network
+// that the driver's credentials reach is allowed if Tenants is empty.
+type PolicyConfig struct {
+	// Tenants lists Contrail projects that networks can be created in.
+	Tenants []TenantPolicy `yaml:"tenants"`
+	// RequiredLabel is a docker label, "key" or "key=value", that docker networks must have for
+	// containers to join them. Docker doesn't pass labels to network drivers, so it's checked
+	// when endpoints are created.
+	RequiredLabel string `yaml:"requiredLabel"`
+}
+
+// TenantPolicy allows networks of a single Contrail project.
+type TenantPolicy struct {
+	// Domain of the project. The default domain is used if it's empty.
+	Domain string `yaml:"domain"`
+	Name   string `yaml:"name"`
+	// Networks lists patterns of allowed network names, in the syntax of path.Match, e.g.
+	// "web-*". All networks of the project are allowed if it's empty.
+	Networks []string `yaml:"networks"`
+}
+
+// RequiredLabelKeyValue returns key and value of the label that docker networks must have. The
+// value is empty if the label may have any value.
+func (c *PolicyConfig) RequiredLabelKeyValue() (string, string) {
+	parts := strings.SplitN(c.RequiredLabel, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+const (
+	ListenerNamedPipe = "npipe"
+	ListenerTCP       = "tcp"
+	ListenerUnix      = "unix"
+)
+
+// HNS network types that the root network can have. All of them bind a virtual switch to the
+// adapter.
+const (
+	RootNetworkTypeTransparent = "transparent"
+	RootNetworkTypeL2Bridge    = "l2bridge"
+	RootNetworkTypeL2Tunnel    = "l2tunnel"
+)
+
+// Modes of HNS networks of Contrail networks.
+const (
+	NetworkModeTransparent = "transparent"
+	NetworkModeL2Bridge    = "l2bridge"
+	NetworkModeL2Tunnel    = "l2tunnel"
+	NetworkModeOverlay     = "overlay"
+)
+
+// IsNetworkMode tells whether mode is one of the modes of HNS networks of Contrail networks.
+func IsNetworkMode(mode string) bool {
+	switch mode {
+	case NetworkModeTransparent, NetworkModeL2Bridge, NetworkModeL2Tunnel, NetworkModeOverlay:
+		return true
+	}
+	return false
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// DefaultConfig returns configuration that is used when no config file is given.
+func DefaultConfig() *Config {
+	return &Config{
+		PluginName:    DriverName,
+		Adapter:       "Ethernet0",
+		DefaultDomain: DomainName,
+		Listener: ListenerConfig{
+			Type:         ListenerNamedPipe,
+			ReadyTimeout: 5 * time.Second,
+		},
+		Controller: ControllerConfig{
+			IP:                  "127.0.0.1",
+			Port:                8082,
+			HealthCheckInterval: 10 * time.Second,
+			Retry: RetryConfig{
+				MaxAttempts:          4,
+				InitialBackoff:       500 * time.Millisecond,
+				MaxBackoff:           5 * time.Second,
+				Jitter:               0.2,
+				CallTimeout:          30 * time.Second,
+				RetryableStatusCodes: []int{500, 502, 503, 504},
+			},
+		},
+		Keystone: KeystoneConfig{
+			TokenRefreshMargin: 5 * time.Minute,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Format:     LogFormatText,
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 5 * time.Second,
+		},
+		HNS: HNSConfig{
+			NetworkReadyTimeout: 10 * time.Second,
+			Mode:                NetworkModeTransparent,
+		},
+		RootNetwork: RootNetworkConfig{
+			Name:    RootNetworkName,
+			Type:    RootNetworkTypeTransparent,
+			Subnet:  "0.0.0.0/24",
+			Gateway: "0.0.0.0",
+		},
+		Features: FeaturesConfig{
+			CreateRootNetwork:       true,
+			DeleteContrailInstances: true,
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// LoadFile reads settings from YAML file at given path. Unknown keys are reported as errors, so
+// that typos don't go unnoticed.
+func (c *Config) LoadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not read config file: %v", err)
+	}
+	if err := c.load(content); err != nil {
+		return fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) load(content []byte) error {
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return err
+	}
+	if err := checkKeys(raw, reflect.TypeOf(*c), ""); err != nil {
+		return err
+	}
+	return yaml.Unmarshal(content, c)
+}
+
+// checkKeys returns error if the YAML mapping contains a key that doesn't correspond to any field
+// of the struct, including structs nested in it and in its lists.
+func checkKeys(raw map[interface{}]interface{}, structType reflect.Type, prefix string) error {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fields[strings.Split(field.Tag.Get("yaml"), ",")[0]] = field.Type
+	}
+
+	var keys []string
+	for key := range raw {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fieldType, exists := fields[key]
+		if !exists {
+			return fmt.Errorf("unknown setting %s%s", prefix, key)
+		}
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			if nested, ok := raw[key].(map[interface{}]interface{}); ok {
+				if err := checkKeys(nested, fieldType, prefix+key+"."); err != nil {
+					return err
+				}
+			}
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+			items, _ := raw[key].([]interface{})
+			for i, item := range items {
+				nested, ok := item.(map[interface{}]interface{})
+				if !ok {
+					continue
+				}
+				itemPrefix := fmt.Sprintf("%s%s[%d].", prefix, key, i)
+				if err := checkKeys(nested, fieldType.Elem(), itemPrefix); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFromEnvironment overrides Keystone settings with OS_* environment variables that are set.
+func (c *Config) LoadFromEnvironment() {
+	// OS_PROJECT_NAME comes after OS_TENANT_NAME, so that it wins when both are set.
+	envs := []struct {
+		name    string
+		setting *string
+	}{
+		{"OS_AUTH_URL", &c.Keystone.AuthURL},
+		{"OS_IDENTITY_API_VERSION", &c.Keystone.IdentityAPIVersion},
+		{"OS_USERNAME", &c.Keystone.Username},
+		{"OS_TENANT_NAME", &c.Keystone.TenantName},
+		{"OS_PROJECT_NAME", &c.Keystone.TenantName},
+		{"OS_PASSWORD", &c.Keystone.Password},
+		{"OS_TOKEN", &c.Keystone.Token},
+		{"OS_USER_DOMAIN_NAME", &c.Keystone.UserDomainName},
+		{"OS_PROJECT_DOMAIN_NAME", &c.Keystone.ProjectDomainName},
+		{"OS_APPLICATION_CREDENTIAL_ID", &c.Keystone.ApplicationCredentialID},
+		{"OS_APPLICATION_CREDENTIAL_NAME", &c.Keystone.ApplicationCredentialName},
+		{"OS_APPLICATION_CREDENTIAL_SECRET", &c.Keystone.ApplicationCredentialSecret},
+		{"OS_CACERT", &c.Keystone.TLS.CAFile},
+		{"OS_CERT", &c.Keystone.TLS.CertFile},
+		{"OS_KEY", &c.Keystone.TLS.KeyFile},
+	}
+	for _, env := range envs {
+		if value := os.Getenv(env.name); value != "" {
+			*env.setting = value
+		}
+	}
+}
+
+// Validate checks whether all settings have correct values. The returned error lists all
+// problems that were found.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.PluginName != "", "pluginName must not be empty")
+	check(!strings.ContainsAny(c.PluginName, `/\:`),
+		"pluginName %q must not contain '/', '\\' or ':'", c.PluginName)
+	check(c.Adapter != "", "adapter must not be empty")
+	check(c.DefaultDomain != "", "defaultDomain must not be empty")
+
+	switch c.Listener.Type {
+	case ListenerNamedPipe, ListenerUnix:
+		check(c.Listener.TLS.CertFile == "", "listener.tls can be used only by %s listener",
+			ListenerTCP)
+	case ListenerTCP:
+		_, port, err := net.SplitHostPort(c.Listener.Address)
+		check(err == nil && port != "", "listener.address %q is not a valid TCP address",
+			c.Listener.Address)
+	default:
+		problems = append(problems, fmt.Sprintf("listener.type %q is not one of: %s, %s, %s",
+			c.Listener.Type, ListenerNamedPipe, ListenerTCP, ListenerUnix))
+	}
+	check(c.Listener.ReadyTimeout > 0, "listener.readyTimeout %v must be positive",
+		c.Listener.ReadyTimeout)
+	check(c.Listener.TLS.CertFile == "" || c.Listener.TLS.KeyFile != "",
+		"listener.tls.keyFile must be set together with certFile")
+	check(c.Listener.TLS.KeyFile == "" || c.Listener.TLS.CertFile != "",
+		"listener.tls.certFile must be set together with keyFile")
+	check(c.Listener.TLS.ClientCAFile == "" || c.Listener.TLS.CertFile != "",
+		"listener.tls.clientCAFile requires certFile")
+
+	check(c.Controller.IP != "" || len(c.Controller.Endpoints) != 0,
+		"controller.ip or controller.endpoints must be set")
+	check(c.Controller.Port > 0 && c.Controller.Port <= 65535,
+		"controller.port %d is not a valid port number", c.Controller.Port)
+	for _, address := range c.Controller.Addresses() {
+		host, port, err := net.SplitHostPort(address)
+		portNumber, _ := strconv.Atoi(port)
+		check(err == nil && host != "" && portNumber > 0 && portNumber <= 65535,
+			"controller endpoint %q is not a valid address", address)
+	}
+	check(c.Controller.HealthCheckInterval >= 0,
+		"controller.healthCheckInterval %v must not be negative", c.Controller.HealthCheckInterval)
+	retry := &c.Controller.Retry
+	check(retry.MaxAttempts >= 1, "controller.retry.maxAttempts must be at least 1")
+	check(retry.InitialBackoff >= 0 && retry.MaxBackoff >= retry.InitialBackoff,
+		"controller.retry.maxBackoff must not be lower than non-negative initialBackoff")
+	check(retry.Jitter >= 0 && retry.Jitter <= 1,
+		"controller.retry.jitter %v is not between 0 and 1", retry.Jitter)
+	check(retry.CallTimeout >= 0, "controller.retry.callTimeout %v must not be negative",
+		retry.CallTimeout)
+	for _, status := range retry.RetryableStatusCodes {
+		check(status >= 100 && status <= 599,
+			"controller.retry.retryableStatusCodes contains invalid status %d", status)
+	}
+	check(c.Controller.TLS.CertFile == "" || c.Controller.TLS.KeyFile != "",
+		"controller.tls.keyFile must be set together with certFile")
+	check(c.Controller.TLS.KeyFile == "" || c.Controller.TLS.CertFile != "",
+		"controller.tls.certFile must be set together with keyFile")
+
+	if c.Keystone.AuthURL == "" {
+		problems = append(problems, "keystone.authURL must be set (or OS_AUTH_URL)")
+	} else {
+		authURL, err := url.Parse(c.Keystone.AuthURL)
+		check(err == nil && (authURL.Scheme == "http" || authURL.Scheme == "https"),
+			"keystone.authURL %q is not a valid http(s) URL", c.Keystone.AuthURL)
+	}
+	switch c.Keystone.IdentityAPIVersion {
+	case "", "2", "2.0", "3":
+	default:
+		problems = append(problems, fmt.Sprintf(
+			"keystone.identityAPIVersion %q is not one of: 2.0, 3", c.Keystone.IdentityAPIVersion))
+	}
+	check(c.Keystone.TLS.CertFile == "" || c.Keystone.TLS.KeyFile != "",
+		"keystone.tls.keyFile must be set together with certFile")
+	check(c.Keystone.TLS.KeyFile == "" || c.Keystone.TLS.CertFile != "",
+		"keystone.tls.certFile must be set together with keyFile")
+	check(c.Docker.TLSCertPath == "" || c.Docker.TLSKeyPath != "",
+		"docker.tlsKey must be set together with tlsCert")
+	check(c.Docker.TLSKeyPath == "" || c.Docker.TLSCertPath != "",
+		"docker.tlsCert must be set together with tlsKey")
+
+	check(c.Keystone.TokenRefreshMargin >= 0, "keystone.tokenRefreshMargin %v must not be negative",
+		c.Keystone.TokenRefreshMargin)
+
+	_, err := log.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level %q is not one of: debug, info, warning, error, fatal, panic",
+		c.Log.Level)
+	check(c.Log.Format == LogFormatText || c.Log.Format == LogFormatJSON,
+		"log.format %q is not one of: %s, %s", c.Log.Format, LogFormatText, LogFormatJSON)
+	check(c.Log.MaxSizeMB > 0, "log.maxSizeMB must be positive")
+	check(c.Log.MaxBackups >= 0, "log.maxBackups must not be negative")
+
+	if c.Metrics.Address != "" {
+		_, port, err := net.SplitHostPort(c.Metrics.Address)
+		check(err == nil && port != "", "metrics.address %q is not a valid TCP address",
+			c.Metrics.Address)
+	}
+
+	if c.Health.Address != "" {
+		_, port, err := net.SplitHostPort(c.Health.Address)
+		check(err == nil && port != "", "health.address %q is not a valid TCP address",
+			c.Health.Address)
+	}
+	check(c.Health.CheckTimeout > 0, "health.checkTimeout %v must be positive",
+		c.Health.CheckTimeout)
+
+	check(c.HNS.NetworkReadyTimeout > 0, "hns.networkReadyTimeout %v must be positive",
+		c.HNS.NetworkReadyTimeout)
+	check(IsNetworkMode(c.HNS.Mode), "hns.mode %q is not one of: %s, %s, %s, %s", c.HNS.Mode,
+		NetworkModeTransparent, NetworkModeL2Bridge, NetworkModeL2Tunnel, NetworkModeOverlay)
+	check(c.HNS.Mode != NetworkModeOverlay || c.HNS.ManagementIP != "",
+		"hns.managementIP must be set in %s mode", NetworkModeOverlay)
+	if c.HNS.ManagementIP != "" {
+		check(net.ParseIP(c.HNS.ManagementIP) != nil,
+			"hns.managementIP %q is not a valid IP address", c.HNS.ManagementIP)
+	}
+	if c.HNS.SourceMac != "" {
+		_, err := net.ParseMAC(c.HNS.SourceMac)
+		check(err == nil, "hns.sourceMac %q is not a valid MAC address", c.HNS.SourceMac)
+	}
+	for i, pool := range c.HNS.MacPools {
+		_, startErr := net.ParseMAC(pool.Start)
+		_, endErr := net.ParseMAC(pool.End)
+		check(startErr == nil && endErr == nil,
+			"hns.macPools[%d] is not a range of valid MAC addresses", i)
+	}
+
+	check(c.RootNetwork.Name != "", "rootNetwork.name must not be empty")
+	switch c.RootNetwork.Type {
+	case RootNetworkTypeTransparent, RootNetworkTypeL2Bridge, RootNetworkTypeL2Tunnel:
+	default:
+		check(false, "rootNetwork.type %q is not one of: %s, %s, %s", c.RootNetwork.Type,
+			RootNetworkTypeTransparent, RootNetworkTypeL2Bridge, RootNetworkTypeL2Tunnel)
+	}
+	_, _, err = net.ParseCIDR(c.RootNetwork.Subnet)
+	check(err == nil, "rootNetwork.subnet %q is not a valid CIDR", c.RootNetwork.Subnet)
+	check(net.ParseIP(c.RootNetwork.Gateway) != nil,
+		"rootNetwork.gateway %q is not a valid IP address", c.RootNetwork.Gateway)
+
+	for i, tenant := range c.Policy.Tenants {
+		check(tenant.Name != "", "policy.tenants[%d].name must not be empty", i)
+		for _, pattern := range tenant.Networks {
+			_, err := path.Match(pattern, "")
+			check(err == nil, "policy.tenants[%d].networks contains invalid pattern %q", i,
+				pattern)
+		}
+	}
+	if c.Policy.RequiredLabel != "" {
+		key, _ := c.Policy.RequiredLabelKeyValue()
+		check(key != "", "policy.requiredLabel %q has no key", c.Policy.RequiredLabel)
+	}
+
+	check(c.ShutdownTimeout > 0, "shutdownTimeout %v must be positive", c.ShutdownTimeout)
+
+	if len(problems) != 0 {
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ConfigureLogging applies log settings to the standard logrus logger. The log file, if any,
+// stays open until the process exits.
+func (c *LogConfig) ConfigureLogging() error {
+	level, err := log.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	if c.File != "" {
+		file, err := OpenRotatingFile(c.File, int64(c.MaxSizeMB)<<20, c.MaxBackups)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(file)
+	}
+	log.SetLevel(level)
+	if c.Format == LogFormatJSON {
+		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		log.SetFormatter(&log.TextFormatter{})
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestCommon(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("common_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Common test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Config", func() {
+
+	var cfg *Config
+
+	BeforeEach(func() {
+		cfg = DefaultConfig()
+		cfg.Keystone.AuthURL = "http://10.7.0.54:5000/v2.0"
+	})
+
+	Context("loaded from file", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "config")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		writeConfig := func(content string) string {
+			path := filepath.Join(dir, "config.yaml")
+			err := ioutil.WriteFile(path, []byte(content), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			return path
+		}
+
+		It("overrides only settings present in the file", func() {
+			path := writeConfig(`
+adapter: Ethernet1
+defaultDomain: k8s
+controller:
+  ip: 10.0.0.1
+keystone:
+  username: admin
+  tokenRefreshMargin: 2m
+log:
+  format: json
+features:
+  createRootNetwork: false
+`)
+			err := cfg.LoadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cfg.Adapter).To(Equal("Ethernet1"))
+			Expect(cfg.DefaultDomain).To(Equal("k8s"))
+			Expect(cfg.Controller.IP).To(Equal("10.0.0.1"))
+			Expect(cfg.Controller.Port).To(Equal(8082))
+			Expect(cfg.Keystone.Username).To(Equal("admin"))
+			Expect(cfg.Keystone.AuthURL).To(Equal("http://10.7.0.54:5000/v2.0"))
+			Expect(cfg.Keystone.TokenRefreshMargin).To(Equal(2 * time.Minute))
+			Expect(cfg.Log.Format).To(Equal(LogFormatJSON))
+			Expect(cfg.Log.Level).To(Equal("info"))
+			Expect(cfg.Features.CreateRootNetwork).To(BeFalse())
+			Expect(cfg.Features.DeleteContrailInstances).To(BeTrue())
+			Expect(cfg.PluginName).To(Equal(DriverName))
+		})
+
+		It("loads docker connection settings", func() {
+			path := writeConfig(`
+docker:
+  host: tcp://10.0.0.1:2376
+  tlsCACert: ca.pem
+  tlsVerify: true
+`)
+			Expect(cfg.LoadFile(path)).To(Succeed())
+			Expect(cfg.Docker).To(Equal(DockerConfig{
+				Host:          "tcp://10.0.0.1:2376",
+				TLSCACertPath: "ca.pem",
+				TLSVerify:     true,
+			}))
+		})
+
+		It("returns error on unknown settings", func() {
+			path := writeConfig(`
+controller:
+  addr: 10.0.0.1
+`)
+			err := cfg.LoadFile(path)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("controller.addr"))
+		})
+
+		It("loads host policy", func() {
+			path := writeConfig(`
+policy:
+  requiredLabel: contrail=allowed
+  tenants:
+  - name: admin
+    networks: [web-*]
+  - domain: k8s
+    name: default
+`)
+			Expect(cfg.LoadFile(path)).To(Succeed())
+			Expect(cfg.Policy.Tenants).To(Equal([]TenantPolicy{
+				{Name: "admin", Networks: []string{"web-*"}},
+				{Domain: "k8s", Name: "default"},
+			}))
+			key, value := cfg.Policy.RequiredLabelKeyValue()
+			Expect(key).To(Equal("contrail"))
+			Expect(value).To(Equal("allowed"))
+		})
+
+		It("returns error on unknown settings of list items", func() {
+			path := writeConfig(`
+policy:
+  tenants:
+  - name: admin
+    network: [web-*]
+`)
+			err := cfg.LoadFile(path)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("policy.tenants[0].network"))
+		})
+
+		It("returns error on malformed YAML", func() {
+			path := writeConfig("controller: [")
+			err := cfg.LoadFile(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns error when file doesn't exist", func() {
+			err := cfg.LoadFile(filepath.Join(dir, "nonexisting.yaml"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("loaded from environment", func() {
+		BeforeEach(func() {
+			os.Setenv("OS_USERNAME", "env_user")
+			os.Unsetenv("OS_PASSWORD")
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("OS_USERNAME")
+		})
+
+		It("overrides only variables that are set", func() {
+			cfg.Keystone.Username = "file_user"
+			cfg.Keystone.Password = "file_password"
+			cfg.LoadFromEnvironment()
+			Expect(cfg.Keystone.Username).To(Equal("env_user"))
+			Expect(cfg.Keystone.Password).To(Equal("file_password"))
+		})
+	})
+
+	It("uses controller port for endpoints without one", func() {
+		cfg.Controller.Endpoints = []string{"10.0.0.1", "10.0.0.2:8443"}
+		Expect(cfg.Controller.Addresses()).To(Equal([]string{"10.0.0.1:8082", "10.0.0.2:8443"}))
+	})
+
+	Context("validation", func() {
+		It("accepts default config with Keystone URL", func() {
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("reports all problems", func() {
+			cfg.Controller.Port = 0
+			cfg.Log.Level = "verbose"
+			cfg.RootNetwork.Subnet = "0.0.0.0"
+			err := cfg.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("controller.port"))
+			Expect(err.Error()).To(ContainSubstring("log.level"))
+			Expect(err.Error()).To(ContainSubstring("rootNetwork.subnet"))
+		})
+
+		It("requires Keystone auth URL", func() {
+			cfg.Keystone.AuthURL = ""
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("requires TLS client certificate and key together", func() {
+			cfg.Controller.TLS.CertFile = "client.pem"
+			err := cfg.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("controller.tls.keyFile"))
+		})
+
+		It("requires docker client certificate and key together", func() {
+			cfg.Docker.TLSKeyPath = "key.pem"
+			err := cfg.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("docker.tlsCert"))
+		})
+
+		It("rejects plugin names that can't be used as pipe names", func() {
+			cfg.PluginName = `Contrail\Driver`
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("requires default Contrail domain", func() {
+			cfg.DefaultDomain = ""
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("requires management IP in overlay mode", func() {
+			cfg.HNS.Mode = NetworkModeOverlay
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.HNS.ManagementIP = "10.7.0.10"
+			Expect(cfg.Validate()).To(Succeed())
+			cfg.HNS.Mode = "nat"
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("requires valid MAC addresses of HNS networks", func() {
+			cfg.HNS.SourceMac = "00-15-5D-01-02-03"
+			cfg.HNS.MacPools = []MacPoolConfig{
+				{Start: "00-15-5D-10-00-00", End: "00-15-5D-10-FF-FF"},
+			}
+			Expect(cfg.Validate()).To(Succeed())
+			cfg.HNS.MacPools[0].End = ""
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("rejects invalid host policy", func() {
+			cfg.Policy.Tenants = []TenantPolicy{{Name: "admin", Networks: []string{"web-["}}}
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.Policy.Tenants = []TenantPolicy{{Networks: []string{"web-*"}}}
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.Policy.Tenants = []TenantPolicy{{Name: "admin", Networks: []string{"web-*"}}}
+			Expect(cfg.Validate()).To(Succeed())
+			cfg.Policy.RequiredLabel = "=allowed"
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+
+		It("rejects root network types that don't bind to the adapter", func() {
+			cfg.RootNetwork.Type = "nat"
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.RootNetwork.Type = RootNetworkTypeL2Bridge
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("requires port in metrics address", func() {
+			cfg.Metrics.Address = "127.0.0.1"
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.Metrics.Address = ":9273"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("requires address of TCP listener", func() {
+			cfg.Listener.Type = ListenerTCP
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.Listener.Address = "0.0.0.0:9275"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("allows listener TLS only for TCP listener", func() {
+			cfg.Listener.TLS = ListenerTLSConfig{CertFile: "plugin.pem", KeyFile: "plugin-key.pem"}
+			Expect(cfg.Validate()).ToNot(Succeed())
+			cfg.Listener.Type = ListenerTCP
+			cfg.Listener.Address = "0.0.0.0:9275"
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("rejects unknown listener type", func() {
+			cfg.Listener.Type = "udp"
+			Expect(cfg.Validate()).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// NewRequestID returns a random ID of a plugin request. It ties together log lines of the
+// request.
+func NewRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// WithRequestID returns context of the request with given ID. Logger of the context adds it to
+// every line as "requestID" field.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return WithFields(ctx, log.Fields{"requestID": id})
+}
+
+// RequestID returns ID of the request that the context belongs to, or "" if there's none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithFields returns context whose logger adds given fields to every line, e.g. IDs of objects
+// that the request handles.
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey, Logger(ctx).WithFields(fields))
+}
+
+// Logger returns logger of the context, or the standard logger without any fields.
+func Logger(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(loggerKey).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// RotatingFile is a log file that is rotated when it grows over its maximum size: the file is
+// renamed with ".1" suffix, previous backups are shifted to ".2", ".3" and so on, and those
+// beyond the maximum number of backups are removed.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotatingFile opens log file at given path for appending, creating it if necessary.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p wouldn't fit. A single write is never
+// split between files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		return f.open()
+	}
+	// Backups that don't exist yet are skipped.
+	os.Remove(f.backupPath(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(f.backupPath(i), f.backupPath(i+1))
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
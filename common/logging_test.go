@@ -0,0 +1,155 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request context", func() {
+
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		log.SetOutput(output)
+		log.SetFormatter(&log.JSONFormatter{})
+	})
+
+	AfterEach(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	})
+
+	lastLine := func() map[string]interface{} {
+		var line map[string]interface{}
+		Expect(json.Unmarshal(output.Bytes(), &line)).To(Succeed())
+		return line
+	}
+
+	It("generates distinct request IDs", func() {
+		Expect(NewRequestID()).To(HaveLen(16))
+		Expect(NewRequestID()).ToNot(Equal(NewRequestID()))
+	})
+
+	It("logs request ID and fields of the request", func() {
+		ctx := WithRequestID(context.Background(), "abc")
+		ctx = WithFields(ctx, log.Fields{"networkID": "net1"})
+		ctx = WithFields(ctx, log.Fields{"tenant": "tenant1"})
+		Expect(RequestID(ctx)).To(Equal("abc"))
+
+		Logger(ctx).Infoln("hello")
+		line := lastLine()
+		Expect(line).To(HaveKeyWithValue("msg", "hello"))
+		Expect(line).To(HaveKeyWithValue("requestID", "abc"))
+		Expect(line).To(HaveKeyWithValue("networkID", "net1"))
+		Expect(line).To(HaveKeyWithValue("tenant", "tenant1"))
+	})
+
+	It("doesn't add fields to context it was derived from", func() {
+		ctx := WithRequestID(context.Background(), "abc")
+		_ = WithFields(ctx, log.Fields{"endpointID": "ep1"})
+
+		Logger(ctx).Infoln("hello")
+		Expect(lastLine()).ToNot(HaveKey("endpointID"))
+	})
+
+	It("logs without fields outside of requests", func() {
+		ctx := context.Background()
+		Expect(RequestID(ctx)).To(Equal(""))
+
+		Logger(ctx).Infoln("hello")
+		Expect(lastLine()).ToNot(HaveKey("requestID"))
+	})
+})
+
+var _ = Describe("Rotating log file", func() {
+
+	var dir, path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "logs")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "driver.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(f *RotatingFile, line string) {
+		_, err := f.Write([]byte(line))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	read := func(path string) string {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return string(contents)
+	}
+
+	It("appends to existing file", func() {
+		Expect(ioutil.WriteFile(path, []byte("old\n"), 0644)).To(Succeed())
+		f, err := OpenRotatingFile(path, 100, 1)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		write(f, "new\n")
+		Expect(read(path)).To(Equal("old\nnew\n"))
+	})
+
+	It("rotates file before a write that doesn't fit", func() {
+		f, err := OpenRotatingFile(path, 10, 2)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		write(f, "first\n")
+		write(f, "second\n")
+		write(f, "third\n")
+		Expect(read(path)).To(Equal("third\n"))
+		Expect(read(path + ".1")).To(Equal("second\n"))
+		Expect(read(path + ".2")).To(Equal("first\n"))
+	})
+
+	It("keeps at most maxBackups rotated files", func() {
+		f, err := OpenRotatingFile(path, 10, 1)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		write(f, "first\n")
+		write(f, "second\n")
+		write(f, "third\n")
+		Expect(read(path + ".1")).To(Equal("second\n"))
+		_, err = os.Stat(path + ".2")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("truncates file if backups are disabled", func() {
+		f, err := OpenRotatingFile(path, 10, 0)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		write(f, "first\n")
+		write(f, "second\n")
+		Expect(read(path)).To(Equal("second\n"))
+		_, err = os.Stat(path + ".1")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("doesn't split writes bigger than maximum size", func() {
+		f, err := OpenRotatingFile(path, 4, 1)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		write(f, "a long line\n")
+		Expect(read(path)).To(Equal("a long line\n"))
+	})
+})
@@ -18,6 +18,8 @@ func callPowershell(cmds ...string) error {
 	return exec.Command("powershell", c...).Run()
 }
 
+// HardResetHNS removes all HNS networks, NAT included, and HNS data of the host. Use
+// hnsManager.HNSManager.Cleanup to remove only networks created by the driver.
 func HardResetHNS() error {
 	log.Infoln("Resetting HNS")
 	log.Debugln("Removing NAT")
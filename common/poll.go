@@ -0,0 +1,38 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	pollMinInterval = 10 * time.Millisecond
+	pollMaxInterval = 500 * time.Millisecond
+)
+
+// Poll calls check until it returns nil or timeout passes. The error returned by check tells why
+// the condition isn't met yet; the last one is included in the error returned on timeout.
+// Intervals between calls start short and double up to half a second, so that conditions that
+// are met quickly don't cost much waiting.
+func Poll(timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	interval := pollMinInterval
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return fmt.Errorf("Timed out after %v: %v", timeout, err)
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > pollMaxInterval {
+			interval = pollMaxInterval
+		}
+	}
+}
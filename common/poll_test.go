@@ -0,0 +1,38 @@
+package common
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Polling", func() {
+
+	It("returns as soon as condition is met", func() {
+		calls := 0
+		start := time.Now()
+		err := Poll(time.Second, func() error {
+			calls++
+			if calls < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal(3))
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+	})
+
+	It("times out with the last reason", func() {
+		start := time.Now()
+		err := Poll(100*time.Millisecond, func() error {
+			return errors.New("network has no adapter")
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("network has no adapter"))
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
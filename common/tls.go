@@ -0,0 +1,109 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// TLSConfig specifies how HTTPS servers are verified and how the driver authenticates to them.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CA certificates that server certificates are verified against.
+	// System CA pool is used if it's empty.
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile are PEM files with optional client certificate and its private key.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// InsecureSkipVerify disables verification of server certificates. Use it only for testing.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// ClientConfig builds TLS configuration for HTTPS clients.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA bundle: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Could not parse CA bundle %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("Client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// HTTPTransport returns a new HTTP transport that uses this TLS configuration.
+func (c *TLSConfig) HTTPTransport() (*http.Transport, error) {
+	tlsConfig, err := c.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}, nil
+}
+
+// ListenerTLSConfig specifies certificate that a TLS listener of the driver presents, and how it
+// verifies its clients.
+type ListenerTLSConfig struct {
+	// CertFile and KeyFile are PEM files with certificate of the listener and its private key.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientCAFile is a PEM bundle of CA certificates. If it's set, clients must present
+	// a certificate signed by one of them.
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// Enabled tells whether the listener should serve over TLS.
+func (c *ListenerTLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ServerConfig builds TLS configuration for the listener.
+func (c *ListenerTLSConfig) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load listener certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read client CA bundle: %v", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Could not parse client CA bundle %s", c.ClientCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// keystoneAuthenticator adds Keystone tokens to Contrail API requests and can replace a token
+// that Contrail API has rejected.
+type keystoneAuthenticator interface {
+	contrail.Authenticator
+	Authenticate() error
+	// Reauthenticate obtains a new token, unless the rejected one has already been replaced.
+	Reauthenticate(rejectedToken string) error
+	SetHTTPClient(httpClient *http.Client)
+}
+
+// apiTransport authenticates Contrail API requests. When a request is rejected with
+// 401 Unauthorized, it obtains a new token and sends the request once more.
+type apiTransport struct {
+	base http.RoundTripper
+	auth keystoneAuthenticator
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	first := cloneRequest(req, body)
+	if err := t.auth.AddAuthentication(first); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	logger := common.Logger(req.Context())
+	logger.Warnf("Contrail API rejected Keystone token on %s %s, re-authenticating",
+		req.Method, req.URL.Path)
+	if err := t.auth.Reauthenticate(first.Header.Get("X-Auth-Token")); err != nil {
+		logger.Errorln("Keystone re-authentication failed:", err)
+		return resp, nil
+	}
+
+	retry := cloneRequest(req, body)
+	if err := t.auth.AddAuthentication(retry); err != nil {
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+// readBody reads and closes body of the request, so that copies of the request made by
+// cloneRequest can send it again. Returns nil if the request has no body.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
+
+// cloneRequest returns a copy of the request with its own headers and a fresh reader of body
+// returned by readBody, so that it can be modified and sent again.
+func cloneRequest(req *http.Request, body []byte) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+	if req.Body != nil {
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.ContentLength = int64(len(body))
+	}
+	return clone
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeContrailAPI is a local HTTP stand-in for Contrail config API that serves virtual networks
+// collection. It accepts only tokens from valid set and records tokens of all requests. If status
+// is set, all requests, including ones to API root, fail with it.
+type fakeContrailAPI struct {
+	server *httptest.Server
+
+	mutex  sync.Mutex
+	valid  map[string]bool
+	tokens []string
+	status int
+}
+
+func newFakeContrailAPI() *fakeContrailAPI {
+	api := newUnstartedFakeContrailAPI()
+	api.server.Start()
+	return api
+}
+
+func newUnstartedFakeContrailAPI() *fakeContrailAPI {
+	api := &fakeContrailAPI{valid: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/virtual-networks", api.handleVirtualNetworks)
+	mux.HandleFunc("/", api.handleRoot)
+	api.server = httptest.NewUnstartedServer(mux)
+	return api
+}
+
+func (api *fakeContrailAPI) address() (string, int) {
+	host, port, err := net.SplitHostPort(api.server.Listener.Addr().String())
+	Expect(err).ToNot(HaveOccurred())
+	portNumber, err := strconv.Atoi(port)
+	Expect(err).ToNot(HaveOccurred())
+	return host, portNumber
+}
+
+func (api *fakeContrailAPI) setValidTokens(tokens ...string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.valid = make(map[string]bool)
+	for _, token := range tokens {
+		api.valid[token] = true
+	}
+}
+
+func (api *fakeContrailAPI) receivedTokens() []string {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]string(nil), api.tokens...)
+}
+
+func (api *fakeContrailAPI) setStatus(status int) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.status = status
+}
+
+func (api *fakeContrailAPI) handleRoot(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.status != 0 {
+		w.WriteHeader(api.status)
+		return
+	}
+	w.Write([]byte(`{"links": []}`))
+}
+
+func (api *fakeContrailAPI) handleVirtualNetworks(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	token := r.Header.Get("X-Auth-Token")
+	api.tokens = append(api.tokens, token)
+	if api.status != 0 {
+		w.WriteHeader(api.status)
+		return
+	}
+	if !api.valid[token] {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Authentication required"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Write([]byte(`{"virtual-networks": []}`))
+	case "POST":
+		var msg map[string]map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		net := msg["virtual-network"]
+		fqName := net["fq_name"].([]interface{})
+		net["name"] = fqName[len(fqName)-1]
+		net["uuid"] = "1234"
+		net["href"] = api.server.URL + "/virtual-network/1234"
+		json.NewEncoder(w).Encode(msg)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var _ = Describe("Contrail API with expiring Keystone tokens", func() {
+
+	var keystone *fakeKeystone
+	var api *fakeContrailAPI
+	var c *ContrailController
+
+	BeforeEach(func() {
+		keystone = newFakeKeystone()
+		api = newFakeContrailAPI()
+		api.setValidTokens("token-1")
+	})
+
+	JustBeforeEach(func() {
+		keys := &KeystoneEnvs{
+			os_auth_url:            keystone.authURL(),
+			os_username:            "admin",
+			os_user_domain_name:    "users",
+			os_password:            "secret123",
+			os_tenant_name:         "admin",
+			os_project_domain_name: "projects",
+			tokenRefreshMargin:     5 * time.Minute,
+		}
+		ip, port := api.address()
+		var err error
+		c, err = NewController(common.ControllerConfig{IP: ip, Port: port}, keys)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		keystone.server.Close()
+		api.server.Close()
+	})
+
+	It("uses the same token while it's valid", func() {
+		for i := 0; i < 3; i++ {
+			_, err := c.ApiClient.List("virtual-network")
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1", "token-1", "token-1"}))
+		Expect(keystone.requestCount()).To(Equal(1))
+	})
+
+	It("retries request once with a new token after 401", func() {
+		api.setValidTokens("token-2")
+
+		_, err := c.ApiClient.List("virtual-network")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1", "token-2"}))
+		Expect(keystone.requestCount()).To(Equal(2))
+	})
+
+	It("sends request body again when retrying", func() {
+		api.setValidTokens("token-2")
+
+		net := new(types.VirtualNetwork)
+		net.SetFQName("project", []string{"default-domain", "admin", "network"})
+		err := c.ApiClient.Create(net)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.GetUuid()).To(Equal("1234"))
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1", "token-2"}))
+	})
+
+	It("doesn't retry more than once", func() {
+		api.setValidTokens()
+
+		_, err := c.ApiClient.List("virtual-network")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("401"))
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1", "token-2"}))
+	})
+
+	It("returns 401 if Keystone refuses to issue a new token", func() {
+		api.setValidTokens()
+		keystone.status = http.StatusUnauthorized
+
+		_, err := c.ApiClient.List("virtual-network")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("401"))
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1"}))
+	})
+
+	Context("when tokens expire within refresh margin", func() {
+		BeforeEach(func() {
+			keystone.lifetime = time.Minute
+			api.setValidTokens("token-1", "token-2", "token-3")
+		})
+
+		It("obtains a new token before each request", func() {
+			for i := 0; i < 2; i++ {
+				_, err := c.ApiClient.List("virtual-network")
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(api.receivedTokens()).To(Equal([]string{"token-2", "token-3"}))
+		})
+	})
+})
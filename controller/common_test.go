@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+var ctx = context.Background()
+
+const (
+	domainName   = common.DomainName
+	tenantName   = "agatka"
+	networkName  = "test_net"
+	subnetCIDR   = "10.10.10.0/24"
+	subnetPrefix = "10.10.10.0"
+	subnetMask   = 24
+	defaultGW    = "10.10.10.1"
+	ifaceMac     = "contrail_pls_check_macs"
+	containerID  = "12345678901"
+)
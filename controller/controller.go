@@ -1,12 +1,14 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Juniper/contrail-go-api"
 	"github.com/Juniper/contrail-go-api/types"
@@ -17,8 +19,37 @@ import (
 type Info struct {
 }
 
-type Controller struct {
+// Controller is the part of Contrail API that the driver uses. Context of every call carries
+// the logger of the plugin request that it's made for.
+type Controller interface {
+	GetNetwork(ctx context.Context, domainName, tenantName, networkName string) (
+		*types.VirtualNetwork, error)
+	GetIpamSubnet(ctx context.Context, net *types.VirtualNetwork) (*types.IpamSubnetType, error)
+	GetDefaultGatewayIp(ctx context.Context, net *types.VirtualNetwork) (string, error)
+	GetOrCreateInstance(ctx context.Context, vif *types.VirtualMachineInterface,
+		containerId string) (*types.VirtualMachine, error)
+	GetOrCreateInterface(ctx context.Context, net *types.VirtualNetwork, domainName, tenantName,
+		containerId string) (*types.VirtualMachineInterface, error)
+	GetInterfaceMac(ctx context.Context, iface *types.VirtualMachineInterface) (string, error)
+	GetOrCreateInstanceIp(ctx context.Context, net *types.VirtualNetwork,
+		iface *types.VirtualMachineInterface) (*types.InstanceIp, error)
+	GetInstance(ctx context.Context, containerId string) (*types.VirtualMachine, error)
+	DeleteElementRecursive(ctx context.Context, parent contrail.IObject) error
+	PlanDeleteElementRecursive(ctx context.Context, parent contrail.IObject) (
+		[]contrail.IObject, error)
+	// CheckConnection returns error if Contrail API can't be reached or rejects our token.
+	CheckConnection(ctx context.Context) error
+}
+
+// ContrailController implements Controller by talking to Contrail API server.
+type ContrailController struct {
 	ApiClient contrail.ApiClient
+	// Host is the name of this host. Objects that the driver creates are annotated with it, and
+	// only objects annotated so are deleted recursively.
+	Host string
+
+	// endpoints is nil when ApiClient wasn't created by NewController.
+	endpoints *endpointPool
 }
 
 type KeystoneEnvs struct {
@@ -27,170 +58,344 @@ type KeystoneEnvs struct {
 	os_tenant_name string
 	os_password    string
 	os_token       string
+
+	// Identity API v3 is used if os_identity_api_version is "3", or if it's empty and auth URL
+	// ends with "/v3". Tenant name is then used as project name.
+	os_identity_api_version          string
+	os_user_domain_name              string
+	os_project_domain_name           string
+	os_application_credential_id     string
+	os_application_credential_name   string
+	os_application_credential_secret string
+
+	// tokenRefreshMargin is how long before expiry Keystone tokens of both identity API versions
+	// are refreshed. Tokens that Contrail API rejects earlier are refreshed right away.
+	tokenRefreshMargin time.Duration
+	// tls is used when auth URL is an https one.
+	tls common.TLSConfig
 }
 
 func (k *KeystoneEnvs) LoadFromEnvironment() {
 	k.os_auth_url = os.Getenv("OS_AUTH_URL")
 	k.os_username = os.Getenv("OS_USERNAME")
 	k.os_tenant_name = os.Getenv("OS_TENANT_NAME")
+	if projectName := os.Getenv("OS_PROJECT_NAME"); projectName != "" {
+		k.os_tenant_name = projectName
+	}
 	k.os_password = os.Getenv("OS_PASSWORD")
 	k.os_token = os.Getenv("OS_TOKEN")
+	k.os_identity_api_version = os.Getenv("OS_IDENTITY_API_VERSION")
+	k.os_user_domain_name = os.Getenv("OS_USER_DOMAIN_NAME")
+	k.os_project_domain_name = os.Getenv("OS_PROJECT_DOMAIN_NAME")
+	k.os_application_credential_id = os.Getenv("OS_APPLICATION_CREDENTIAL_ID")
+	k.os_application_credential_name = os.Getenv("OS_APPLICATION_CREDENTIAL_NAME")
+	k.os_application_credential_secret = os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET")
+	k.tls.CAFile = os.Getenv("OS_CACERT")
+	k.tls.CertFile = os.Getenv("OS_CERT")
+	k.tls.KeyFile = os.Getenv("OS_KEY")
+
+	if k.isV3() {
+		// v3 has a few alternative sets of credentials, KeystoneV3Client reports the missing
+		// ones.
+		if k.os_auth_url == "" {
+			log.Warn("Keystone variable empty: os_auth_url")
+		}
+		return
+	}
 
-	// print a warning for every empty variable
+	// print a warning for every empty v2.0 variable
 	keysReflection := reflect.ValueOf(*k)
 	for i := 0; i < keysReflection.NumField(); i++ {
+		name := keysReflection.Type().Field(i).Name
+		if name == "os_identity_api_version" {
+			break
+		}
 		if keysReflection.Field(i).String() == "" {
-			log.Warn("Keystone variable empty: ", keysReflection.Type().Field(i).Name)
+			log.Warn("Keystone variable empty: ", name)
 		}
 	}
 }
 
-func NewController(ip string, port int, keys *KeystoneEnvs) (*Controller, error) {
-	client := &Controller{}
-	client.ApiClient = contrail.NewClient(ip, port)
+// LoadFromConfig takes Keystone credentials from driver configuration, which already includes
+// overrides from environment variables.
+func (k *KeystoneEnvs) LoadFromConfig(cfg common.KeystoneConfig) {
+	k.os_auth_url = cfg.AuthURL
+	k.os_username = cfg.Username
+	k.os_tenant_name = cfg.TenantName
+	k.os_password = cfg.Password
+	k.os_token = cfg.Token
+	k.os_identity_api_version = cfg.IdentityAPIVersion
+	k.os_user_domain_name = cfg.UserDomainName
+	k.os_project_domain_name = cfg.ProjectDomainName
+	k.os_application_credential_id = cfg.ApplicationCredentialID
+	k.os_application_credential_name = cfg.ApplicationCredentialName
+	k.os_application_credential_secret = cfg.ApplicationCredentialSecret
+	k.tokenRefreshMargin = cfg.TokenRefreshMargin
+	k.tls = cfg.TLS
+}
+
+func (k *KeystoneEnvs) isV3() bool {
+	if k.os_identity_api_version != "" {
+		return k.os_identity_api_version == "3"
+	}
+	return strings.HasSuffix(strings.TrimSuffix(k.os_auth_url, "/"), "/v3")
+}
+
+func (k *KeystoneEnvs) v3Config() KeystoneV3Config {
+	return KeystoneV3Config{
+		AuthURL:                     k.os_auth_url,
+		Token:                       k.os_token,
+		ApplicationCredentialID:     k.os_application_credential_id,
+		ApplicationCredentialName:   k.os_application_credential_name,
+		ApplicationCredentialSecret: k.os_application_credential_secret,
+		Username:                    k.os_username,
+		UserDomainName:              k.os_user_domain_name,
+		Password:                    k.os_password,
+		ProjectName:                 k.os_tenant_name,
+		ProjectDomainName:           k.os_project_domain_name,
+		RefreshMargin:               k.tokenRefreshMargin,
+	}
+}
+
+func NewController(cfg common.ControllerConfig, keys *KeystoneEnvs) (*ContrailController,
+	error) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	client := &ContrailController{Host: hostName}
 
 	if keys.os_auth_url == "" {
 		// this corner case is not handled by keystone.Authenticate. Causes panic.
 		return nil, errors.New("Empty Keystone auth URL")
 	}
 
-	keystone := contrail.NewKeystoneClient(keys.os_auth_url, keys.os_tenant_name,
-		keys.os_username, keys.os_password, keys.os_token)
-	err := keystone.Authenticate()
-	if err != nil {
+	var auth keystoneAuthenticator
+	if keys.isV3() {
+		auth = NewKeystoneV3Client(keys.v3Config())
+	} else {
+		auth = newKeystoneV2Client(keys.os_auth_url, keys.os_tenant_name, keys.os_username,
+			keys.os_password, keys.os_token, keys.tokenRefreshMargin)
+	}
+	if strings.HasPrefix(keys.os_auth_url, "https://") {
+		transport, err := keys.tls.HTTPTransport()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Keystone TLS configuration: %v", err)
+		}
+		if keys.tls.InsecureSkipVerify {
+			log.Warnln("Keystone certificate verification is disabled")
+		}
+		auth.SetHTTPClient(&http.Client{Transport: transport})
+	}
+	if err := auth.Authenticate(); err != nil {
 		log.Errorln("Keystone error:", err)
 		return nil, err
 	}
-	client.ApiClient.(*contrail.Client).SetAuthenticator(keystone)
+
+	var base http.RoundTripper = http.DefaultTransport
+	scheme := "http"
+	if cfg.HTTPS {
+		transport, err := cfg.TLS.HTTPTransport()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Contrail API TLS configuration: %v", err)
+		}
+		if cfg.TLS.InsecureSkipVerify {
+			log.Warnln("Contrail API certificate verification is disabled")
+		}
+		base = transport
+		scheme = "https"
+	}
+
+	// contrail.Client builds http URLs pointing to the first endpoint. They are redirected to
+	// a healthy one by the transport.
+	addresses := cfg.Addresses()
+	host, port, err := splitAddress(addresses[0])
+	if err != nil {
+		return nil, err
+	}
+	client.endpoints = newEndpointPool(scheme, addresses, base)
+	if len(addresses) > 1 {
+		interval := cfg.HealthCheckInterval
+		if interval == 0 {
+			interval = DefaultHealthCheckInterval
+		}
+		client.endpoints.startHealthChecks(interval)
+	}
+
+	transport := &apiTransport{
+		base: &failoverTransport{base: base, pool: client.endpoints},
+		auth: auth,
+	}
+	apiClient := contrail.NewClient(host, port)
+	// Requests that exceed call timeout are cancelled, so that retryingClient can try again.
+	apiClient.SetHTTPClient(&http.Client{Transport: transport, Timeout: cfg.Retry.CallTimeout})
+	client.ApiClient = newRetryingClient(apiClient, cfg.Retry)
 	return client, nil
 }
 
-func (c *Controller) GetNetwork(tenantName, networkName string) (*types.VirtualNetwork,
-	error) {
-	name := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, networkName)
-	net, err := types.VirtualNetworkByName(c.ApiClient, name)
+// apiClient returns Contrail API client that logs with the logger of given context.
+func (c *ContrailController) apiClient(ctx context.Context) contrail.ApiClient {
+	if client, ok := c.ApiClient.(*retryingClient); ok {
+		return client.withContext(ctx)
+	}
+	return c.ApiClient
+}
+
+// Close stops health checks of Contrail API endpoints.
+func (c *ContrailController) Close() {
+	if c.endpoints != nil {
+		c.endpoints.stop()
+	}
+}
+
+func (c *ContrailController) GetNetwork(ctx context.Context, domainName, tenantName,
+	networkName string) (*types.VirtualNetwork, error) {
+	name := fmt.Sprintf("%s:%s:%s", domainName, tenantName, networkName)
+	net, err := types.VirtualNetworkByName(c.apiClient(ctx), name)
 	if err != nil {
-		log.Errorf("Failed to get virtual network %s by name: %v", name, err)
+		common.Logger(ctx).Errorf("Failed to get virtual network %s by name: %v", name, err)
 		return nil, err
 	}
 	return net, nil
 }
 
-func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork) (*types.IpamSubnetType, error) {
+func (c *ContrailController) GetIpamSubnet(ctx context.Context, net *types.VirtualNetwork) (
+	*types.IpamSubnetType, error) {
+	logger := common.Logger(ctx).WithField("networkUUID", net.GetUuid())
 	ipamReferences, err := net.GetNetworkIpamRefs()
 	if err != nil {
-		log.Errorf("Failed to get ipam references: %v", err)
+		logger.Errorf("Failed to get ipam references: %v", err)
 		return nil, err
 	}
 	if len(ipamReferences) == 0 {
 		err = errors.New("Ipam references list is empty")
-		log.Error(err)
+		logger.Error(err)
 		return nil, err
 	}
 	attribute := ipamReferences[0].Attr
 	ipamSubnets := attribute.(types.VnSubnetsType).IpamSubnets
 	if len(ipamSubnets) == 0 {
 		err = errors.New("Ipam subnets list is empty")
-		log.Error(err)
+		logger.Error(err)
 		return nil, err
 	}
 	return &ipamSubnets[0], nil
 }
 
-func (c *Controller) GetDefaultGatewayIp(net *types.VirtualNetwork) (string, error) {
-	subnet, err := c.GetIpamSubnet(net)
+func (c *ContrailController) GetDefaultGatewayIp(ctx context.Context,
+	net *types.VirtualNetwork) (string, error) {
+	subnet, err := c.GetIpamSubnet(ctx, net)
 	if err != nil {
 		return "", err
 	}
 	gw := subnet.DefaultGateway
 	if gw == "" {
 		err = errors.New("Default GW is empty")
-		log.Error(err)
+		common.Logger(ctx).WithField("networkUUID", net.GetUuid()).Error(err)
 		return "", err
 	}
 	return gw, nil
 }
 
-func (c *Controller) GetOrCreateInstance(vif *types.VirtualMachineInterface, containerId string) (
+func (c *ContrailController) GetInstance(ctx context.Context, containerId string) (
 	*types.VirtualMachine, error) {
-	instance, err := types.VirtualMachineByName(c.ApiClient, containerId)
+	return types.VirtualMachineByName(c.apiClient(ctx), containerId)
+}
+
+func (c *ContrailController) GetOrCreateInstance(ctx context.Context,
+	vif *types.VirtualMachineInterface, containerId string) (*types.VirtualMachine, error) {
+	logger := common.Logger(ctx).WithField("interfaceUUID", vif.GetUuid())
+	client := c.apiClient(ctx)
+	instance, err := c.GetInstance(ctx, containerId)
 	if err == nil && instance != nil {
 		return instance, nil
 	}
 
 	instance = new(types.VirtualMachine)
 	instance.SetName(containerId)
-	err = c.ApiClient.Create(instance)
+	markOwned(instance, c.Host)
+	err = client.Create(instance)
 	if err != nil {
-		log.Errorf("Failed to create instance: %v", err)
+		logger.Errorf("Failed to create instance: %v", err)
 		return nil, err
 	}
 
-	createdInstance, err := types.VirtualMachineByName(c.ApiClient, containerId)
+	createdInstance, err := types.VirtualMachineByName(client, containerId)
 	if err != nil {
-		log.Errorf("Failed to retreive instance %s by name: %v", containerId, err)
+		logger.Errorf("Failed to retreive instance %s by name: %v", containerId, err)
 		return nil, err
 	}
-	log.Infoln("Created instance: ", createdInstance.GetFQName())
+	logger = logger.WithField("instanceUUID", createdInstance.GetUuid())
+	logger.Infoln("Created instance: ", createdInstance.GetFQName())
 
 	err = vif.AddVirtualMachine(createdInstance)
 	if err != nil {
-		log.Errorf("Failed to add instance to vif")
+		logger.Errorf("Failed to add instance to vif")
 		return nil, err
 	}
-	err = c.ApiClient.Update(vif)
+	err = client.Update(vif)
 	if err != nil {
-		log.Errorf("Failed to update vif")
+		logger.Errorf("Failed to update vif")
 		return nil, err
 	}
 
 	return createdInstance, nil
 }
 
-func (c *Controller) GetOrCreateInterface(net *types.VirtualNetwork, tenantName,
-	containerId string) (*types.VirtualMachineInterface, error) {
+func (c *ContrailController) GetOrCreateInterface(ctx context.Context, net *types.VirtualNetwork,
+	domainName, tenantName, containerId string) (*types.VirtualMachineInterface, error) {
+	logger := common.Logger(ctx).WithField("networkUUID", net.GetUuid())
+	client := c.apiClient(ctx)
 
-	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, containerId)
-	iface, err := types.VirtualMachineInterfaceByName(c.ApiClient, fqName)
+	fqName := fmt.Sprintf("%s:%s:%s", domainName, tenantName, containerId)
+	iface, err := types.VirtualMachineInterfaceByName(client, fqName)
 	if err == nil && iface != nil {
 		return iface, nil
 	}
 
 	iface = new(types.VirtualMachineInterface)
-	iface.SetFQName("project", []string{common.DomainName, tenantName, containerId})
+	iface.SetFQName("project", []string{domainName, tenantName, containerId})
 	err = iface.AddVirtualNetwork(net)
 	if err != nil {
-		log.Errorf("Failed to add network to interface: %v", err)
+		logger.Errorf("Failed to add network to interface: %v", err)
 		return nil, err
 	}
-	err = c.ApiClient.Create(iface)
+	markOwned(iface, c.Host)
+	err = client.Create(iface)
 	if err != nil {
-		log.Errorf("Failed to create interface: %v", err)
+		logger.Errorf("Failed to create interface: %v", err)
 		return nil, err
 	}
 
-	createdIface, err := types.VirtualMachineInterfaceByName(c.ApiClient, fqName)
+	createdIface, err := types.VirtualMachineInterfaceByName(client, fqName)
 	if err != nil {
-		log.Errorf("Failed to retreive vmi %s by name: %v", fqName, err)
+		logger.Errorf("Failed to retreive vmi %s by name: %v", fqName, err)
 		return nil, err
 	}
-	log.Infoln("Created instance: ", createdIface.GetFQName())
+	logger.WithField("interfaceUUID", createdIface.GetUuid()).Infoln("Created instance: ",
+		createdIface.GetFQName())
 	return createdIface, nil
 }
 
-func (c *Controller) GetInterfaceMac(iface *types.VirtualMachineInterface) (string, error) {
+func (c *ContrailController) GetInterfaceMac(ctx context.Context,
+	iface *types.VirtualMachineInterface) (string, error) {
 	macs := iface.GetVirtualMachineInterfaceMacAddresses()
 	if len(macs.MacAddress) == 0 {
 		err := errors.New("Empty MAC list")
-		log.Error(err)
+		common.Logger(ctx).WithField("interfaceUUID", iface.GetUuid()).Error(err)
 		return "", err
 	}
 	return macs.MacAddress[0], nil
 }
 
-func (c *Controller) GetOrCreateInstanceIp(net *types.VirtualNetwork,
-	iface *types.VirtualMachineInterface) (*types.InstanceIp, error) {
-	instIp, err := types.InstanceIpByName(c.ApiClient, iface.GetName())
+func (c *ContrailController) GetOrCreateInstanceIp(ctx context.Context,
+	net *types.VirtualNetwork, iface *types.VirtualMachineInterface) (*types.InstanceIp, error) {
+	logger := common.Logger(ctx).WithFields(log.Fields{
+		"networkUUID":   net.GetUuid(),
+		"interfaceUUID": iface.GetUuid(),
+	})
+	client := c.apiClient(ctx)
+	instIp, err := types.InstanceIpByName(client, iface.GetName())
 	if err == nil && instIp != nil {
 		return instIp, nil
 	}
@@ -199,68 +404,47 @@ func (c *Controller) GetOrCreateInstanceIp(net *types.VirtualNetwork,
 	instIp.SetName(iface.GetName())
 	err = instIp.AddVirtualNetwork(net)
 	if err != nil {
-		log.Errorf("Failed to add network to instanceIP object: %v", err)
+		logger.Errorf("Failed to add network to instanceIP object: %v", err)
 		return nil, err
 	}
 	err = instIp.AddVirtualMachineInterface(iface)
 	if err != nil {
-		log.Errorf("Failed to add vmi to instanceIP object: %v", err)
+		logger.Errorf("Failed to add vmi to instanceIP object: %v", err)
 		return nil, err
 	}
-	err = c.ApiClient.Create(instIp)
+	markOwned(instIp, c.Host)
+	err = client.Create(instIp)
 	if err != nil {
-		log.Errorf("Failed to instanceIP: %v", err)
+		logger.Errorf("Failed to instanceIP: %v", err)
 		return nil, err
 	}
 
-	allocatedIP, err := types.InstanceIpByUuid(c.ApiClient, instIp.GetUuid())
+	allocatedIP, err := types.InstanceIpByUuid(client, instIp.GetUuid())
 	if err != nil {
-		log.Errorf("Failed to retreive instanceIP object %s by name: %v", instIp.GetUuid(), err)
+		logger.Errorf("Failed to retreive instanceIP object %s by name: %v", instIp.GetUuid(),
+			err)
 		return nil, err
 	}
 	return allocatedIP, nil
 }
 
-func (c *Controller) DeleteElementRecursive(parent contrail.IObject) error {
-	log.Debugln("Deleting", parent.GetType(), parent.GetUuid())
-	for err := c.ApiClient.Delete(parent); err != nil; err = c.ApiClient.Delete(parent) {
-		if strings.Contains(err.Error(), "404 Resource") {
-			log.Errorln("Failed to delete Contrail resource", err.Error())
-			break
-		} else if strings.Contains(err.Error(), "409 Conflict") {
-			msg := err.Error()
-			// example error message when object has children:
-			// `409 Conflict: Delete when children still present:
-			// ['http://10.7.0.54:8082/virtual-network/23e300f4-ab1a-4d97-a1d9-9ed69b601e17']`
-
-			// This regex finds all strings like:
-			// `virtual-network/23e300f4-ab1a-4d97-a1d9-9ed69b601e17`
-			var re *regexp.Regexp
-			re, err = regexp.Compile(
-				"([a-z-]*\\/[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})")
-			if err != nil {
-				return err
-			}
-			found := re.FindAll([]byte(msg), -1)
-
-			for _, f := range found {
-				split := strings.Split(string(f), "/")
-				typename := split[0]
-				UUID := split[1]
-				var child contrail.IObject
-				child, err = c.ApiClient.FindByUuid(typename, UUID)
-				if err != nil {
-					return err
-				}
-				if child == nil {
-					return errors.New("Child object is nil")
-				}
-				err = c.DeleteElementRecursive(child)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
+func (c *ContrailController) CheckConnection(ctx context.Context) error {
+	// Listing domains is cheap and, unlike the API root, requires a valid token.
+	_, err := c.apiClient(ctx).List("domain")
+	return err
+}
+
+// DeleteElementRecursive deletes the object together with its children and objects that refer
+// to it, in order found by walking them through the typed API. It refuses to delete anything
+// that the driver on this host hasn't created for containers.
+func (c *ContrailController) DeleteElementRecursive(ctx context.Context,
+	parent contrail.IObject) error {
+	return deleteRecursive(ctx, c.apiClient(ctx), parent, ownedBy(c.Host))
+}
+
+// PlanDeleteElementRecursive is a dry run of DeleteElementRecursive. It returns objects that
+// would be deleted, in order of deletion, without deleting any of them.
+func (c *ContrailController) PlanDeleteElementRecursive(ctx context.Context,
+	parent contrail.IObject) ([]contrail.IObject, error) {
+	return planDeletion(c.apiClient(ctx), parent, ownedBy(c.Host))
 }
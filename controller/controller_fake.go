@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/mocks"
+	"github.com/Juniper/contrail-go-api/types"
+)
+
+// FakeController is an in-memory implementation of Controller, built on mocks.ApiClient. On top
+// of what the mocks provide, it mimics behaviour of Contrail API server that the driver relies
+// on: it fills in default gateways of subnets, assigns MAC addresses to interfaces and allocates
+// instance IPs from network's subnet. Unlike the mocks, it's safe for concurrent use.
+type FakeController struct {
+	ContrailController
+	mutex sync.Mutex
+}
+
+func NewFakeController() *FakeController {
+	apiClient := new(mocks.ApiClient)
+	apiClient.Init()
+	apiClient.AddInterceptor("virtual-network", &networkInterceptor{})
+	apiClient.AddInterceptor("virtual-machine-interface", &interfaceInterceptor{})
+	apiClient.AddInterceptor("instance-ip", &instanceIpInterceptor{client: apiClient})
+
+	c := &FakeController{}
+	c.ApiClient = apiClient
+	c.Host, _ = os.Hostname()
+	return c
+}
+
+func (c *FakeController) GetNetwork(ctx context.Context, domainName, tenantName,
+	networkName string) (*types.VirtualNetwork, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetNetwork(ctx, domainName, tenantName, networkName)
+}
+
+func (c *FakeController) GetIpamSubnet(ctx context.Context, net *types.VirtualNetwork) (
+	*types.IpamSubnetType, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetIpamSubnet(ctx, net)
+}
+
+func (c *FakeController) GetDefaultGatewayIp(ctx context.Context, net *types.VirtualNetwork) (
+	string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetDefaultGatewayIp(ctx, net)
+}
+
+func (c *FakeController) GetInstance(ctx context.Context, containerId string) (
+	*types.VirtualMachine, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetInstance(ctx, containerId)
+}
+
+func (c *FakeController) GetOrCreateInstance(ctx context.Context,
+	vif *types.VirtualMachineInterface, containerId string) (*types.VirtualMachine, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetOrCreateInstance(ctx, vif, containerId)
+}
+
+func (c *FakeController) GetOrCreateInterface(ctx context.Context, net *types.VirtualNetwork,
+	domainName, tenantName, containerId string) (*types.VirtualMachineInterface, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetOrCreateInterface(ctx, net, domainName, tenantName,
+		containerId)
+}
+
+func (c *FakeController) GetInterfaceMac(ctx context.Context,
+	iface *types.VirtualMachineInterface) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetInterfaceMac(ctx, iface)
+}
+
+func (c *FakeController) GetOrCreateInstanceIp(ctx context.Context, net *types.VirtualNetwork,
+	iface *types.VirtualMachineInterface) (*types.InstanceIp, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.GetOrCreateInstanceIp(ctx, net, iface)
+}
+
+func (c *FakeController) CheckConnection(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.CheckConnection(ctx)
+}
+
+func (c *FakeController) DeleteElementRecursive(ctx context.Context,
+	parent contrail.IObject) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.DeleteElementRecursive(ctx, parent)
+}
+
+func (c *FakeController) PlanDeleteElementRecursive(ctx context.Context,
+	parent contrail.IObject) ([]contrail.IObject, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ContrailController.PlanDeleteElementRecursive(ctx, parent)
+}
+
+// networkInterceptor fills in default gateways of network's subnets, using the first address
+// of a subnet, like Contrail API server does.
+type networkInterceptor struct{}
+
+func (i *networkInterceptor) Get(obj contrail.IObject) {
+	i.Put(obj)
+}
+
+func (i *networkInterceptor) Put(obj contrail.IObject) {
+	ipamRefs, err := obj.(*types.VirtualNetwork).GetNetworkIpamRefs()
+	if err != nil {
+		return
+	}
+	for _, ref := range ipamRefs {
+		subnets, ok := ref.Attr.(types.VnSubnetsType)
+		if !ok {
+			continue
+		}
+		for j := range subnets.IpamSubnets {
+			subnet := &subnets.IpamSubnets[j]
+			if subnet.DefaultGateway == "" && subnet.Subnet != nil {
+				subnet.DefaultGateway = nthAddress(subnet.Subnet.IpPrefix, 1)
+			}
+		}
+	}
+}
+
+// interfaceInterceptor assigns MAC addresses to created interfaces.
+type interfaceInterceptor struct{}
+
+func (i *interfaceInterceptor) Get(obj contrail.IObject) {}
+
+func (i *interfaceInterceptor) Put(obj contrail.IObject) {
+	iface := obj.(*types.VirtualMachineInterface)
+	if len(iface.GetVirtualMachineInterfaceMacAddresses().MacAddress) != 0 {
+		return
+	}
+	// Locally administered MAC, derived from interface's UUID.
+	id := strings.Replace(iface.GetUuid(), "-", "", -1)
+	mac := fmt.Sprintf("02:%s:%s:%s:%s:%s", id[0:2], id[2:4], id[4:6], id[6:8], id[8:10])
+	macs := new(types.MacAddressesType)
+	macs.AddMacAddress(mac)
+	iface.SetVirtualMachineInterfaceMacAddresses(macs)
+}
+
+// instanceIpInterceptor allocates the first free address from network's subnet to created
+// instance IPs.
+type instanceIpInterceptor struct {
+	client contrail.ApiClient
+}
+
+func (i *instanceIpInterceptor) Get(obj contrail.IObject) {}
+
+func (i *instanceIpInterceptor) Put(obj contrail.IObject) {
+	instIp := obj.(*types.InstanceIp)
+	if instIp.GetInstanceIpAddress() != "" {
+		return
+	}
+	netRefs, err := instIp.GetVirtualNetworkRefs()
+	if err != nil || len(netRefs) == 0 {
+		return
+	}
+	network, err := types.VirtualNetworkByUuid(i.client, netRefs[0].Uuid)
+	if err != nil {
+		return
+	}
+	ipamRefs, err := network.GetNetworkIpamRefs()
+	if err != nil || len(ipamRefs) == 0 {
+		return
+	}
+	subnets, ok := ipamRefs[0].Attr.(types.VnSubnetsType)
+	if !ok || len(subnets.IpamSubnets) == 0 || subnets.IpamSubnets[0].Subnet == nil {
+		return
+	}
+	subnet := subnets.IpamSubnets[0]
+
+	used := map[string]bool{subnet.DefaultGateway: true}
+	ipRefs, err := network.GetInstanceIpBackRefs()
+	if err != nil {
+		return
+	}
+	for _, ref := range ipRefs {
+		if usedIp, err := types.InstanceIpByUuid(i.client, ref.Uuid); err == nil {
+			used[usedIp.GetInstanceIpAddress()] = true
+		}
+	}
+
+	// Skip network and broadcast addresses.
+	size := 1 << uint(32-subnet.Subnet.IpPrefixLen)
+	for n := 1; n < size-1; n++ {
+		addr := nthAddress(subnet.Subnet.IpPrefix, n)
+		if !used[addr] {
+			instIp.SetInstanceIpAddress(addr)
+			return
+		}
+	}
+}
+
+// nthAddress returns n-th IPv4 address counting from prefix.
+func nthAddress(prefix string, n int) string {
+	ip := net.ParseIP(prefix).To4()
+	if ip == nil {
+		return ""
+	}
+	addr := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(addr, binary.BigEndian.Uint32(ip)+uint32(n))
+	return addr.String()
+}
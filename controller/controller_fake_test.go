@@ -0,0 +1,126 @@
+//go:build fakes
+// +build fakes
+
+package controller
+
+import (
+	"net"
+
+	"github.com/Juniper/contrail-go-api/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake controller", func() {
+
+	var fake *FakeController
+	var testNetwork *types.VirtualNetwork
+
+	BeforeEach(func() {
+		fake = NewFakeController()
+		project := new(types.Project)
+		project.SetFQName("domain", []string{domainName, tenantName})
+		err := fake.ApiClient.Create(project)
+		Expect(err).ToNot(HaveOccurred())
+		testNetwork = CreateMockedNetworkWithSubnet(fake.ApiClient, networkName, subnetCIDR,
+			project)
+	})
+
+	createInterfaceAndIP := func(containerID string) (*types.VirtualMachineInterface,
+		*types.InstanceIp) {
+		iface, err := fake.GetOrCreateInterface(ctx, testNetwork, domainName,
+			tenantName, containerID)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = fake.GetOrCreateInstance(ctx, iface, containerID)
+		Expect(err).ToNot(HaveOccurred())
+		instanceIP, err := fake.GetOrCreateInstanceIp(ctx, testNetwork, iface)
+		Expect(err).ToNot(HaveOccurred())
+		return iface, instanceIP
+	}
+
+	It("reports working connection", func() {
+		Expect(fake.CheckConnection(ctx)).To(Succeed())
+	})
+
+	It("sets default gateway of subnets", func() {
+		net, err := fake.GetNetwork(ctx, domainName, tenantName, networkName)
+		Expect(err).ToNot(HaveOccurred())
+		gw, err := fake.GetDefaultGatewayIp(ctx, net)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gw).To(Equal(defaultGW))
+	})
+
+	It("finds networks and creates interfaces in the given domain", func() {
+		project := CreateMockedProject(fake.ApiClient, "other-domain", tenantName)
+		otherNetwork := CreateMockedNetworkWithSubnet(fake.ApiClient, networkName, subnetCIDR,
+			project)
+
+		net, err := fake.GetNetwork(ctx, "other-domain", tenantName, networkName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.GetUuid()).To(Equal(otherNetwork.GetUuid()))
+		Expect(net.GetUuid()).ToNot(Equal(testNetwork.GetUuid()))
+
+		iface, err := fake.GetOrCreateInterface(ctx, net, "other-domain", tenantName,
+			containerID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(iface.GetFQName()).To(Equal([]string{"other-domain", tenantName, containerID}))
+	})
+
+	It("assigns MAC addresses to interfaces", func() {
+		iface, _ := createInterfaceAndIP(containerID)
+		mac, err := fake.GetInterfaceMac(ctx, iface)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = net.ParseMAC(mac)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("allocates distinct instance IPs from network's subnet", func() {
+		_, subnet, err := net.ParseCIDR(subnetCIDR)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, ip1 := createInterfaceAndIP("container1")
+		_, ip2 := createInterfaceAndIP("container2")
+
+		Expect(ip1.GetInstanceIpAddress()).ToNot(Equal(ip2.GetInstanceIpAddress()))
+		for _, ip := range []*types.InstanceIp{ip1, ip2} {
+			Expect(ip.GetInstanceIpAddress()).ToNot(Equal(defaultGW))
+			Expect(subnet.Contains(net.ParseIP(ip.GetInstanceIpAddress()))).To(BeTrue())
+		}
+	})
+
+	It("returns existing objects instead of creating new ones", func() {
+		iface1, ip1 := createInterfaceAndIP(containerID)
+		iface2, ip2 := createInterfaceAndIP(containerID)
+		Expect(iface1.GetUuid()).To(Equal(iface2.GetUuid()))
+		Expect(ip1.GetUuid()).To(Equal(ip2.GetUuid()))
+	})
+
+	Specify("recursive deletion removes instance, its interface and IP, but not network", func() {
+		iface, instanceIP := createInterfaceAndIP(containerID)
+		instance, err := fake.GetInstance(ctx, containerID)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = fake.DeleteElementRecursive(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = fake.GetInstance(ctx, containerID)
+		Expect(err).To(HaveOccurred())
+		_, err = fake.ApiClient.FindByUuid(iface.GetType(), iface.GetUuid())
+		Expect(err).To(HaveOccurred())
+		_, err = fake.ApiClient.FindByUuid(instanceIP.GetType(), instanceIP.GetUuid())
+		Expect(err).To(HaveOccurred())
+		_, err = fake.GetNetwork(ctx, domainName, tenantName, networkName)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Specify("freed instance IPs can be allocated again", func() {
+		_, ip1 := createInterfaceAndIP("container1")
+		instance, err := fake.GetInstance(ctx, "container1")
+		Expect(err).ToNot(HaveOccurred())
+		err = fake.DeleteElementRecursive(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, ip2 := createInterfaceAndIP("container2")
+		Expect(ip2.GetInstanceIpAddress()).To(Equal(ip1.GetInstanceIpAddress()))
+	})
+})
@@ -1,3 +1,6 @@
+//go:build !fakes
+// +build !fakes
+
 package controller
 
 import (
@@ -36,35 +39,19 @@ func TestController(t *testing.T) {
 		[]Reporter{junitReporter})
 }
 
-const (
-	tenantName   = "agatka"
-	networkName  = "test_net"
-	subnetCIDR   = "10.10.10.0/24"
-	subnetPrefix = "10.10.10.0"
-	subnetMask   = 24
-	defaultGW    = "10.10.10.1"
-	ifaceMac     = "contrail_pls_check_macs"
-	containerID  = "12345678901"
-)
-
-var _ = BeforeSuite(func() {
-	if useActualController {
-		// this cleans up
-		client, _ := NewClientAndProject(tenantName, controllerAddr, controllerPort)
-		CleanupLingeringVM(client, containerID)
-	}
-})
-
 var _ = Describe("Controller", func() {
 
-	var client *Controller
+	var client *ContrailController
 	var project *types.Project
 
 	BeforeEach(func() {
 		if useActualController {
-			client, project = NewClientAndProject(tenantName, controllerAddr, controllerPort)
+			client, project = NewClientAndProject(domainName, tenantName, controllerAddr,
+				controllerPort)
+			// this cleans up what previous runs may have left
+			CleanupLingeringVM(client, containerID)
 		} else {
-			client, project = NewMockedClientAndProject(tenantName)
+			client, project = NewMockedClientAndProject(domainName, tenantName)
 		}
 	})
 
@@ -79,30 +66,31 @@ var _ = Describe("Controller", func() {
 		// to VMI
 		testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
 			project)
-		testInterface := CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-			containerID)
+		testInterface := CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+			tenantName, containerID)
 		_ = CreateMockedInstance(client.ApiClient, testInterface, containerID)
 		_ = CreateMockedInstanceIP(client.ApiClient, tenantName, testInterface,
 			testNetwork)
 
 		// shouldn't error when creating new client and project
 		if useActualController {
-			client, project = NewClientAndProject(tenantName, controllerAddr, controllerPort)
+			client, project = NewClientAndProject(domainName, tenantName, controllerAddr,
+				controllerPort)
 		} else {
-			client, project = NewMockedClientAndProject(tenantName)
+			client, project = NewMockedClientAndProject(domainName, tenantName)
 		}
 	})
 
 	Specify("recursive deletion removes elements down the ref tree", func() {
 		testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
 			project)
-		testInterface := CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-			containerID)
+		testInterface := CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+			tenantName, containerID)
 		testInstance := CreateMockedInstance(client.ApiClient, testInterface, containerID)
 		testInstanceIP := CreateMockedInstanceIP(client.ApiClient, tenantName, testInterface,
 			testNetwork)
 
-		err := client.DeleteElementRecursive(testInstance)
+		err := client.DeleteElementRecursive(ctx, testInstance)
 		Expect(err).ToNot(HaveOccurred())
 
 		_, err = client.ApiClient.FindByUuid(testNetwork.GetType(), testNetwork.GetUuid())
@@ -124,14 +112,14 @@ var _ = Describe("Controller", func() {
 					subnetCIDR, project)
 			})
 			It("returns it", func() {
-				net, err := client.GetNetwork(tenantName, networkName)
+				net, err := client.GetNetwork(ctx, domainName, tenantName, networkName)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(net.GetUuid()).To(Equal(testNetwork.GetUuid()))
 			})
 		})
 		Context("when network doesn't exist in Contrail", func() {
 			It("returns an error", func() {
-				net, err := client.GetNetwork(tenantName, networkName)
+				net, err := client.GetNetwork(ctx, domainName, tenantName, networkName)
 				Expect(err).To(HaveOccurred())
 				Expect(net).To(BeNil())
 			})
@@ -147,12 +135,12 @@ var _ = Describe("Controller", func() {
 					subnetMask, testNetwork)
 			})
 			Specify("getting default gw IP works", func() {
-				gwAddr, err := client.GetDefaultGatewayIp(testNetwork)
+				gwAddr, err := client.GetDefaultGatewayIp(ctx, testNetwork)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(gwAddr).ToNot(Equal(""))
 			})
 			Specify("getting subnet prefix and prefix len works", func() {
-				ipam, err := client.GetIpamSubnet(testNetwork)
+				ipam, err := client.GetIpamSubnet(ctx, testNetwork)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(ipam.Subnet.IpPrefix).To(Equal(subnetPrefix))
 				Expect(ipam.Subnet.IpPrefixLen).To(Equal(subnetMask))
@@ -165,7 +153,7 @@ var _ = Describe("Controller", func() {
 					subnetCIDR, project)
 			})
 			Specify("getting default gw IP returns error", func() {
-				gwAddr, err := client.GetDefaultGatewayIp(testNetwork)
+				gwAddr, err := client.GetDefaultGatewayIp(ctx, testNetwork)
 				if useActualController {
 					Expect(gwAddr).ToNot(Equal(""))
 					Expect(err).ToNot(HaveOccurred())
@@ -176,7 +164,7 @@ var _ = Describe("Controller", func() {
 				}
 			})
 			Specify("getting subnet prefix and prefix len works", func() {
-				ipam, err := client.GetIpamSubnet(testNetwork)
+				ipam, err := client.GetIpamSubnet(ctx, testNetwork)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(ipam.Subnet.IpPrefix).To(Equal(subnetPrefix))
 				Expect(ipam.Subnet.IpPrefixLen).To(Equal(subnetMask))
@@ -188,12 +176,12 @@ var _ = Describe("Controller", func() {
 				testNetwork = CreateMockedNetwork(client.ApiClient, networkName, project)
 			})
 			Specify("getting default gw IP returns error", func() {
-				gwAddr, err := client.GetDefaultGatewayIp(testNetwork)
+				gwAddr, err := client.GetDefaultGatewayIp(ctx, testNetwork)
 				Expect(err).To(HaveOccurred())
 				Expect(gwAddr).To(Equal(""))
 			})
 			Specify("getting subnet prefix and prefix len returns error", func() {
-				ipam, err := client.GetIpamSubnet(testNetwork)
+				ipam, err := client.GetIpamSubnet(ctx, testNetwork)
 				Expect(err).To(HaveOccurred())
 				Expect(ipam).To(BeNil())
 			})
@@ -209,26 +197,29 @@ var _ = Describe("Controller", func() {
 		Context("when vif already exists in Contrail", func() {
 			var testInterface *types.VirtualMachineInterface
 			BeforeEach(func() {
-				testInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-					containerID)
+				testInterface = CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+					tenantName, containerID)
 			})
 			It("returns existing vif", func() {
-				iface, err := client.GetOrCreateInterface(testNetwork, tenantName, containerID)
+				iface, err := client.GetOrCreateInterface(ctx, testNetwork, domainName,
+					tenantName, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(iface).ToNot(BeNil())
 				Expect(iface.GetUuid()).To(Equal(testInterface.GetUuid()))
 			})
 			It("assigns correct FQName to vif", func() {
-				iface, err := client.GetOrCreateInterface(testNetwork, tenantName, containerID)
+				iface, err := client.GetOrCreateInterface(ctx, testNetwork, domainName,
+					tenantName, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(iface).ToNot(BeNil())
-				Expect(iface.GetFQName()).To(Equal([]string{common.DomainName, tenantName,
+				Expect(iface.GetFQName()).To(Equal([]string{domainName, tenantName,
 					containerID}))
 			})
 		})
 		Context("when vif doesn't exist in Contrail", func() {
 			It("creates a new vif", func() {
-				iface, err := client.GetOrCreateInterface(testNetwork, tenantName, containerID)
+				iface, err := client.GetOrCreateInterface(ctx, testNetwork, domainName,
+					tenantName, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(iface).ToNot(BeNil())
 
@@ -245,8 +236,8 @@ var _ = Describe("Controller", func() {
 		BeforeEach(func() {
 			testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
 				project)
-			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-				containerID)
+			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+				tenantName, containerID)
 		})
 		Context("when instance already exists in Contrail", func() {
 			var testInstance *types.VirtualMachine
@@ -254,7 +245,7 @@ var _ = Describe("Controller", func() {
 				testInstance = CreateMockedInstance(client.ApiClient, testInterface, containerID)
 			})
 			It("returns existing instance", func() {
-				instance, err := client.GetOrCreateInstance(testInterface, containerID)
+				instance, err := client.GetOrCreateInstance(ctx, testInterface, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(instance).ToNot(BeNil())
 				Expect(instance.GetUuid()).To(Equal(testInstance.GetUuid()))
@@ -262,7 +253,7 @@ var _ = Describe("Controller", func() {
 		})
 		Context("when instance doesn't exist in Contrail", func() {
 			It("creates a new instance", func() {
-				instance, err := client.GetOrCreateInstance(testInterface, containerID)
+				instance, err := client.GetOrCreateInstance(ctx, testInterface, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(instance).ToNot(BeNil())
 
@@ -279,15 +270,15 @@ var _ = Describe("Controller", func() {
 		BeforeEach(func() {
 			testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
 				project)
-			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-				containerID)
+			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+				tenantName, containerID)
 		})
 		Context("when vif has a VM", func() {
 			BeforeEach(func() {
 				_ = CreateMockedInstance(client.ApiClient, testInterface, containerID)
 			})
 			It("returns MAC address", func() {
-				mac, err := client.GetInterfaceMac(testInterface)
+				mac, err := client.GetInterfaceMac(ctx, testInterface)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(mac).ToNot(Equal("")) // dunno how to get actual MAC when given Instance
 			})
@@ -297,14 +288,14 @@ var _ = Describe("Controller", func() {
 				AddMacToInterface(client.ApiClient, ifaceMac, testInterface)
 			})
 			It("returns MAC address", func() {
-				mac, err := client.GetInterfaceMac(testInterface)
+				mac, err := client.GetInterfaceMac(ctx, testInterface)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(mac).To(Equal(ifaceMac))
 			})
 		})
 		Context("when vif doesn't have a MAC", func() {
 			It("returns error", func() {
-				mac, err := client.GetInterfaceMac(testInterface)
+				mac, err := client.GetInterfaceMac(ctx, testInterface)
 				Expect(err).To(HaveOccurred())
 				Expect(mac).To(Equal(""))
 			})
@@ -313,14 +304,13 @@ var _ = Describe("Controller", func() {
 
 	Describe("getting Contrail instance IP", func() {
 		var testNetwork *types.VirtualNetwork
-		var testInstance *types.VirtualMachine
 		var testInterface *types.VirtualMachineInterface
 		BeforeEach(func() {
 			testNetwork = CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
 				project)
-			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
-				containerID)
-			testInstance = CreateMockedInstance(client.ApiClient, testInterface, containerID)
+			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+				tenantName, containerID)
+			_ = CreateMockedInstance(client.ApiClient, testInterface, containerID)
 		})
 		Context("when instance IP already exists in Contrail", func() {
 			var testInstanceIP *types.InstanceIp
@@ -329,7 +319,7 @@ var _ = Describe("Controller", func() {
 					testInterface, testNetwork)
 			})
 			It("returns existing instance IP", func() {
-				instanceIP, err := client.GetOrCreateInstanceIp(testNetwork, testInterface)
+				instanceIP, err := client.GetOrCreateInstanceIp(ctx, testNetwork, testInterface)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(instanceIP).ToNot(BeNil())
 				Expect(instanceIP.GetUuid()).To(Equal(testInstanceIP.GetUuid()))
@@ -343,7 +333,7 @@ var _ = Describe("Controller", func() {
 		})
 		Context("when instance IP doesn't exist in Contrail", func() {
 			It("creates new instance IP", func() {
-				instanceIP, err := client.GetOrCreateInstanceIp(testNetwork, testInterface)
+				instanceIP, err := client.GetOrCreateInstanceIp(ctx, testNetwork, testInterface)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(instanceIP).ToNot(BeNil())
 				Expect(instanceIP.GetInstanceIpAddress()).ToNot(Equal(""))
@@ -364,7 +354,8 @@ var _ = Describe("Authenticating", func() {
 	}
 	DescribeTable("with different keystone env variables",
 		func(t TestCase) {
-			_, err := NewController(controllerAddr, controllerPort, &t.keys)
+			_, err := NewController(common.ControllerConfig{IP: controllerAddr, Port: controllerPort},
+				&t.keys)
 			if t.shouldErr {
 				Expect(err).To(HaveOccurred())
 			} else {
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"os"
 
 	contrail "github.com/Juniper/contrail-go-api"
 	"github.com/Juniper/contrail-go-api/config"
@@ -30,34 +32,44 @@ func TestKeystoneEnvs() *KeystoneEnvs {
 	}
 }
 
-func NewMockedClientAndProject(tenant string) (*Controller, *types.Project) {
-	c := &Controller{}
+func NewMockedClientAndProject(domainName, tenant string) (*ContrailController, *types.Project) {
+	c := &ContrailController{Host: thisHost()}
 	mockedApiClient := new(mocks.ApiClient)
 	mockedApiClient.Init()
 	c.ApiClient = mockedApiClient
-
-	project := new(types.Project)
-	project.SetFQName("domain", []string{common.DomainName, tenant})
-	err := c.ApiClient.Create(project)
-	Expect(err).ToNot(HaveOccurred())
-	return c, project
+	return c, CreateMockedProject(c.ApiClient, domainName, tenant)
 }
 
-func NewClientAndProject(tenant, controllerAddr string, controllerPort int) (*Controller,
-	*types.Project) {
-	c, err := NewController(controllerAddr, controllerPort, TestKeystoneEnvs())
+func NewClientAndProject(domainName, tenant, controllerAddr string, controllerPort int) (
+	*ContrailController, *types.Project) {
+	c, err := NewController(common.ControllerConfig{IP: controllerAddr, Port: controllerPort},
+		TestKeystoneEnvs())
 	Expect(err).ToNot(HaveOccurred())
 
-	ForceDeleteProject(c, tenant)
+	ForceDeleteProject(c, domainName, tenant)
 
 	project := new(types.Project)
-	project.SetFQName("domain", []string{common.DomainName, tenant})
+	project.SetFQName("domain", []string{domainName, tenant})
 	Expect(err).ToNot(HaveOccurred())
 	err = c.ApiClient.Create(project)
 	Expect(err).ToNot(HaveOccurred())
 	return c, project
 }
 
+// CreateMockedProject creates project in given domain, creating the domain first if it doesn't
+// exist yet.
+func CreateMockedProject(c contrail.ApiClient, domainName, tenant string) *types.Project {
+	if _, err := c.FindByName("domain", domainName); err != nil {
+		domain := new(types.Domain)
+		domain.SetName(domainName)
+		Expect(c.Create(domain)).To(Succeed())
+	}
+	project := new(types.Project)
+	project.SetFQName("domain", []string{domainName, tenant})
+	Expect(c.Create(project)).To(Succeed())
+	return project
+}
+
 func CreateMockedNetworkWithSubnet(c contrail.ApiClient, netName, subnetCIDR string,
 	project *types.Project) *types.VirtualNetwork {
 	netUUID, err := config.CreateNetworkWithSubnet(c, project.GetUuid(), netName, subnetCIDR)
@@ -100,10 +112,19 @@ func AddSubnetWithDefaultGateway(c contrail.ApiClient, subnetPrefix, defaultGW s
 	Expect(err).ToNot(HaveOccurred())
 }
 
+// thisHost returns name of this host. Objects created by helpers below are annotated with it, like
+// those that the driver creates for containers.
+func thisHost() string {
+	host, err := os.Hostname()
+	Expect(err).ToNot(HaveOccurred())
+	return host
+}
+
 func CreateMockedInstance(c contrail.ApiClient, vif *types.VirtualMachineInterface,
 	containerID string) *types.VirtualMachine {
 	testInstance := new(types.VirtualMachine)
 	testInstance.SetName(containerID)
+	markOwned(testInstance, thisHost())
 	err := c.Create(testInstance)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -118,14 +139,15 @@ func CreateMockedInstance(c contrail.ApiClient, vif *types.VirtualMachineInterfa
 	return testInstance
 }
 
-func CreateMockedInterface(c contrail.ApiClient, net *types.VirtualNetwork, tenantName,
-	containerId string) *types.VirtualMachineInterface {
+func CreateMockedInterface(c contrail.ApiClient, net *types.VirtualNetwork, domainName,
+	tenantName, containerId string) *types.VirtualMachineInterface {
 	iface := new(types.VirtualMachineInterface)
 
-	iface.SetFQName("project", []string{common.DomainName, tenantName, containerId})
+	iface.SetFQName("project", []string{domainName, tenantName, containerId})
 
 	err := iface.AddVirtualNetwork(net)
 	Expect(err).ToNot(HaveOccurred())
+	markOwned(iface, thisHost())
 	err = c.Create(iface)
 	Expect(err).ToNot(HaveOccurred())
 	return iface
@@ -149,6 +171,7 @@ func CreateMockedInstanceIP(c contrail.ApiClient, tenantName string,
 	Expect(err).ToNot(HaveOccurred())
 	err = instIP.AddVirtualMachineInterface(iface)
 	Expect(err).ToNot(HaveOccurred())
+	markOwned(instIP, thisHost())
 	err = c.Create(instIP)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -157,18 +180,23 @@ func CreateMockedInstanceIP(c contrail.ApiClient, tenantName string,
 	return allocatedIP
 }
 
-func ForceDeleteProject(c *Controller, tenant string) {
-	projToDelete, _ := c.ApiClient.FindByName("project", fmt.Sprintf("%s:%s", common.DomainName,
-		tenant))
+func ForceDeleteProject(c *ContrailController, domainName, tenant string) {
+	projToDelete, _ := c.ApiClient.FindByName("project", fmt.Sprintf("%s:%s", domainName, tenant))
 	if projToDelete != nil {
-		c.DeleteElementRecursive(projToDelete)
+		ForceDeleteElementRecursive(c, projToDelete)
 	}
 }
 
-func CleanupLingeringVM(c *Controller, containerID string) {
+// ForceDeleteElementRecursive deletes the object with everything that depends on it, even if
+// the driver doesn't own them. Tests use it to clean up networks and projects.
+func ForceDeleteElementRecursive(c *ContrailController, obj contrail.IObject) error {
+	return deleteRecursive(context.Background(), c.ApiClient, obj, anyObject)
+}
+
+func CleanupLingeringVM(c *ContrailController, containerID string) {
 	instance, err := types.VirtualMachineByName(c.ApiClient, containerID)
 	if err == nil {
 		log.Debugln("Cleaning up lingering test vm", instance.GetUuid())
-		c.DeleteElementRecursive(instance)
+		ForceDeleteElementRecursive(c, instance)
 	}
 }
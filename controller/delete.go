@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// ownerAnnotation is the key of the annotation that marks objects created by the driver. Its value
+// is the name of the host whose driver created the object.
+const ownerAnnotation = "contrail-windows-docker/host"
+
+// ownedTypes are Contrail types of objects that the driver creates for containers. Recursive
+// deletion refuses to touch anything else, e.g. a network reached from an instance, or a virtual
+// router that refers to it.
+var ownedTypes = map[string]bool{
+	"virtual-machine":           true,
+	"virtual-machine-interface": true,
+	"instance-ip":               true,
+}
+
+// annotatedObject is a Contrail object that can be annotated, like all types in ownedTypes.
+type annotatedObject interface {
+	contrail.IObject
+	GetAnnotations() types.KeyValuePairs
+	SetAnnotations(*types.KeyValuePairs)
+}
+
+// markOwned annotates object, which is about to be created, as created by the driver on given
+// host.
+func markOwned(obj annotatedObject, host string) {
+	annotations := obj.GetAnnotations()
+	annotations.AddKeyValuePair(&types.KeyValuePair{Key: ownerAnnotation, Value: host})
+	obj.SetAnnotations(&annotations)
+}
+
+// ownedBy returns function that checks whether the object was created by the driver on given
+// host. Objects of the same types created by others, e.g. by the driver on another host or by an
+// orchestrator, aren't owned.
+func ownedBy(host string) func(contrail.IObject) bool {
+	return func(obj contrail.IObject) bool {
+		annotated, ok := obj.(annotatedObject)
+		if !ok || !ownedTypes[obj.GetType()] {
+			return false
+		}
+		for _, pair := range annotated.GetAnnotations().KeyValuePair {
+			if pair.Key == ownerAnnotation {
+				return pair.Value == host
+			}
+		}
+		return false
+	}
+}
+
+// anyObject allows deletion of every object. Only tests use it, to clean up whole projects.
+func anyObject(contrail.IObject) bool {
+	return true
+}
+
+// deletionPlanner builds order in which objects have to be deleted, so that each object is
+// deleted after its children and objects that refer to it.
+type deletionPlanner struct {
+	client contrail.ApiClient
+	owned  func(contrail.IObject) bool
+
+	// inProgress holds objects on the current path of the walk, to detect cycles.
+	inProgress map[string]bool
+	planned    map[string]bool
+	order      []contrail.IObject
+}
+
+// planDeletion returns the object and everything that depends on it, in order of deletion. It
+// returns an error if any of these objects can't be deleted by the driver, or if dependencies
+// form a cycle.
+func planDeletion(client contrail.ApiClient, obj contrail.IObject,
+	owned func(contrail.IObject) bool) ([]contrail.IObject, error) {
+	p := &deletionPlanner{
+		client:     client,
+		owned:      owned,
+		inProgress: make(map[string]bool),
+		planned:    make(map[string]bool),
+	}
+	if err := p.visit(obj, nil); err != nil {
+		return nil, err
+	}
+	return p.order, nil
+}
+
+// visit walks dependents of the object depth first and adds them to the plan before the object
+// itself. path leads from the deleted object to this one and is used in errors.
+func (p *deletionPlanner) visit(obj contrail.IObject, path []string) error {
+	uuid := obj.GetUuid()
+	path = append(path, fmt.Sprintf("%s %s", obj.GetType(), uuid))
+	if p.planned[uuid] {
+		return nil
+	}
+	if p.inProgress[uuid] {
+		return fmt.Errorf("Cycle in Contrail object dependencies: %s",
+			strings.Join(path, " -> "))
+	}
+	if !p.owned(obj) {
+		return fmt.Errorf("Refusing to delete %s %s, which isn't owned by the driver: %s",
+			obj.GetType(), strings.Join(obj.GetFQName(), ":"), strings.Join(path, " -> "))
+	}
+
+	p.inProgress[uuid] = true
+	// Object is fetched again, because the one we were given may have stale references.
+	stored, err := p.client.FindByUuid(obj.GetType(), uuid)
+	if err != nil {
+		return err
+	}
+	dependents, err := dependentObjects(p.client, stored)
+	if err != nil {
+		return err
+	}
+	for _, dependent := range dependents {
+		if err := p.visit(dependent, path); err != nil {
+			return err
+		}
+	}
+	delete(p.inProgress, uuid)
+
+	p.planned[uuid] = true
+	p.order = append(p.order, stored)
+	return nil
+}
+
+// deleteRecursive deletes the object together with everything that depends on it. Nothing is
+// deleted if planning fails.
+func deleteRecursive(ctx context.Context, client contrail.ApiClient, obj contrail.IObject,
+	owned func(contrail.IObject) bool) error {
+	logger := common.Logger(ctx)
+	plan, err := planDeletion(client, obj, owned)
+	if err != nil {
+		return err
+	}
+	for _, planned := range plan {
+		logger.Debugln("Deleting", planned.GetType(), planned.GetUuid())
+		if err := client.Delete(planned); err != nil {
+			if errorStatus(err) == 404 {
+				logger.Warnf("%s %s was already deleted", planned.GetType(), planned.GetUuid())
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// dependentGetter returns references to objects of one type that depend on an object.
+type dependentGetter struct {
+	typename string
+	get      func() (contrail.ReferenceList, error)
+}
+
+// dependentGetters lists getters of children of the object and of objects that refer to it, i.e.
+// of everything that has to be deleted before the object itself. It returns false for types
+// whose dependents aren't known, which can't be deleted recursively.
+func dependentGetters(obj contrail.IObject) ([]dependentGetter, bool) {
+	switch obj := obj.(type) {
+	case *types.VirtualMachine:
+		return []dependentGetter{
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaces},
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaceBackRefs},
+			{"virtual-router", obj.GetVirtualRouterBackRefs},
+		}, true
+	case *types.VirtualMachineInterface:
+		return []dependentGetter{
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaceBackRefs},
+			{"instance-ip", obj.GetInstanceIpBackRefs},
+			{"floating-ip", obj.GetFloatingIpBackRefs},
+			{"alias-ip", obj.GetAliasIpBackRefs},
+			{"logical-interface", obj.GetLogicalInterfaceBackRefs},
+			{"logical-router", obj.GetLogicalRouterBackRefs},
+			{"loadbalancer", obj.GetLoadbalancerBackRefs},
+			{"loadbalancer-pool", obj.GetLoadbalancerPoolBackRefs},
+			{"virtual-ip", obj.GetVirtualIpBackRefs},
+		}, true
+	case *types.InstanceIp:
+		return []dependentGetter{
+			{"floating-ip", obj.GetFloatingIps},
+			{"service-instance", obj.GetServiceInstanceBackRefs},
+		}, true
+	// Types below are never owned by the driver. They are listed for tests, which clean up
+	// whole projects.
+	case *types.Project:
+		return []dependentGetter{
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaces},
+			{"virtual-network", obj.GetVirtualNetworks},
+			{"network-ipam", obj.GetNetworkIpams},
+			{"network-policy", obj.GetNetworkPolicys},
+			{"security-group", obj.GetSecurityGroups},
+			{"floating-ip", obj.GetFloatingIpBackRefs},
+		}, true
+	case *types.VirtualNetwork:
+		return []dependentGetter{
+			{"access-control-list", obj.GetAccessControlLists},
+			{"floating-ip-pool", obj.GetFloatingIpPools},
+			{"routing-instance", obj.GetRoutingInstances},
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaceBackRefs},
+			{"instance-ip", obj.GetInstanceIpBackRefs},
+			{"logical-router", obj.GetLogicalRouterBackRefs},
+		}, true
+	case *types.NetworkIpam:
+		return []dependentGetter{
+			{"virtual-network", obj.GetVirtualNetworkBackRefs},
+		}, true
+	case *types.NetworkPolicy:
+		return []dependentGetter{
+			{"virtual-network", obj.GetVirtualNetworkBackRefs},
+		}, true
+	case *types.SecurityGroup:
+		return []dependentGetter{
+			{"access-control-list", obj.GetAccessControlLists},
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaceBackRefs},
+		}, true
+	case *types.RoutingInstance:
+		return []dependentGetter{
+			{"virtual-machine-interface", obj.GetVirtualMachineInterfaceBackRefs},
+		}, true
+	case *types.FloatingIpPool:
+		return []dependentGetter{
+			{"floating-ip", obj.GetFloatingIps},
+			{"project", obj.GetProjectBackRefs},
+		}, true
+	case *types.FloatingIp:
+		return []dependentGetter{
+			{"customer-attachment", obj.GetCustomerAttachmentBackRefs},
+		}, true
+	case *types.AccessControlList:
+		return nil, true
+	}
+	return nil, false
+}
+
+// dependentObjects returns children of the object and objects that refer to it, i.e. everything
+// that has to be deleted before the object itself.
+func dependentObjects(client contrail.ApiClient, obj contrail.IObject) ([]contrail.IObject,
+	error) {
+	getters, ok := dependentGetters(obj)
+	if !ok {
+		return nil, fmt.Errorf("Objects that depend on %s %s are unknown", obj.GetType(),
+			obj.GetUuid())
+	}
+	var dependents []contrail.IObject
+	for _, getter := range getters {
+		refs, err := getter.get()
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			dependent, err := client.FindByUuid(getter.typename, ref.Uuid)
+			if err != nil {
+				return nil, err
+			}
+			dependents = append(dependents, dependent)
+		}
+	}
+	return dependents, nil
+}
@@ -0,0 +1,125 @@
+//go:build fakes
+// +build fakes
+
+package controller
+
+import (
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deleting Contrail objects recursively", func() {
+
+	var client *ContrailController
+	var testNetwork *types.VirtualNetwork
+	var testInterface *types.VirtualMachineInterface
+	var testInstance *types.VirtualMachine
+	var testInstanceIP *types.InstanceIp
+
+	BeforeEach(func() {
+		var project *types.Project
+		client, project = NewMockedClientAndProject(domainName, tenantName)
+		testNetwork = CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
+			project)
+		testInterface = CreateMockedInterface(client.ApiClient, testNetwork, domainName,
+			tenantName, containerID)
+		testInstance = CreateMockedInstance(client.ApiClient, testInterface, containerID)
+		testInstanceIP = CreateMockedInstanceIP(client.ApiClient, tenantName, testInterface,
+			testNetwork)
+	})
+
+	uuids := func(objs []contrail.IObject) []string {
+		var result []string
+		for _, obj := range objs {
+			result = append(result, obj.GetUuid())
+		}
+		return result
+	}
+
+	exists := func(obj contrail.IObject) bool {
+		_, err := client.ApiClient.FindByUuid(obj.GetType(), obj.GetUuid())
+		return err == nil
+	}
+
+	It("plans deletion of dependents before objects they depend on", func() {
+		plan, err := client.PlanDeleteElementRecursive(ctx, testInstance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uuids(plan)).To(Equal([]string{testInstanceIP.GetUuid(),
+			testInterface.GetUuid(), testInstance.GetUuid()}))
+	})
+
+	It("doesn't delete anything in a dry run", func() {
+		_, err := client.PlanDeleteElementRecursive(ctx, testInstance)
+		Expect(err).ToNot(HaveOccurred())
+		for _, obj := range []contrail.IObject{testInstance, testInterface, testInstanceIP} {
+			Expect(exists(obj)).To(BeTrue())
+		}
+	})
+
+	It("refuses to delete objects that the driver doesn't own", func() {
+		err := client.DeleteElementRecursive(ctx, testNetwork)
+		Expect(err).To(HaveOccurred())
+		Expect(exists(testNetwork)).To(BeTrue())
+		Expect(exists(testInstanceIP)).To(BeTrue())
+	})
+
+	It("refuses to delete objects of owned types that the driver hasn't created", func() {
+		instance := new(types.VirtualMachine)
+		instance.SetName("created-by-orchestrator")
+		Expect(client.ApiClient.Create(instance)).To(Succeed())
+
+		err := client.DeleteElementRecursive(ctx, instance)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("isn't owned by the driver"))
+		Expect(exists(instance)).To(BeTrue())
+	})
+
+	It("refuses to delete objects that the driver on another host has created", func() {
+		client.Host = "other-host"
+		_, err := client.PlanDeleteElementRecursive(ctx, testInstance)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("isn't owned by the driver"))
+	})
+
+	It("refuses to delete anything if a dependent isn't owned by the driver", func() {
+		config := new(types.GlobalSystemConfig)
+		config.SetFQName("", []string{"default-global-system-config"})
+		Expect(client.ApiClient.Create(config)).To(Succeed())
+		router := new(types.VirtualRouter)
+		router.SetFQName("global-system-config", []string{"default-global-system-config",
+			"vrouter"})
+		Expect(router.AddVirtualMachine(testInstance)).To(Succeed())
+		Expect(client.ApiClient.Create(router)).To(Succeed())
+
+		err := client.DeleteElementRecursive(ctx, testInstance)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("virtual-router"))
+		for _, obj := range []contrail.IObject{testInstance, testInterface, testInstanceIP} {
+			Expect(exists(obj)).To(BeTrue())
+		}
+	})
+
+	It("detects cycles in dependencies", func() {
+		other := CreateMockedInterface(client.ApiClient, testNetwork, domainName, tenantName,
+			"other")
+		Expect(other.AddVirtualMachineInterface(testInterface)).To(Succeed())
+		Expect(client.ApiClient.Update(other)).To(Succeed())
+		Expect(testInterface.AddVirtualMachineInterface(other)).To(Succeed())
+		Expect(client.ApiClient.Update(testInterface)).To(Succeed())
+
+		_, err := client.PlanDeleteElementRecursive(ctx, testInstance)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Cycle"))
+	})
+
+	It("deletes objects that the driver doesn't own when forced to", func() {
+		Expect(ForceDeleteElementRecursive(client, testNetwork)).To(Succeed())
+		for _, obj := range []contrail.IObject{testNetwork, testInterface, testInstanceIP} {
+			Expect(exists(obj)).To(BeFalse())
+		}
+		// Instance doesn't depend on the network, only its interface does.
+		Expect(exists(testInstance)).To(BeTrue())
+	})
+})
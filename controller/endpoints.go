@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// DefaultHealthCheckInterval is how often Contrail API endpoints are checked by default.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// apiEndpoint is a single Contrail config API node.
+type apiEndpoint struct {
+	address string
+	healthy bool
+	lastErr error
+}
+
+// endpointPool keeps track of Contrail config API nodes and which of them are healthy. Requests
+// stick to the current endpoint until it fails.
+type endpointPool struct {
+	scheme string
+	client *http.Client
+
+	mutex     sync.Mutex
+	endpoints []*apiEndpoint
+	current   int
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func newEndpointPool(scheme string, addresses []string, base http.RoundTripper) *endpointPool {
+	pool := &endpointPool{
+		scheme:   scheme,
+		client:   &http.Client{Transport: base, Timeout: 5 * time.Second},
+		stopChan: make(chan struct{}),
+	}
+	for _, address := range addresses {
+		pool.endpoints = append(pool.endpoints, &apiEndpoint{address: address, healthy: true})
+	}
+	return pool
+}
+
+// pick returns the current endpoint if it's healthy, or the next healthy one. If none is
+// healthy, the current one is returned anyway, because health information may be stale.
+func (p *endpointPool) pick() *apiEndpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := 0; i < len(p.endpoints); i++ {
+		idx := (p.current + i) % len(p.endpoints)
+		if p.endpoints[idx].healthy {
+			p.current = idx
+			return p.endpoints[idx]
+		}
+	}
+	return p.endpoints[p.current]
+}
+
+// markFailed marks endpoint as unhealthy and moves on to the next one. Context is that of the
+// failed request.
+func (p *endpointPool) markFailed(ctx context.Context, endpoint *apiEndpoint, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if endpoint.healthy {
+		common.Logger(ctx).Warnf("Contrail API endpoint %s failed: %v", endpoint.address, err)
+	}
+	endpoint.healthy = false
+	endpoint.lastErr = err
+	if p.endpoints[p.current] == endpoint {
+		p.current = (p.current + 1) % len(p.endpoints)
+	}
+}
+
+func (p *endpointPool) setHealth(endpoint *apiEndpoint, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	healthy := err == nil
+	if healthy && !endpoint.healthy {
+		log.Infof("Contrail API endpoint %s is healthy again", endpoint.address)
+	} else if !healthy && endpoint.healthy {
+		log.Warnf("Contrail API endpoint %s failed health check: %v", endpoint.address, err)
+	}
+	endpoint.healthy = healthy
+	endpoint.lastErr = err
+}
+
+// isHealthy checks whether endpoint with given address is considered healthy.
+func (p *endpointPool) isHealthy(address string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, endpoint := range p.endpoints {
+		if endpoint.address == address {
+			return endpoint.healthy
+		}
+	}
+	return false
+}
+
+// contains checks whether address belongs to one of the endpoints.
+func (p *endpointPool) contains(address string) bool {
+	for _, endpoint := range p.endpoints {
+		if endpoint.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHealth sends a request to API root of every endpoint. Any response other than 5xx means
+// that the endpoint is alive; root may require authentication, which health checks don't send.
+func (p *endpointPool) checkHealth() {
+	for _, endpoint := range p.endpoints {
+		p.setHealth(endpoint, p.probe(endpoint))
+	}
+}
+
+func (p *endpointPool) probe(endpoint *apiEndpoint) error {
+	resp, err := p.client.Get(fmt.Sprintf("%s://%s/", p.scheme, endpoint.address))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// startHealthChecks checks endpoints periodically until stop is called.
+func (p *endpointPool) startHealthChecks(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkHealth()
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (p *endpointPool) stop() {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+}
+
+// failoverTransport sends requests to a healthy endpoint from the pool. On connection errors
+// and 5xx responses it marks the endpoint as failed and tries the next one. POSTs aren't
+// idempotent, so they're sent to the next endpoint only if they couldn't reach the failed one.
+type failoverTransport struct {
+	base http.RoundTripper
+	pool *endpointPool
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.pool.contains(req.URL.Host) {
+		// Not a Contrail API request, e.g. an href pointing somewhere else.
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := len(t.pool.endpoints)
+	for i := 0; ; i++ {
+		endpoint := t.pool.pick()
+		attempt := withEndpoint(cloneRequest(req, body), t.pool.scheme, endpoint.address)
+		resp, err := t.base.RoundTrip(attempt)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		failure := err
+		if failure == nil {
+			failure = errors.New(resp.Status)
+		}
+		t.pool.markFailed(req.Context(), endpoint, failure)
+		if i == attempts-1 || (req.Method == "POST" && !isDialError(err)) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+}
+
+// isDialError checks whether connection to the endpoint couldn't be established, so the request
+// wasn't sent.
+func isDialError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// splitAddress splits "host:port" address of an endpoint.
+func splitAddress(address string) (string, int, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid port in Contrail API address %s", address)
+	}
+	return host, portNumber, nil
+}
+
+// withEndpoint returns a shallow copy of the request with URL pointed at given endpoint.
+func withEndpoint(req *http.Request, scheme, address string) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	url := *req.URL
+	url.Scheme = scheme
+	url.Host = address
+	clone.URL = &url
+	clone.Host = ""
+	return clone
+}
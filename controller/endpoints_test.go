@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Contrail API failover", func() {
+
+	var keystone *fakeKeystone
+	var apis []*fakeContrailAPI
+	var c *ContrailController
+
+	BeforeEach(func() {
+		keystone = newFakeKeystone()
+		apis = nil
+		for i := 0; i < 3; i++ {
+			api := newFakeContrailAPI()
+			api.setValidTokens("token-1")
+			apis = append(apis, api)
+		}
+	})
+
+	JustBeforeEach(func() {
+		keys := &KeystoneEnvs{
+			os_auth_url:            keystone.authURL(),
+			os_username:            "admin",
+			os_user_domain_name:    "users",
+			os_password:            "secret123",
+			os_tenant_name:         "admin",
+			os_project_domain_name: "projects",
+		}
+		cfg := common.ControllerConfig{
+			Port:                8082,
+			HealthCheckInterval: 10 * time.Millisecond,
+		}
+		for _, api := range apis {
+			cfg.Endpoints = append(cfg.Endpoints, api.server.Listener.Addr().String())
+		}
+		var err error
+		c, err = NewController(cfg, keys)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		c.Close()
+		keystone.server.Close()
+		for _, api := range apis {
+			api.server.Close()
+		}
+	})
+
+	list := func() error {
+		_, err := c.ApiClient.List("virtual-network")
+		return err
+	}
+
+	requestCounts := func() []int {
+		var counts []int
+		for _, api := range apis {
+			counts = append(counts, len(api.receivedTokens()))
+		}
+		return counts
+	}
+
+	It("sends requests to the first endpoint while it's healthy", func() {
+		Expect(list()).To(Succeed())
+		Expect(list()).To(Succeed())
+		Expect(requestCounts()).To(Equal([]int{2, 0, 0}))
+	})
+
+	It("fails over when endpoint is stopped", func() {
+		Expect(list()).To(Succeed())
+		apis[0].server.Close()
+
+		Expect(list()).To(Succeed())
+		Expect(list()).To(Succeed())
+		Expect(requestCounts()).To(Equal([]int{1, 2, 0}))
+	})
+
+	It("fails over on 5xx responses", func() {
+		apis[0].setStatus(http.StatusServiceUnavailable)
+
+		Expect(list()).To(Succeed())
+		Expect(requestCounts()).To(Equal([]int{1, 1, 0}))
+	})
+
+	It("doesn't fail over on 4xx responses", func() {
+		apis[0].setValidTokens()
+		keystone.status = http.StatusUnauthorized
+
+		Expect(list()).ToNot(Succeed())
+		Expect(requestCounts()).To(Equal([]int{1, 0, 0}))
+	})
+
+	It("sends request body to the next endpoint", func() {
+		apis[0].server.Close()
+
+		net := new(types.VirtualNetwork)
+		net.SetFQName("project", []string{"default-domain", "admin", "network"})
+		Expect(c.ApiClient.Create(net)).To(Succeed())
+		Expect(net.GetUuid()).To(Equal("1234"))
+	})
+
+	It("doesn't send POST to the next endpoint after 5xx response", func() {
+		apis[0].setStatus(http.StatusServiceUnavailable)
+
+		net := new(types.VirtualNetwork)
+		net.SetFQName("project", []string{"default-domain", "admin", "network"})
+		Expect(c.ApiClient.Create(net)).ToNot(Succeed())
+		Expect(requestCounts()).To(Equal([]int{1, 0, 0}))
+	})
+
+	It("returns error when all endpoints are down", func() {
+		for _, api := range apis {
+			api.server.Close()
+		}
+		Expect(list()).ToNot(Succeed())
+	})
+
+	It("skips endpoints that fail health checks", func() {
+		apis[1].setStatus(http.StatusInternalServerError)
+		Eventually(func() bool {
+			return !c.endpoints.isHealthy(apis[1].server.Listener.Addr().String())
+		}).Should(BeTrue())
+
+		apis[0].server.Close()
+		Expect(list()).To(Succeed())
+		Expect(requestCounts()).To(Equal([]int{0, 0, 1}))
+	})
+
+	It("returns to endpoint that is healthy again", func() {
+		apis[0].setStatus(http.StatusServiceUnavailable)
+		Expect(list()).To(Succeed())
+
+		apis[0].setStatus(0)
+		Eventually(func() bool {
+			return c.endpoints.isHealthy(apis[0].server.Listener.Addr().String())
+		}).Should(BeTrue())
+
+		apis[1].server.Close()
+		apis[2].server.Close()
+		Expect(list()).To(Succeed())
+		Expect(requestCounts()).To(Equal([]int{2, 1, 0}))
+	})
+})
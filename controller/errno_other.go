@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package controller
+
+import "syscall"
+
+// Errors of connections refused or reset by the API server.
+const (
+	errnoConnRefused = syscall.ECONNREFUSED
+	errnoConnReset   = syscall.ECONNRESET
+)
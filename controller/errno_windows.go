@@ -0,0 +1,9 @@
+package controller
+
+import "syscall"
+
+// Winsock errors of connections refused or reset by the API server.
+const (
+	errnoConnRefused = syscall.Errno(10061) // WSAECONNREFUSED
+	errnoConnReset   = syscall.WSAECONNRESET
+)
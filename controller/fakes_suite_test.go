@@ -0,0 +1,22 @@
+//go:build fakes
+// +build fakes
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+// TestControllerWithFakes runs specs that use the fake controller, mocked API client or local
+// HTTP servers instead of actual Contrail. They are built with -tags fakes into a suite of their
+// own that doesn't connect to Contrail.
+func TestControllerWithFakes(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("controller_fakes_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Controller client with fakes test suite",
+		[]Reporter{junitReporter})
+}
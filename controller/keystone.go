@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// DefaultTokenRefreshMargin is how long before expiry Keystone tokens are refreshed by default.
+const DefaultTokenRefreshMargin = 5 * time.Minute
+
+// keystoneSession keeps a Keystone token and adds it to Contrail API requests. The token is
+// replaced when it's about to expire, or when Contrail API rejects it. Keystone clients of
+// different identity API versions provide newToken.
+type keystoneSession struct {
+	httpClient    *http.Client
+	refreshMargin time.Duration
+	// newToken obtains a new token from Keystone. Zero expiry time means that it's unknown.
+	newToken func() (token string, expiresAt time.Time, err error)
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newKeystoneSession(refreshMargin time.Duration) keystoneSession {
+	if refreshMargin == 0 {
+		refreshMargin = DefaultTokenRefreshMargin
+	}
+	return keystoneSession{
+		httpClient:    http.DefaultClient,
+		refreshMargin: refreshMargin,
+	}
+}
+
+// SetHTTPClient replaces the HTTP client used to talk to Keystone, e.g. to configure TLS.
+func (s *keystoneSession) SetHTTPClient(httpClient *http.Client) {
+	s.httpClient = httpClient
+}
+
+// Authenticate obtains a new token from Keystone.
+func (s *keystoneSession) Authenticate() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.authenticate()
+}
+
+func (s *keystoneSession) authenticate() error {
+	token, expiresAt, err := s.newToken()
+	if err != nil {
+		return err
+	}
+	s.token = token
+	s.expiresAt = expiresAt
+	return nil
+}
+
+// AddAuthentication adds the token to Contrail API request, authenticating first if there's
+// no token yet or if the current one is about to expire.
+func (s *keystoneSession) AddAuthentication(req *http.Request) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token == "" {
+		if err := s.authenticate(); err != nil {
+			return err
+		}
+	} else if s.expiresSoon() {
+		logger := common.Logger(req.Context())
+		logger.Infoln("Keystone token expires at", s.expiresAt.Format(time.RFC3339),
+			"- refreshing it")
+		if err := s.authenticate(); err != nil {
+			if !time.Now().Before(s.expiresAt) {
+				return fmt.Errorf("Keystone token expired and refreshing it failed: %v", err)
+			}
+			// The old token is still valid, so we'll try again with the next request.
+			logger.Warnln("Failed to refresh Keystone token, using the current one:", err)
+		}
+	}
+	req.Header.Set("X-Auth-Token", s.token)
+	return nil
+}
+
+func (s *keystoneSession) expiresSoon() bool {
+	if s.expiresAt.IsZero() {
+		return false
+	}
+	return time.Now().Add(s.refreshMargin).After(s.expiresAt)
+}
+
+// Reauthenticate obtains a new token after Contrail API has rejected the given one. Nothing is
+// done if the token has already been replaced, e.g. by a concurrent request.
+func (s *keystoneSession) Reauthenticate(rejectedToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && s.token != rejectedToken {
+		return nil
+	}
+	return s.authenticate()
+}
+
+// parseTokenExpiry parses expiry time of a Keystone token. Empty string means unknown expiry.
+func parseTokenExpiry(expires string) (time.Time, error) {
+	if expires == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Malformed Keystone token expiry time: %v", err)
+	}
+	return expiresAt, nil
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// keystoneV2Client is a client of Keystone identity API v2.0. It sends the same requests as
+// contrail.KeystoneClient, but its tokens are refreshed like the v3 ones and its HTTP client can
+// be configured.
+type keystoneV2Client struct {
+	keystoneSession
+	authURL    string
+	tenantName string
+	username   string
+	password   string
+	adminToken string
+}
+
+func newKeystoneV2Client(authURL, tenantName, username, password, token string,
+	refreshMargin time.Duration) *keystoneV2Client {
+	k := &keystoneV2Client{
+		keystoneSession: newKeystoneSession(refreshMargin),
+		authURL:         authURL,
+		tenantName:      tenantName,
+		username:        username,
+		password:        password,
+		adminToken:      token,
+	}
+	k.newToken = k.requestToken
+	return k
+}
+
+type keystoneV2AuthTokenRequest struct {
+	Auth struct {
+		Token struct {
+			ID string `json:"id"`
+		} `json:"token"`
+	} `json:"auth"`
+}
+
+type keystoneV2AuthCredentialsRequest struct {
+	Auth struct {
+		TenantName          string `json:"tenantName"`
+		PasswordCredentials struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"passwordCredentials"`
+	} `json:"auth"`
+}
+
+type keystoneV2TokenResponse struct {
+	Access struct {
+		Token struct {
+			ID      string `json:"id"`
+			Expires string `json:"expires"`
+		} `json:"token"`
+	} `json:"access"`
+}
+
+func (k *keystoneV2Client) requestToken() (string, time.Time, error) {
+	var expiresAt time.Time
+	if k.authURL == "" {
+		return "", expiresAt, errors.New("Empty Keystone auth URL")
+	}
+
+	var authReq interface{}
+	if k.adminToken != "" {
+		tokenReq := &keystoneV2AuthTokenRequest{}
+		tokenReq.Auth.Token.ID = k.adminToken
+		authReq = tokenReq
+	} else {
+		credentialsReq := &keystoneV2AuthCredentialsRequest{}
+		credentialsReq.Auth.TenantName = k.tenantName
+		credentialsReq.Auth.PasswordCredentials.Username = k.username
+		credentialsReq.Auth.PasswordCredentials.Password = k.password
+		authReq = credentialsReq
+	}
+	data, err := json.Marshal(authReq)
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	url := strings.TrimSuffix(k.authURL, "/") + "/tokens"
+	resp, err := k.httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", expiresAt, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", expiresAt, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", expiresAt, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	var tokenResp keystoneV2TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", expiresAt, err
+	}
+	if tokenResp.Access.Token.ID == "" {
+		return "", expiresAt, errors.New("Keystone response has no token")
+	}
+	if expiresAt, err = parseTokenExpiry(tokenResp.Access.Token.Expires); err != nil {
+		return "", expiresAt, err
+	}
+
+	log.Infoln("Obtained Keystone token for tenant", k.tenantName, "expiring at",
+		tokenResp.Access.Token.Expires)
+	return tokenResp.Access.Token.ID, expiresAt, nil
+}
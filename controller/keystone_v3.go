@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// KeystoneV3Config holds credentials for Keystone identity API v3. Exactly one authentication
+// method is used, in this order of preference: token, application credential, password.
+type KeystoneV3Config struct {
+	AuthURL string
+
+	// Token is an existing token that is exchanged for a project-scoped one.
+	Token string
+
+	// Application credentials are scoped to a project by Keystone, so project settings are
+	// ignored when they are used. Credential can be identified by ID, or by name together with
+	// user name and user domain name.
+	ApplicationCredentialID     string
+	ApplicationCredentialName   string
+	ApplicationCredentialSecret string
+
+	Username       string
+	UserDomainName string
+	Password       string
+
+	ProjectName       string
+	ProjectDomainName string
+
+	// RefreshMargin is how long before expiry the token is replaced with a new one. Zero means
+	// DefaultTokenRefreshMargin.
+	RefreshMargin time.Duration
+}
+
+// KeystoneV3Client is a client of Keystone identity API v3 that adds project-scoped tokens
+// to Contrail API requests. Tokens are refreshed when they are about to expire, or when Contrail
+// API rejects them.
+type KeystoneV3Client struct {
+	keystoneSession
+	config KeystoneV3Config
+}
+
+func NewKeystoneV3Client(cfg KeystoneV3Config) *KeystoneV3Client {
+	k := &KeystoneV3Client{
+		keystoneSession: newKeystoneSession(cfg.RefreshMargin),
+		config:          cfg,
+	}
+	k.newToken = k.requestToken
+	return k
+}
+
+type keystoneV3Domain struct {
+	Name string `json:"name"`
+}
+
+type keystoneV3User struct {
+	Name     string            `json:"name"`
+	Domain   *keystoneV3Domain `json:"domain,omitempty"`
+	Password string            `json:"password,omitempty"`
+}
+
+type keystoneV3Password struct {
+	User keystoneV3User `json:"user"`
+}
+
+type keystoneV3Token struct {
+	ID string `json:"id"`
+}
+
+type keystoneV3ApplicationCredential struct {
+	ID     string          `json:"id,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Secret string          `json:"secret"`
+	User   *keystoneV3User `json:"user,omitempty"`
+}
+
+type keystoneV3Project struct {
+	Name   string           `json:"name"`
+	Domain keystoneV3Domain `json:"domain"`
+}
+
+type keystoneV3Scope struct {
+	Project keystoneV3Project `json:"project"`
+}
+
+type keystoneV3AuthRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods               []string                         `json:"methods"`
+			Password              *keystoneV3Password              `json:"password,omitempty"`
+			Token                 *keystoneV3Token                 `json:"token,omitempty"`
+			ApplicationCredential *keystoneV3ApplicationCredential `json:"application_credential,omitempty"`
+		} `json:"identity"`
+		Scope *keystoneV3Scope `json:"scope,omitempty"`
+	} `json:"auth"`
+}
+
+type keystoneV3TokenResponse struct {
+	Token struct {
+		ExpiresAt string `json:"expires_at"`
+		Project   *struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"project"`
+	} `json:"token"`
+}
+
+func (k *KeystoneV3Client) authRequest() (*keystoneV3AuthRequest, error) {
+	cfg := &k.config
+	req := &keystoneV3AuthRequest{}
+	identity := &req.Auth.Identity
+
+	switch {
+	case cfg.Token != "":
+		identity.Methods = []string{"token"}
+		identity.Token = &keystoneV3Token{ID: cfg.Token}
+	case cfg.ApplicationCredentialID != "" || cfg.ApplicationCredentialName != "":
+		if cfg.ApplicationCredentialSecret == "" {
+			return nil, errors.New("Keystone application credential secret is empty")
+		}
+		identity.Methods = []string{"application_credential"}
+		identity.ApplicationCredential = &keystoneV3ApplicationCredential{
+			ID:     cfg.ApplicationCredentialID,
+			Secret: cfg.ApplicationCredentialSecret,
+		}
+		if cfg.ApplicationCredentialID == "" {
+			if cfg.Username == "" || cfg.UserDomainName == "" {
+				return nil, errors.New("Keystone application credential name requires user " +
+					"name and user domain name")
+			}
+			identity.ApplicationCredential.Name = cfg.ApplicationCredentialName
+			identity.ApplicationCredential.User = &keystoneV3User{
+				Name:   cfg.Username,
+				Domain: &keystoneV3Domain{Name: cfg.UserDomainName},
+			}
+		}
+		// Application credentials are already scoped to a project.
+		return req, nil
+	default:
+		if cfg.Username == "" || cfg.UserDomainName == "" {
+			return nil, errors.New("Keystone user name and user domain name must not be empty")
+		}
+		identity.Methods = []string{"password"}
+		identity.Password = &keystoneV3Password{
+			User: keystoneV3User{
+				Name:     cfg.Username,
+				Domain:   &keystoneV3Domain{Name: cfg.UserDomainName},
+				Password: cfg.Password,
+			},
+		}
+	}
+
+	if cfg.ProjectName == "" || cfg.ProjectDomainName == "" {
+		return nil, errors.New("Keystone project name and project domain name must not be empty")
+	}
+	req.Auth.Scope = &keystoneV3Scope{
+		Project: keystoneV3Project{
+			Name:   cfg.ProjectName,
+			Domain: keystoneV3Domain{Name: cfg.ProjectDomainName},
+		},
+	}
+	return req, nil
+}
+
+// requestToken obtains a new project-scoped token from Keystone.
+func (k *KeystoneV3Client) requestToken() (string, time.Time, error) {
+	var expiresAt time.Time
+	if k.config.AuthURL == "" {
+		return "", expiresAt, errors.New("Empty Keystone auth URL")
+	}
+
+	authReq, err := k.authRequest()
+	if err != nil {
+		return "", expiresAt, err
+	}
+	data, err := json.Marshal(authReq)
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	url := strings.TrimSuffix(k.config.AuthURL, "/") + "/auth/tokens"
+	resp, err := k.httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", expiresAt, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", expiresAt, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", expiresAt, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+
+	token := resp.Header.Get("X-Subject-Token")
+	if token == "" {
+		return "", expiresAt, errors.New("Keystone response has no X-Subject-Token header")
+	}
+
+	var tokenResp keystoneV3TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", expiresAt, err
+	}
+	if tokenResp.Token.Project == nil {
+		return "", expiresAt, errors.New("Keystone issued a token that is not scoped to a project")
+	}
+	if expiresAt, err = parseTokenExpiry(tokenResp.Token.ExpiresAt); err != nil {
+		return "", expiresAt, err
+	}
+
+	log.Infoln("Obtained Keystone token for project", tokenResp.Token.Project.Name,
+		"expiring at", tokenResp.Token.ExpiresAt)
+	return token, expiresAt, nil
+}
@@ -0,0 +1,306 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeKeystone is a local HTTP stand-in for Keystone identity API v3. It records received
+// authentication requests and issues tokens "token-1", "token-2" and so on, valid for lifetime.
+type fakeKeystone struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	requests []map[string]interface{}
+	status   int
+	unscoped bool
+	noHeader bool
+	lifetime time.Duration
+}
+
+func newFakeKeystone() *fakeKeystone {
+	k := newUnstartedFakeKeystone()
+	k.server.Start()
+	return k
+}
+
+// newUnstartedFakeKeystone returns fake Keystone whose server can be configured, e.g. to use
+// TLS, before it's started.
+func newUnstartedFakeKeystone() *fakeKeystone {
+	k := &fakeKeystone{status: http.StatusCreated, lifetime: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/auth/tokens", k.handleTokens)
+	k.server = httptest.NewUnstartedServer(mux)
+	return k
+}
+
+func (k *fakeKeystone) authURL() string {
+	return k.server.URL + "/v3"
+}
+
+func (k *fakeKeystone) handleTokens(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	k.requests = append(k.requests, req)
+
+	if k.status != http.StatusCreated {
+		w.WriteHeader(k.status)
+		w.Write([]byte(`{"error": {"message": "The request you have made requires authentication."}}`))
+		return
+	}
+
+	token := map[string]interface{}{
+		"expires_at": time.Now().Add(k.lifetime).UTC().Format("2006-01-02T15:04:05.000000Z"),
+	}
+	if !k.unscoped {
+		token["project"] = map[string]interface{}{"id": "1234", "name": "admin"}
+	}
+	if !k.noHeader {
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", len(k.requests)))
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": token})
+}
+
+func (k *fakeKeystone) lastRequest() map[string]interface{} {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	Expect(k.requests).ToNot(BeEmpty())
+	return k.requests[len(k.requests)-1]
+}
+
+func (k *fakeKeystone) requestCount() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return len(k.requests)
+}
+
+// field returns value at given path in decoded JSON object.
+func field(obj interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = m[key]
+	}
+	return obj
+}
+
+var _ = Describe("Keystone v3 client", func() {
+
+	var keystone *fakeKeystone
+	var cfg KeystoneV3Config
+
+	BeforeEach(func() {
+		keystone = newFakeKeystone()
+		cfg = KeystoneV3Config{
+			AuthURL:           keystone.authURL(),
+			Username:          "admin",
+			UserDomainName:    "users",
+			Password:          "secret123",
+			ProjectName:       "admin",
+			ProjectDomainName: "projects",
+		}
+	})
+
+	AfterEach(func() {
+		keystone.server.Close()
+	})
+
+	addAuthentication := func(client *KeystoneV3Client) (string, error) {
+		req, err := http.NewRequest("GET", "http://contrail:8082/virtual-networks", nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = client.AddAuthentication(req)
+		return req.Header.Get("X-Auth-Token"), err
+	}
+
+	It("authenticates with password and domain names, scoped to project", func() {
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		req := keystone.lastRequest()
+		identity := field(req, "auth", "identity")
+		Expect(field(identity, "methods")).To(Equal([]interface{}{"password"}))
+		Expect(field(identity, "password", "user", "name")).To(Equal("admin"))
+		Expect(field(identity, "password", "user", "domain", "name")).To(Equal("users"))
+		Expect(field(identity, "password", "user", "password")).To(Equal("secret123"))
+		Expect(field(req, "auth", "scope", "project", "name")).To(Equal("admin"))
+		Expect(field(req, "auth", "scope", "project", "domain", "name")).To(Equal("projects"))
+	})
+
+	It("adds token from X-Subject-Token header to requests", func() {
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		token, err := addAuthentication(client)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("token-1"))
+		Expect(keystone.requestCount()).To(Equal(1))
+	})
+
+	It("authenticates lazily when adding authentication to a request", func() {
+		client := NewKeystoneV3Client(cfg)
+		token, err := addAuthentication(client)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("token-1"))
+	})
+
+	It("authenticates with application credential ID without scope", func() {
+		cfg.ApplicationCredentialID = "appcred"
+		cfg.ApplicationCredentialSecret = "appsecret"
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		req := keystone.lastRequest()
+		identity := field(req, "auth", "identity")
+		Expect(field(identity, "methods")).To(Equal([]interface{}{"application_credential"}))
+		Expect(field(identity, "application_credential", "id")).To(Equal("appcred"))
+		Expect(field(identity, "application_credential", "secret")).To(Equal("appsecret"))
+		Expect(field(identity, "password")).To(BeNil())
+		Expect(field(req, "auth", "scope")).To(BeNil())
+	})
+
+	It("authenticates with application credential name together with user", func() {
+		cfg.ApplicationCredentialName = "appcred"
+		cfg.ApplicationCredentialSecret = "appsecret"
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		appCred := field(keystone.lastRequest(), "auth", "identity", "application_credential")
+		Expect(field(appCred, "name")).To(Equal("appcred"))
+		Expect(field(appCred, "user", "name")).To(Equal("admin"))
+		Expect(field(appCred, "user", "domain", "name")).To(Equal("users"))
+	})
+
+	It("exchanges existing token for a project-scoped one", func() {
+		cfg.Token = "unscoped"
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		req := keystone.lastRequest()
+		Expect(field(req, "auth", "identity", "methods")).To(Equal([]interface{}{"token"}))
+		Expect(field(req, "auth", "identity", "token", "id")).To(Equal("unscoped"))
+		Expect(field(req, "auth", "scope", "project", "name")).To(Equal("admin"))
+	})
+
+	DescribeTable("returns error without contacting Keystone when credentials are incomplete",
+		func(modify func(*KeystoneV3Config)) {
+			modify(&cfg)
+			client := NewKeystoneV3Client(cfg)
+			Expect(client.Authenticate()).ToNot(Succeed())
+			Expect(keystone.requestCount()).To(Equal(0))
+		},
+		Entry("no user domain", func(c *KeystoneV3Config) { c.UserDomainName = "" }),
+		Entry("no project domain", func(c *KeystoneV3Config) { c.ProjectDomainName = "" }),
+		Entry("no application credential secret", func(c *KeystoneV3Config) {
+			c.ApplicationCredentialID = "appcred"
+		}),
+		Entry("no auth URL", func(c *KeystoneV3Config) { c.AuthURL = "" }),
+	)
+
+	It("returns error when Keystone rejects credentials", func() {
+		keystone.status = http.StatusUnauthorized
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).ToNot(Succeed())
+		_, err := addAuthentication(client)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns error when issued token is not scoped to a project", func() {
+		keystone.unscoped = true
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).ToNot(Succeed())
+	})
+
+	It("returns error when response has no X-Subject-Token", func() {
+		keystone.noHeader = true
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).ToNot(Succeed())
+	})
+
+	Context("when token is about to expire", func() {
+		BeforeEach(func() {
+			keystone.lifetime = time.Minute
+			cfg.RefreshMargin = 5 * time.Minute
+		})
+
+		It("obtains a new token before adding it to request", func() {
+			client := NewKeystoneV3Client(cfg)
+			Expect(client.Authenticate()).To(Succeed())
+
+			token, err := addAuthentication(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("token-2"))
+		})
+
+		It("keeps using the current token if refreshing it fails", func() {
+			client := NewKeystoneV3Client(cfg)
+			Expect(client.Authenticate()).To(Succeed())
+
+			keystone.status = http.StatusServiceUnavailable
+			token, err := addAuthentication(client)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("token-1"))
+			Expect(keystone.requestCount()).To(Equal(2))
+		})
+
+		It("returns error if token has expired and refreshing it fails", func() {
+			keystone.lifetime = -time.Minute
+			client := NewKeystoneV3Client(cfg)
+			Expect(client.Authenticate()).To(Succeed())
+
+			keystone.status = http.StatusServiceUnavailable
+			_, err := addAuthentication(client)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("doesn't refresh token that is far from expiry", func() {
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+		for i := 0; i < 3; i++ {
+			_, err := addAuthentication(client)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(keystone.requestCount()).To(Equal(1))
+	})
+
+	It("reauthenticates only once when the same token is rejected twice", func() {
+		client := NewKeystoneV3Client(cfg)
+		Expect(client.Authenticate()).To(Succeed())
+
+		Expect(client.Reauthenticate("token-1")).To(Succeed())
+		Expect(client.Reauthenticate("token-1")).To(Succeed())
+		Expect(keystone.requestCount()).To(Equal(2))
+	})
+
+	Specify("NewController uses v3 when auth URL ends with /v3", func() {
+		keys := &KeystoneEnvs{
+			os_auth_url:            keystone.authURL(),
+			os_username:            "admin",
+			os_user_domain_name:    "users",
+			os_password:            "secret123",
+			os_tenant_name:         "admin",
+			os_project_domain_name: "projects",
+		}
+		_, err := NewController(common.DefaultConfig().Controller, keys)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keystone.requestCount()).To(Equal(1))
+	})
+})
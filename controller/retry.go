@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/metrics"
+)
+
+// statusPattern matches errors that contrail.Client returns for non-200 responses.
+var statusPattern = regexp.MustCompile(`^(\d{3}) `)
+
+// errorStatus returns HTTP status code of a Contrail API error, or 0 if it's not a HTTP error.
+func errorStatus(err error) int {
+	match := statusPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	status, _ := strconv.Atoi(match[1])
+	return status
+}
+
+// isRetryable checks whether failed call should be attempted again according to the policy.
+func isRetryable(policy *common.RetryConfig, err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		return isTransient(urlErr.Err)
+	}
+	if _, ok := err.(net.Error); ok {
+		return isTransient(err)
+	}
+	status := errorStatus(err)
+	for _, retryable := range policy.RetryableStatusCodes {
+		if status == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient checks whether a connection error may not happen on the next attempt: it's a
+// timeout or a temporary error, or the connection was refused or reset by the API server.
+func isTransient(err error) bool {
+	if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+	errno, ok := connectionErrno(err)
+	return ok && (errno == errnoConnRefused || errno == errnoConnReset)
+}
+
+// hasUnknownOutcome checks whether a failed request may have been processed by the API server
+// anyway: it timed out, or the connection was reset before the response came.
+func hasUnknownOutcome(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	errno, ok := connectionErrno(err)
+	return ok && errno == errnoConnReset
+}
+
+// connectionErrno returns system error code of a connection error.
+func connectionErrno(err error) (syscall.Errno, bool) {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return errno, ok
+}
+
+// backoff returns delay before the next attempt after given number of failed ones.
+func backoff(policy *common.RetryConfig, failedAttempts int) time.Duration {
+	delay := policy.InitialBackoff
+	for i := 1; i < failedAttempts && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay - time.Duration(rand.Float64()*policy.Jitter*float64(delay))
+}
+
+// objectClient is the part of contrail.Client that objects use to lazily fetch their fields
+// and update references.
+type objectClient interface {
+	GetField(contrail.IObject, string) error
+	UpdateReference(*contrail.ReferenceUpdateMsg) error
+}
+
+// retryingClient is a contrail.ApiClient that retries failed calls with exponential backoff.
+// Time of each attempt is limited by the transport of the wrapped client, which cancels requests
+// after call timeout, so an attempt has always finished before the next one starts. Objects that
+// it returns use it for lazy calls too, as long as the wrapped client supports them.
+type retryingClient struct {
+	inner  contrail.ApiClient
+	policy common.RetryConfig
+	// ctx carries the logger of the plugin request that calls are made for.
+	ctx context.Context
+}
+
+func newRetryingClient(inner contrail.ApiClient, policy common.RetryConfig) *retryingClient {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &retryingClient{inner: inner, policy: policy, ctx: context.Background()}
+}
+
+// withContext returns a copy of the client that logs with the logger of given context. If the
+// wrapped client is a contrail.Client, requests that it sends carry the context too, so that
+// transports log with that logger as well.
+func (c *retryingClient) withContext(ctx context.Context) *retryingClient {
+	bound := *c
+	bound.ctx = ctx
+	if client, ok := c.inner.(*contrail.Client); ok {
+		inner := *client
+		inner.SetAuthenticator(contextAuthenticator{ctx: ctx})
+		bound.inner = &inner
+	}
+	return &bound
+}
+
+// contextAuthenticator is the authenticator of contrail.Client, which calls it for every request
+// it builds. It doesn't authenticate requests, which is done by apiTransport, but sets their
+// context.
+type contextAuthenticator struct {
+	ctx context.Context
+}
+
+func (a contextAuthenticator) AddAuthentication(req *http.Request) error {
+	*req = *req.WithContext(a.ctx)
+	return nil
+}
+
+// call runs fn until it succeeds, fails with an error that's not retryable, or runs out of
+// attempts. It returns result of the last attempt. Operation and type of the object are used in
+// logs and metrics; typename is empty if it's unknown.
+func (c *retryingClient) call(operation, typename string,
+	fn func(attempt int) (interface{}, error)) (interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		result, err := fn(attempt)
+		metrics.ContrailAPICallDuration.ObserveDuration(start, operation, typename)
+		if err == nil {
+			return result, nil
+		}
+		metrics.ContrailAPIErrors.Inc(operation, typename)
+		if attempt >= c.policy.MaxAttempts || !isRetryable(&c.policy, err) {
+			return result, err
+		}
+		delay := backoff(&c.policy, attempt)
+		common.Logger(c.ctx).Warnf("Contrail API %s %s failed (attempt %d of %d), retrying in "+
+			"%v: %v", operation, typename, attempt, c.policy.MaxAttempts, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// own makes object use this client for lazy calls.
+func (c *retryingClient) own(obj contrail.IObject) {
+	if _, ok := c.inner.(objectClient); ok {
+		obj.SetClient(c)
+	}
+}
+
+// adopt makes ptr refer to an existing object with the same name. It's used when a create,
+// which failed, has actually succeeded.
+func (c *retryingClient) adopt(ptr, existing contrail.IObject) error {
+	fields := map[string]interface{}{
+		"fq_name": existing.GetFQName(),
+		"uuid":    existing.GetUuid(),
+		"name":    existing.GetName(),
+	}
+	if existing.GetHref() != "" {
+		fields["href"] = existing.GetHref()
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, ptr); err != nil {
+		return err
+	}
+	c.own(ptr)
+	return nil
+}
+
+// Create is not idempotent, so after an attempt whose outcome is unknown, the object is looked
+// up by name before it's created again, and a conflict with an object of the same name means
+// that the attempt has created it. Otherwise a conflict is returned, as the object was created
+// by someone else.
+func (c *retryingClient) Create(ptr contrail.IObject) error {
+	typename := ptr.GetType()
+	fqName := strings.Join(ptr.GetFQName(), ":")
+	logger := common.Logger(c.ctx)
+	outcomeUnknown := false
+	_, err := c.call("create", typename, func(attempt int) (interface{}, error) {
+		if outcomeUnknown {
+			if existing, err := c.inner.FindByName(typename, fqName); err == nil {
+				logger.Infof("%s %s was created by a previous attempt", typename, fqName)
+				return nil, c.adopt(ptr, existing)
+			}
+		}
+		err := c.inner.Create(ptr)
+		if err != nil && outcomeUnknown && errorStatus(err) == 409 {
+			existing, findErr := c.inner.FindByName(typename, fqName)
+			if findErr != nil {
+				return nil, err
+			}
+			logger.Infof("%s %s was created by a previous attempt", typename, fqName)
+			return nil, c.adopt(ptr, existing)
+		}
+		if err != nil && hasUnknownOutcome(err) {
+			outcomeUnknown = true
+		}
+		if err == nil {
+			c.own(ptr)
+		}
+		return nil, err
+	})
+	return err
+}
+
+func (c *retryingClient) Update(ptr contrail.IObject) error {
+	_, err := c.call("update", ptr.GetType(), func(int) (interface{}, error) {
+		return nil, c.inner.Update(ptr)
+	})
+	return err
+}
+
+// isNotFoundOnRetry checks whether a delete failed only because a previous attempt removed the
+// object.
+func isNotFoundOnRetry(err error, attempt int) bool {
+	return err != nil && attempt > 1 && errorStatus(err) == 404
+}
+
+func (c *retryingClient) DeleteByUuid(typename, uuid string) error {
+	_, err := c.call("delete", typename, func(attempt int) (interface{}, error) {
+		err := c.inner.DeleteByUuid(typename, uuid)
+		if isNotFoundOnRetry(err, attempt) {
+			return nil, nil
+		}
+		return nil, err
+	})
+	return err
+}
+
+func (c *retryingClient) Delete(ptr contrail.IObject) error {
+	_, err := c.call("delete", ptr.GetType(), func(attempt int) (interface{}, error) {
+		err := c.inner.Delete(ptr)
+		if isNotFoundOnRetry(err, attempt) {
+			return nil, nil
+		}
+		return nil, err
+	})
+	return err
+}
+
+func (c *retryingClient) FindByUuid(typename string, uuid string) (contrail.IObject, error) {
+	result, err := c.call("find", typename, func(int) (interface{}, error) {
+		return c.inner.FindByUuid(typename, uuid)
+	})
+	return c.object(result), err
+}
+
+func (c *retryingClient) UuidByName(typename string, fqn string) (string, error) {
+	result, err := c.call("find", typename, func(int) (interface{}, error) {
+		return c.inner.UuidByName(typename, fqn)
+	})
+	uuid, _ := result.(string)
+	return uuid, err
+}
+
+func (c *retryingClient) FQNameByUuid(uuid string) ([]string, error) {
+	result, err := c.call("find name", "", func(int) (interface{}, error) {
+		return c.inner.FQNameByUuid(uuid)
+	})
+	fqName, _ := result.([]string)
+	return fqName, err
+}
+
+func (c *retryingClient) FindByName(typename string, fqn string) (contrail.IObject, error) {
+	result, err := c.call("find", typename, func(int) (interface{}, error) {
+		return c.inner.FindByName(typename, fqn)
+	})
+	return c.object(result), err
+}
+
+func (c *retryingClient) List(typename string) ([]contrail.ListResult, error) {
+	result, err := c.call("list", typename, func(int) (interface{}, error) {
+		return c.inner.List(typename)
+	})
+	results, _ := result.([]contrail.ListResult)
+	return results, err
+}
+
+func (c *retryingClient) ListByParent(typename string, parentID string) ([]contrail.ListResult,
+	error) {
+	result, err := c.call("list", typename, func(int) (interface{}, error) {
+		return c.inner.ListByParent(typename, parentID)
+	})
+	results, _ := result.([]contrail.ListResult)
+	return results, err
+}
+
+func (c *retryingClient) ListDetail(typename string, fields []string) ([]contrail.IObject,
+	error) {
+	result, err := c.call("list", typename, func(int) (interface{}, error) {
+		return c.inner.ListDetail(typename, fields)
+	})
+	return c.objects(result), err
+}
+
+func (c *retryingClient) ListDetailByParent(typename string, parentID string,
+	fields []string) ([]contrail.IObject, error) {
+	result, err := c.call("list", typename, func(int) (interface{}, error) {
+		return c.inner.ListDetailByParent(typename, parentID, fields)
+	})
+	return c.objects(result), err
+}
+
+// object converts result of a call to an object owned by this client.
+func (c *retryingClient) object(result interface{}) contrail.IObject {
+	obj, _ := result.(contrail.IObject)
+	if obj != nil {
+		c.own(obj)
+	}
+	return obj
+}
+
+func (c *retryingClient) objects(result interface{}) []contrail.IObject {
+	objs, _ := result.([]contrail.IObject)
+	for _, obj := range objs {
+		c.own(obj)
+	}
+	return objs
+}
+
+func (c *retryingClient) GetField(obj contrail.IObject, field string) error {
+	client, ok := c.inner.(objectClient)
+	if !ok {
+		return fmt.Errorf("Contrail API client %T can't read object fields", c.inner)
+	}
+	_, err := c.call("read", obj.GetType(), func(int) (interface{}, error) {
+		return nil, client.GetField(obj, field)
+	})
+	return err
+}
+
+func (c *retryingClient) UpdateReference(msg *contrail.ReferenceUpdateMsg) error {
+	client, ok := c.inner.(objectClient)
+	if !ok {
+		return fmt.Errorf("Contrail API client %T can't update references", c.inner)
+	}
+	_, err := c.call("update reference", msg.Type, func(int) (interface{}, error) {
+		return nil, client.UpdateReference(msg)
+	})
+	return err
+}
@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/mocks"
+	"github.com/Juniper/contrail-go-api/types"
+	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var errUnavailable = errors.New("503 Service Unavailable: config node is restarting")
+var errConflict = errors.New("409 Conflict: project exists")
+
+// timeoutError is the error of a request whose response didn't come in time.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout = &url.Error{Op: "Post", URL: "http://10.0.0.1:8082/projects", Err: timeoutError{}}
+
+// flakyApiClient is an in-memory Contrail API that fails calls as scripted. Errors queued in
+// failures are returned, one per call, before calls reach the database. If failAfter is set,
+// the call reaches the database first, like when response is lost on the way back.
+type flakyApiClient struct {
+	*mocks.ApiClient
+
+	mutex     sync.Mutex
+	failures  map[string][]error
+	failAfter bool
+	calls     map[string]int
+}
+
+func newFlakyApiClient() *flakyApiClient {
+	client := &flakyApiClient{
+		ApiClient: new(mocks.ApiClient),
+		failures:  make(map[string][]error),
+		calls:     make(map[string]int),
+	}
+	client.ApiClient.Init()
+	return client
+}
+
+func (c *flakyApiClient) fail(method string, errs ...error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures[method] = append(c.failures[method], errs...)
+}
+
+func (c *flakyApiClient) callCount(method string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls[method]
+}
+
+// intercept records a call and returns error that should be returned before and after it
+// reaches the database.
+func (c *flakyApiClient) intercept(method string) (error, error) {
+	c.mutex.Lock()
+	c.calls[method]++
+	var err error
+	if len(c.failures[method]) > 0 {
+		err = c.failures[method][0]
+		c.failures[method] = c.failures[method][1:]
+	}
+	failAfter := c.failAfter
+	c.mutex.Unlock()
+
+	if failAfter {
+		return nil, err
+	}
+	return err, nil
+}
+
+func (c *flakyApiClient) Create(ptr contrail.IObject) error {
+	before, after := c.intercept("Create")
+	if before != nil {
+		return before
+	}
+	if err := c.ApiClient.Create(ptr); err != nil {
+		return err
+	}
+	return after
+}
+
+func (c *flakyApiClient) Delete(ptr contrail.IObject) error {
+	before, after := c.intercept("Delete")
+	if before != nil {
+		return before
+	}
+	if _, err := c.ApiClient.FindByUuid(ptr.GetType(), ptr.GetUuid()); err != nil {
+		// Report missing objects like the API server does.
+		return fmt.Errorf("404 Not Found: %v", err)
+	}
+	if err := c.ApiClient.Delete(ptr); err != nil {
+		return err
+	}
+	return after
+}
+
+func (c *flakyApiClient) List(typename string) ([]contrail.ListResult, error) {
+	before, _ := c.intercept("List")
+	if before != nil {
+		return nil, before
+	}
+	return c.ApiClient.List(typename)
+}
+
+// contextRecorder records ID of the plugin request that the last request was sent for.
+type contextRecorder struct {
+	base      http.RoundTripper
+	requestID *string
+}
+
+func (r *contextRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	*r.requestID = common.RequestID(req.Context())
+	return r.base.RoundTrip(req)
+}
+
+var _ = Describe("Retrying Contrail API client", func() {
+
+	var inner *flakyApiClient
+	var policy common.RetryConfig
+
+	BeforeEach(func() {
+		inner = newFlakyApiClient()
+		policy = common.RetryConfig{
+			MaxAttempts:          3,
+			InitialBackoff:       time.Millisecond,
+			MaxBackoff:           5 * time.Millisecond,
+			Jitter:               0.5,
+			RetryableStatusCodes: []int{500, 502, 503, 504},
+		}
+	})
+
+	retrying := func() *retryingClient {
+		return newRetryingClient(inner, policy)
+	}
+
+	newProject := func() *types.Project {
+		project := new(types.Project)
+		project.SetFQName("domain", []string{domainName, "retry"})
+		return project
+	}
+
+	It("retries retryable errors until call succeeds", func() {
+		inner.fail("List", errUnavailable, errUnavailable)
+		_, err := retrying().List("project")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(inner.callCount("List")).To(Equal(3))
+	})
+
+	It("gives up after max attempts", func() {
+		inner.fail("List", errUnavailable, errUnavailable, errUnavailable)
+		_, err := retrying().List("project")
+		Expect(err).To(Equal(errUnavailable))
+		Expect(inner.callCount("List")).To(Equal(3))
+	})
+
+	It("retries refused connections", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		address := listener.Addr().String()
+		listener.Close()
+		_, dialErr := net.Dial("tcp", address)
+		Expect(dialErr).To(HaveOccurred())
+
+		inner.fail("List", &url.Error{Op: "Get", URL: "http://" + address + "/projects",
+			Err: dialErr})
+		_, err = retrying().List("project")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(inner.callCount("List")).To(Equal(2))
+	})
+
+	It("doesn't retry connection errors that are not transient", func() {
+		inner.fail("List", &url.Error{Op: "Get", URL: "https://10.0.0.1:8082/projects",
+			Err: errors.New("x509: certificate signed by unknown authority")})
+		_, err := retrying().List("project")
+		Expect(err).To(HaveOccurred())
+		Expect(inner.callCount("List")).To(Equal(1))
+	})
+
+	It("doesn't retry errors that are not retryable", func() {
+		inner.fail("List", errors.New("400 Bad Request: malformed"))
+		_, err := retrying().List("project")
+		Expect(err).To(HaveOccurred())
+		Expect(inner.callCount("List")).To(Equal(1))
+	})
+
+	It("retries only statuses from the policy", func() {
+		policy.RetryableStatusCodes = []int{502}
+		inner.fail("List", errUnavailable)
+		_, err := retrying().List("project")
+		Expect(err).To(HaveOccurred())
+		Expect(inner.callCount("List")).To(Equal(1))
+	})
+
+	It("doesn't create object again if attempt that timed out has created it", func() {
+		inner.failAfter = true
+		inner.fail("Create", errTimeout)
+
+		project := newProject()
+		Expect(retrying().Create(project)).To(Succeed())
+		Expect(inner.callCount("Create")).To(Equal(1))
+		Expect(project.GetUuid()).ToNot(BeEmpty())
+
+		uuid, err := inner.ApiClient.UuidByName("project", "default-domain:retry")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uuid).To(Equal(project.GetUuid()))
+	})
+
+	It("creates object again if failed attempt hasn't created it", func() {
+		inner.fail("Create", errUnavailable)
+
+		project := newProject()
+		Expect(retrying().Create(project)).To(Succeed())
+		Expect(inner.callCount("Create")).To(Equal(2))
+		uuid, err := inner.ApiClient.UuidByName("project", "default-domain:retry")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uuid).To(Equal(project.GetUuid()))
+	})
+
+	It("returns conflict with object that it hasn't created", func() {
+		existing := newProject()
+		Expect(inner.ApiClient.Create(existing)).To(Succeed())
+		inner.fail("Create", errConflict)
+
+		project := newProject()
+		Expect(retrying().Create(project)).To(Equal(errConflict))
+		Expect(inner.callCount("Create")).To(Equal(1))
+		Expect(project.GetUuid()).ToNot(Equal(existing.GetUuid()))
+	})
+
+	It("returns conflict after attempts that certainly failed", func() {
+		existing := newProject()
+		Expect(inner.ApiClient.Create(existing)).To(Succeed())
+		inner.fail("Create", errUnavailable, errConflict)
+
+		Expect(retrying().Create(newProject())).To(Equal(errConflict))
+		Expect(inner.callCount("Create")).To(Equal(2))
+	})
+
+	It("treats object missing on retried delete as deleted", func() {
+		project := newProject()
+		Expect(inner.ApiClient.Create(project)).To(Succeed())
+		inner.failAfter = true
+		inner.fail("Delete", errUnavailable)
+
+		Expect(retrying().Delete(project)).To(Succeed())
+		Expect(inner.callCount("Delete")).To(Equal(2))
+	})
+
+	Context("with call timeout", func() {
+		var server *httptest.Server
+		var requests int32
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&requests, 0)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+				r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte(`{"projects": []}`))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("cancels requests of attempts that exceed the deadline", func() {
+			host, port, err := splitAddress(server.Listener.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			client := contrail.NewClient(host, port)
+			client.SetHTTPClient(&http.Client{Timeout: 20 * time.Millisecond})
+
+			start := time.Now()
+			_, err = newRetryingClient(client, policy).List("project")
+			Expect(err).To(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+			Expect(atomic.LoadInt32(&requests)).To(BeEquivalentTo(3))
+		})
+	})
+
+	Describe("logging", func() {
+		var output *bytes.Buffer
+
+		BeforeEach(func() {
+			output = &bytes.Buffer{}
+			log.SetOutput(output)
+		})
+
+		AfterEach(func() {
+			log.SetOutput(os.Stderr)
+		})
+
+		It("logs retries with the logger of the request", func() {
+			inner.fail("List", errUnavailable)
+			ctx := common.WithRequestID(context.Background(), "abc")
+			_, err := retrying().withContext(ctx).List("project")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output.String()).To(ContainSubstring("retrying"))
+			Expect(output.String()).To(ContainSubstring("requestID=abc"))
+		})
+
+		It("sends requests of Contrail client with context of the request", func() {
+			var requestID string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+				r *http.Request) {
+				w.Write([]byte(`{"projects": []}`))
+			}))
+			defer server.Close()
+			host, port, err := splitAddress(server.Listener.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			client := contrail.NewClient(host, port)
+			client.SetHTTPClient(&http.Client{Transport: &contextRecorder{
+				base: http.DefaultTransport, requestID: &requestID}})
+
+			ctx := common.WithRequestID(context.Background(), "abc")
+			_, err = newRetryingClient(client, policy).withContext(ctx).List("project")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(requestID).To(Equal("abc"))
+		})
+	})
+
+	Describe("backoff", func() {
+		It("doubles after each attempt up to max backoff", func() {
+			policy.Jitter = 0
+			policy.InitialBackoff = 100 * time.Millisecond
+			policy.MaxBackoff = 300 * time.Millisecond
+			Expect(backoff(&policy, 1)).To(Equal(100 * time.Millisecond))
+			Expect(backoff(&policy, 2)).To(Equal(200 * time.Millisecond))
+			Expect(backoff(&policy, 3)).To(Equal(300 * time.Millisecond))
+			Expect(backoff(&policy, 10)).To(Equal(300 * time.Millisecond))
+		})
+
+		It("randomizes jitter fraction of delay", func() {
+			policy.Jitter = 0.5
+			policy.InitialBackoff = 100 * time.Millisecond
+			policy.MaxBackoff = 100 * time.Millisecond
+			for i := 0; i < 20; i++ {
+				Expect(backoff(&policy, 1)).To(BeNumerically(">=", 50*time.Millisecond))
+				Expect(backoff(&policy, 1)).To(BeNumerically("<=", 100*time.Millisecond))
+			}
+		})
+	})
+})
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writePEM writes a single PEM block to a new file in dir and returns its path.
+func writePEM(dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	Expect(ioutil.WriteFile(path, data, 0600)).To(Succeed())
+	return path
+}
+
+// generateClientCert writes a new self-signed client certificate and its key to dir. It returns
+// paths of both files and the parsed certificate.
+func generateClientCert(dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "contrail-windows-docker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPath := writePEM(dir, "client.pem", "CERTIFICATE", der)
+	keyPath := writePEM(dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath, cert
+}
+
+var _ = Describe("Contrail API and Keystone over HTTPS", func() {
+
+	var dir string
+	var keystone *fakeKeystone
+	var api *fakeContrailAPI
+	var keys *KeystoneEnvs
+	var cfg common.ControllerConfig
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tls")
+		Expect(err).ToNot(HaveOccurred())
+
+		keystone = newUnstartedFakeKeystone()
+		keystone.server.StartTLS()
+		api = newUnstartedFakeContrailAPI()
+		api.setValidTokens("token-1")
+	})
+
+	JustBeforeEach(func() {
+		api.server.StartTLS()
+
+		keys = &KeystoneEnvs{
+			os_auth_url:            keystone.authURL(),
+			os_username:            "admin",
+			os_user_domain_name:    "users",
+			os_password:            "secret123",
+			os_tenant_name:         "admin",
+			os_project_domain_name: "projects",
+		}
+		ip, port := api.address()
+		cfg = common.ControllerConfig{IP: ip, Port: port, HTTPS: true}
+	})
+
+	AfterEach(func() {
+		keystone.server.Close()
+		api.server.Close()
+		os.RemoveAll(dir)
+	})
+
+	caBundle := func() string {
+		// Both servers use the same httptest certificate.
+		return writePEM(dir, "ca.pem", "CERTIFICATE", keystone.server.Certificate().Raw)
+	}
+
+	It("verifies server certificates against CA bundle", func() {
+		keys.tls.CAFile = caBundle()
+		cfg.TLS.CAFile = caBundle()
+
+		c, err := NewController(cfg, keys)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.ApiClient.List("virtual-network")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(api.receivedTokens()).To(Equal([]string{"token-1"}))
+	})
+
+	It("refuses to authenticate to Keystone with unknown certificate", func() {
+		_, err := NewController(cfg, keys)
+		Expect(err).To(HaveOccurred())
+		Expect(keystone.requestCount()).To(Equal(0))
+	})
+
+	It("refuses to send requests to Contrail API with unknown certificate", func() {
+		keys.tls.CAFile = caBundle()
+
+		c, err := NewController(cfg, keys)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.ApiClient.List("virtual-network")
+		Expect(err).To(HaveOccurred())
+		Expect(api.receivedTokens()).To(BeEmpty())
+	})
+
+	It("skips verification only when explicitly asked to", func() {
+		keys.tls.InsecureSkipVerify = true
+		cfg.TLS.InsecureSkipVerify = true
+
+		c, err := NewController(cfg, keys)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.ApiClient.List("virtual-network")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns error when CA bundle can't be read", func() {
+		keys.tls.CAFile = filepath.Join(dir, "nonexisting.pem")
+		_, err := NewController(cfg, keys)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("when Contrail API requires client certificate", func() {
+		var certPath, keyPath string
+
+		BeforeEach(func() {
+			var cert *x509.Certificate
+			certPath, keyPath, cert = generateClientCert(dir)
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(cert)
+			api.server.TLS = &tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  clientCAs,
+			}
+		})
+
+		It("sends client certificate", func() {
+			keys.tls.CAFile = caBundle()
+			cfg.TLS = common.TLSConfig{CAFile: caBundle(), CertFile: certPath, KeyFile: keyPath}
+
+			c, err := NewController(cfg, keys)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = c.ApiClient.List("virtual-network")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("fails without client certificate", func() {
+			keys.tls.CAFile = caBundle()
+			cfg.TLS.CAFile = caBundle()
+
+			c, err := NewController(cfg, keys)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = c.ApiClient.List("virtual-network")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("talks plain HTTP to Contrail API unless HTTPS is enabled", func() {
+		keys.tls.CAFile = caBundle()
+		cfg.HTTPS = false
+
+		c, err := NewController(cfg, keys)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.ApiClient.List("virtual-network")
+		Expect(err).To(HaveOccurred())
+		Expect(api.receivedTokens()).To(BeEmpty())
+	})
+})
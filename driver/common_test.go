@@ -0,0 +1,35 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/hnsManager"
+)
+
+// netAdapter is set by -netAdapter flag in the suite that uses actual HNS.
+var netAdapter = "Ethernet0"
+
+var ctx = context.Background()
+
+const (
+	domainName  = common.DomainName
+	tenantName  = "agatka"
+	networkName = "test_net"
+	subnetCIDR  = "10.10.10.0/24"
+	defaultGW   = "10.10.10.1"
+	timeout     = time.Second * 5
+)
+
+var hnsNetName = hnsManager.NetworkName{
+	Domain:  domainName,
+	Tenant:  tenantName,
+	Network: networkName,
+}
+
+func testConfig() *common.Config {
+	cfg := common.DefaultConfig()
+	cfg.Adapter = netAdapter
+	return cfg
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+)
+
+// DockerClient is the part of docker API that the driver uses.
+type DockerClient interface {
+	NetworkInspect(networkID string) (dockerTypes.NetworkResource, error)
+	NetworkList() ([]dockerTypes.NetworkResource, error)
+	ContainerInspect(containerID string) (dockerTypes.ContainerJSON, error)
+	// Ping returns error if docker daemon can't be reached.
+	Ping() error
+}
+
+type dockerAPIClient struct {
+	client *dockerClient.Client
+}
+
+// NewDockerClient returns DockerClient that talks to actual docker daemon.
+func NewDockerClient(cfg common.DockerConfig) (DockerClient, error) {
+	if cfg == (common.DockerConfig{}) {
+		client, err := dockerClient.NewEnvClient()
+		if err != nil {
+			return nil, err
+		}
+		return &dockerAPIClient{client: client}, nil
+	}
+
+	host := cfg.Host
+	if host == "" {
+		host = dockerClient.DefaultDockerHost
+	}
+	version := cfg.APIVersion
+	if version == "" {
+		version = dockerClient.DefaultVersion
+	}
+
+	var httpClient *http.Client
+	if cfg.TLSVerify || cfg.TLSCACertPath != "" || cfg.TLSCertPath != "" || cfg.TLSKeyPath != "" {
+		tlsCfg := dockerTLSConfig(cfg)
+		tlsConfig, err := tlsCfg.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		proto, addr, _, err := dockerClient.ParseHost(host)
+		if err != nil {
+			return nil, err
+		}
+		transport := &http.Transport{TLSClientConfig: tlsConfig}
+		if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	client, err := dockerClient.NewClient(host, version, httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerAPIClient{client: client}, nil
+}
+
+// dockerTLSConfig returns TLS configuration of connections to docker daemon. Its certificate is
+// verified if TLSVerify is set or if a CA certificate is given, as there is no point in
+// configuring a CA that isn't used.
+func dockerTLSConfig(cfg common.DockerConfig) common.TLSConfig {
+	return common.TLSConfig{
+		CAFile:             cfg.TLSCACertPath,
+		CertFile:           cfg.TLSCertPath,
+		KeyFile:            cfg.TLSKeyPath,
+		InsecureSkipVerify: !cfg.TLSVerify && cfg.TLSCACertPath == "",
+	}
+}
+
+func (c *dockerAPIClient) NetworkInspect(networkID string) (dockerTypes.NetworkResource, error) {
+	return c.client.NetworkInspect(context.Background(), networkID)
+}
+
+func (c *dockerAPIClient) NetworkList() ([]dockerTypes.NetworkResource, error) {
+	return c.client.NetworkList(context.Background(), dockerTypes.NetworkListOptions{})
+}
+
+func (c *dockerAPIClient) ContainerInspect(containerID string) (dockerTypes.ContainerJSON, error) {
+	return c.client.ContainerInspect(context.Background(), containerID)
+}
+
+func (c *dockerAPIClient) Ping() error {
+	_, err := c.client.Ping(context.Background())
+	return err
+}
@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"fmt"
+	"sync"
+
+	dockerTypes "github.com/docker/docker/api/types"
+)
+
+// FakeDockerClient is an in-memory implementation of DockerClient. Tests put networks and
+// containers in it, as docker daemon would have them.
+type FakeDockerClient struct {
+	mutex      sync.Mutex
+	networks   map[string]dockerTypes.NetworkResource
+	containers map[string]dockerTypes.ContainerJSON
+	pingErr    error
+}
+
+func NewFakeDockerClient() *FakeDockerClient {
+	return &FakeDockerClient{
+		networks:   make(map[string]dockerTypes.NetworkResource),
+		containers: make(map[string]dockerTypes.ContainerJSON),
+	}
+}
+
+// AddNetwork stores a docker network. Its ID must be set.
+func (f *FakeDockerClient) AddNetwork(net dockerTypes.NetworkResource) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.networks[net.ID] = net
+}
+
+func (f *FakeDockerClient) RemoveNetwork(networkID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.networks, networkID)
+}
+
+// AddContainer stores a container. Its ID must be set.
+func (f *FakeDockerClient) AddContainer(container dockerTypes.ContainerJSON) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.containers[container.ID] = container
+}
+
+func (f *FakeDockerClient) RemoveContainer(containerID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.containers, containerID)
+}
+
+func (f *FakeDockerClient) NetworkInspect(networkID string) (dockerTypes.NetworkResource,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	net, exists := f.networks[networkID]
+	if !exists {
+		return dockerTypes.NetworkResource{}, fmt.Errorf("Error: No such network: %s",
+			networkID)
+	}
+	return net, nil
+}
+
+func (f *FakeDockerClient) NetworkList() ([]dockerTypes.NetworkResource, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	nets := []dockerTypes.NetworkResource{}
+	for _, net := range f.networks {
+		nets = append(nets, net)
+	}
+	return nets, nil
+}
+
+func (f *FakeDockerClient) ContainerInspect(containerID string) (dockerTypes.ContainerJSON,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	container, exists := f.containers[containerID]
+	if !exists {
+		return dockerTypes.ContainerJSON{}, fmt.Errorf("Error: No such container: %s",
+			containerID)
+	}
+	return container, nil
+}
+
+// SetPingError makes Ping fail with given error, as if docker daemon was unreachable. Nil
+// makes it reachable again.
+func (f *FakeDockerClient) SetPingError(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pingErr = err
+}
+
+func (f *FakeDockerClient) Ping() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.pingErr
+}
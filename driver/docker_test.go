@@ -0,0 +1,89 @@
+//go:build fakes
+// +build fakes
+
+package driver
+
+import (
+	"github.com/codilime/contrail-windows-docker/common"
+	dockerTypes "github.com/docker/docker/api/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Docker client", func() {
+
+	Context("configured explicitly", func() {
+		It("can be created without TLS", func() {
+			_, err := NewDockerClient(common.DockerConfig{
+				Host:       "tcp://127.0.0.1:2375",
+				APIVersion: "1.24",
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns error on malformed host", func() {
+			_, err := NewDockerClient(common.DockerConfig{
+				Host: "127.0.0.1:2375",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+		It("returns error when CA certificate doesn't exist", func() {
+			_, err := NewDockerClient(common.DockerConfig{
+				Host:          "tcp://127.0.0.1:2376",
+				TLSCACertPath: "nonexisting_ca.pem",
+				TLSVerify:     true,
+			})
+			Expect(err).To(HaveOccurred())
+		})
+		It("returns error when client key is missing", func() {
+			_, err := NewDockerClient(common.DockerConfig{
+				Host:        "tcp://127.0.0.1:2376",
+				TLSCertPath: "nonexisting_cert.pem",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+		It("verifies daemon certificate when CA certificate is given", func() {
+			tlsCfg := dockerTLSConfig(common.DockerConfig{TLSCACertPath: "ca.pem"})
+			Expect(tlsCfg.InsecureSkipVerify).To(BeFalse())
+			Expect(tlsCfg.CAFile).To(Equal("ca.pem"))
+		})
+		It("verifies daemon certificate against system CAs only when requested", func() {
+			tlsCfg := dockerTLSConfig(common.DockerConfig{TLSCertPath: "cert.pem"})
+			Expect(tlsCfg.InsecureSkipVerify).To(BeTrue())
+			tlsCfg = dockerTLSConfig(common.DockerConfig{TLSVerify: true})
+			Expect(tlsCfg.InsecureSkipVerify).To(BeFalse())
+		})
+	})
+
+	Describe("fake", func() {
+		var fake *FakeDockerClient
+
+		BeforeEach(func() {
+			fake = NewFakeDockerClient()
+			fake.AddNetwork(dockerTypes.NetworkResource{
+				ID:      "1234",
+				Options: map[string]string{"tenant": tenantName, "network": networkName},
+			})
+		})
+
+		It("returns stored networks", func() {
+			net, err := fake.NetworkInspect("1234")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(net.Options).To(HaveKeyWithValue("tenant", tenantName))
+
+			nets, err := fake.NetworkList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+		})
+
+		It("returns error for removed network", func() {
+			fake.RemoveNetwork("1234")
+			_, err := fake.NetworkInspect("1234")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns error for nonexisting container", func() {
+			_, err := fake.ContainerInspect("1234")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
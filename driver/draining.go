@@ -0,0 +1,300 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/docker/go-plugins-helpers/network"
+)
+
+// ErrShuttingDown is returned for plugin requests that arrive after the driver started stopping.
+var ErrShuttingDown = errors.New("Contrail driver is shutting down")
+
+// ErrDrainTimeout is returned by StopServing when plugin requests in flight didn't finish before
+// the shutdown timeout.
+var ErrDrainTimeout = errors.New("Timed out waiting for plugin requests in flight")
+
+// requestTracker counts plugin requests in flight, so that stopping the driver can wait for them
+// to finish.
+type requestTracker struct {
+	mutex     sync.Mutex
+	rejecting bool
+	// abandoned is set once waiting for requests in flight timed out. Docker won't learn about
+	// results of these requests, so objects they create are rolled back.
+	abandoned bool
+	inFlight  int
+	// idle is closed when the last request in flight finishes after rejecting started.
+	idle chan struct{}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{idle: make(chan struct{})}
+}
+
+// begin registers a new request. It fails once the tracker rejects requests.
+func (t *requestTracker) begin() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.rejecting {
+		return ErrShuttingDown
+	}
+	t.inFlight++
+	return nil
+}
+
+func (t *requestTracker) end() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inFlight--
+	if t.rejecting && t.inFlight == 0 {
+		close(t.idle)
+	}
+}
+
+// reject makes the tracker reject new requests. Requests that already began aren't affected.
+func (t *requestTracker) reject() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.rejecting {
+		return
+	}
+	t.rejecting = true
+	if t.inFlight == 0 {
+		close(t.idle)
+	}
+}
+
+// wait waits until all requests in flight finish, but no longer than timeout, in which case they
+// are abandoned. It must be called after reject.
+func (t *requestTracker) wait(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.idle:
+		return nil
+	case <-timer.C:
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.abandoned = true
+		return ErrDrainTimeout
+	}
+}
+
+// isAbandoned tells whether waiting for requests in flight timed out.
+func (t *requestTracker) isAbandoned() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.abandoned
+}
+
+// count returns number of requests in flight.
+func (t *requestTracker) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.inFlight
+}
+
+// rollbacker removes objects created by requests that finished after they were abandoned.
+type rollbacker interface {
+	rollbackNetwork(req *network.CreateNetworkRequest)
+	rollbackEndpoint(req *network.CreateEndpointRequest)
+}
+
+// drainingDriver registers every plugin request in the tracker before passing it on, and rejects
+// requests once the driver is stopping. Networks and endpoints created by abandoned requests are
+// rolled back and the requests fail, so that docker doesn't use them even if it still gets the
+// response.
+type drainingDriver struct {
+	driver   network.Driver
+	requests *requestTracker
+	rollback rollbacker
+}
+
+func (d *drainingDriver) GetCapabilities() (*network.CapabilitiesResponse, error) {
+	if err := d.requests.begin(); err != nil {
+		return nil, err
+	}
+	defer d.requests.end()
+	return d.driver.GetCapabilities()
+}
+
+func (d *drainingDriver) CreateNetwork(req *network.CreateNetworkRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	err := d.driver.CreateNetwork(req)
+	if d.requests.isAbandoned() {
+		// A network that failed to be created may have existed before, so it's left as it is.
+		if err == nil {
+			d.rollback.rollbackNetwork(req)
+		}
+		return ErrDrainTimeout
+	}
+	return err
+}
+
+func (d *drainingDriver) AllocateNetwork(req *network.AllocateNetworkRequest) (
+	*network.AllocateNetworkResponse, error) {
+	if err := d.requests.begin(); err != nil {
+		return nil, err
+	}
+	defer d.requests.end()
+	return d.driver.AllocateNetwork(req)
+}
+
+func (d *drainingDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.DeleteNetwork(req)
+}
+
+func (d *drainingDriver) FreeNetwork(req *network.FreeNetworkRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.FreeNetwork(req)
+}
+
+func (d *drainingDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
+	*network.CreateEndpointResponse, error) {
+	if err := d.requests.begin(); err != nil {
+		return nil, err
+	}
+	defer d.requests.end()
+	resp, err := d.driver.CreateEndpoint(req)
+	if d.requests.isAbandoned() {
+		// Endpoint IDs are unique, so whatever exists under this ID was created by the request,
+		// even if it failed halfway.
+		d.rollback.rollbackEndpoint(req)
+		return nil, ErrDrainTimeout
+	}
+	return resp, err
+}
+
+func (d *drainingDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.DeleteEndpoint(req)
+}
+
+func (d *drainingDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoResponse, error) {
+	if err := d.requests.begin(); err != nil {
+		return nil, err
+	}
+	defer d.requests.end()
+	return d.driver.EndpointInfo(req)
+}
+
+func (d *drainingDriver) Join(req *network.JoinRequest) (*network.JoinResponse, error) {
+	if err := d.requests.begin(); err != nil {
+		return nil, err
+	}
+	defer d.requests.end()
+	return d.driver.Join(req)
+}
+
+func (d *drainingDriver) Leave(req *network.LeaveRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.Leave(req)
+}
+
+func (d *drainingDriver) DiscoverNew(req *network.DiscoveryNotification) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.DiscoverNew(req)
+}
+
+func (d *drainingDriver) DiscoverDelete(req *network.DiscoveryNotification) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.DiscoverDelete(req)
+}
+
+func (d *drainingDriver) ProgramExternalConnectivity(
+	req *network.ProgramExternalConnectivityRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.ProgramExternalConnectivity(req)
+}
+
+func (d *drainingDriver) RevokeExternalConnectivity(
+	req *network.RevokeExternalConnectivityRequest) error {
+	if err := d.requests.begin(); err != nil {
+		return err
+	}
+	defer d.requests.end()
+	return d.driver.RevokeExternalConnectivity(req)
+}
+
+// rollbackNetwork removes HNS network created by an abandoned CreateNetwork request.
+func (d *ContrailDriver) rollbackNetwork(req *network.CreateNetworkRequest) {
+	ctx := requestContext("CreateNetwork", log.Fields{"networkID": req.NetworkID})
+	meta, _, err := d.networkMetaFromRequest(req)
+	if err != nil {
+		common.Logger(ctx).Errorln("Failed to roll back network:", err)
+		return
+	}
+	ctx = common.WithFields(ctx, meta.logFields())
+	logger := common.Logger(ctx)
+	logger.Warnln("Rolling back network created after shutdown timeout")
+
+	netKey := fmt.Sprintf("%s:%s:%s", meta.domain, meta.tenant, meta.network)
+	d.locks.lockNetwork(netKey)
+	defer d.locks.unlockNetwork(netKey)
+	if err := d.hnsMgr.DeleteNetwork(ctx, meta.hnsName()); err != nil {
+		logger.Errorln("Failed to roll back network:", err)
+	}
+}
+
+// rollbackEndpoint removes HNS endpoint and Contrail instance created by an abandoned
+// CreateEndpoint request.
+func (d *ContrailDriver) rollbackEndpoint(req *network.CreateEndpointRequest) {
+	ctx := requestContext("CreateEndpoint", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Warnln("Rolling back endpoint created after shutdown timeout")
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
+
+	// The instance is named after the endpoint, see CreateEndpoint.
+	if instance, err := d.controller.GetInstance(ctx, req.EndpointID); err == nil {
+		instanceCtx := common.WithFields(ctx, log.Fields{"instanceUUID": instance.GetUuid()})
+		if err := d.controller.DeleteElementRecursive(instanceCtx, instance); err != nil {
+			common.Logger(instanceCtx).Errorln("Failed to roll back Contrail instance:", err)
+		}
+	}
+
+	hnsEp, err := d.hnsMgr.GetEndpointByName(ctx, req.EndpointID)
+	if err != nil {
+		logger.Errorln("Failed to roll back HNS endpoint:", err)
+		return
+	}
+	if hnsEp == nil {
+		return
+	}
+	if err := d.hnsMgr.DeleteEndpoint(ctx, hnsEp); err != nil {
+		logger.Errorln("Failed to roll back HNS endpoint:", err)
+	}
+}
@@ -4,183 +4,322 @@
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
 	"strings"
-	"time"
-
-	"context"
 
 	"github.com/Juniper/contrail-go-api/types"
-	"github.com/Microsoft/go-winio"
-	"github.com/Microsoft/hcsshim"
 	log "github.com/Sirupsen/logrus"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/health"
 	"github.com/codilime/contrail-windows-docker/hns"
 	"github.com/codilime/contrail-windows-docker/hnsManager"
+	"github.com/codilime/contrail-windows-docker/listener"
+	"github.com/codilime/contrail-windows-docker/metrics"
 	dockerTypes "github.com/docker/docker/api/types"
-	dockerClient "github.com/docker/docker/client"
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/docker/libnetwork/netlabel"
 )
 
 type ContrailDriver struct {
-	controller     *controller.Controller
+	controller     controller.Controller
+	hns            hns.HNS
 	hnsMgr         *hnsManager.HNSManager
+	docker         DockerClient
+	config         *common.Config
 	networkAdapter string
-	listener       net.Listener
+	listener       *listener.Listener
+	metricsServer  *metrics.Server
+	healthServer   *health.Server
+	locks          *lockManager
+	requests       *requestTracker
 }
 
 type NetworkMeta struct {
+	domain  string
 	tenant  string
 	network string
 }
 
-func NewDriver(adapter string, c *controller.Controller) *ContrailDriver {
+// hnsName returns name of HNS network of the Contrail network.
+func (m *NetworkMeta) hnsName() hnsManager.NetworkName {
+	return hnsManager.NetworkName{Domain: m.domain, Tenant: m.tenant, Network: m.network}
+}
 
+// logFields returns fields that identify the Contrail network in logs.
+func (m *NetworkMeta) logFields() log.Fields {
+	return log.Fields{"domain": m.domain, "tenant": m.tenant, "network": m.network}
+}
+
+func NewDriver(cfg *common.Config, c controller.Controller, h hns.HNS,
+	docker DockerClient) *ContrailDriver {
+
+	hnsMgr := hnsManager.NewHNSManager(h)
+	hnsMgr.NetworkReadyTimeout = cfg.HNS.NetworkReadyTimeout
+	hnsMgr.DefaultDomain = cfg.DefaultDomain
 	d := &ContrailDriver{
 		controller:     c,
-		hnsMgr:         &hnsManager.HNSManager{},
-		networkAdapter: adapter,
+		hns:            h,
+		hnsMgr:         hnsMgr,
+		docker:         docker,
+		config:         cfg,
+		networkAdapter: cfg.Adapter,
+		locks:          newLockManager(),
+		requests:       newRequestTracker(),
 	}
 	return d
 }
 
-func (d *ContrailDriver) StartServing() error {
-
-	err := d.createRootNetwork()
-	if err != nil {
-		return err
+// requestContext returns context of a plugin request. Its logger tags every line with a new
+// request ID, the plugin method and given fields, like IDs of docker network and endpoint.
+func requestContext(method string, fields log.Fields) context.Context {
+	ctx := common.WithRequestID(context.Background(), common.NewRequestID())
+	ctx = common.WithFields(ctx, log.Fields{"method": method})
+	if len(fields) > 0 {
+		ctx = common.WithFields(ctx, fields)
 	}
+	return ctx
+}
 
-	pipeConfig := winio.PipeConfig{
-		// This will set permissions for Service, System, Adminstrator group and account to
-		// have full access
-		SecurityDescriptor: "D:(A;ID;FA;;;SY)(A;ID;FA;;;BA)(A;ID;FA;;;LA)(A;ID;FA;;;LS)",
-		MessageMode:        true,
-		InputBufferSize:    4096,
-		OutputBufferSize:   4096,
-	}
+func (d *ContrailDriver) StartServing() error {
+	ctx := context.Background()
 
-	pipeAddr := "//./pipe/" + common.DriverName
-	if d.listener, err = winio.ListenPipe(pipeAddr, &pipeConfig); err != nil {
-		return err
+	var err error
+	if d.config.Features.CreateRootNetwork {
+		if err = d.createRootNetwork(ctx); err != nil {
+			return err
+		}
 	}
 
-	if err := os.MkdirAll(common.PluginSpecDir(), 0755); err != nil {
+	if err = d.hnsMgr.Refresh(ctx); err != nil {
 		return err
 	}
 
-	url := "npipe://" + d.listener.Addr().String()
-	if err := ioutil.WriteFile(common.PluginSpecFilePath(), []byte(url), 0644); err != nil {
+	d.listener, err = listener.Listen(d.config.Listener, d.config.PluginName,
+		common.PluginSpecDir())
+	if err != nil {
 		return err
 	}
 
-	h := network.NewHandler(d)
+	h := network.NewHandler(&instrumentedDriver{
+		driver: &drainingDriver{driver: d, requests: d.requests, rollback: d},
+	})
 	go h.Serve(d.listener)
 
-	// wait for listener goroutine to spin up. I don't see more elegant way to do this.
-	time.Sleep(time.Second * 1)
+	if err := d.listener.WaitAccepting(d.config.Listener.ReadyTimeout); err != nil {
+		return fmt.Errorf("Plugin listener isn't accepting connections: %v", err)
+	}
 
-	log.Infoln("Started serving on ", pipeAddr)
+	log.Infoln("Started serving on", d.listener.URL())
 
-	return nil
+	if err := d.startServingHealth(); err != nil {
+		return err
+	}
+	return d.startServingMetrics()
 }
 
-func (d *ContrailDriver) createRootNetwork() error {
-	rootNetwork, err := hns.GetHNSNetworkByName(common.RootNetworkName)
+// startServingMetrics starts the metrics listener, if it's configured.
+func (d *ContrailDriver) startServingMetrics() error {
+	metrics.ManagedNetworks.Set(func() float64 {
+		networks, _ := d.hnsMgr.IndexedCounts()
+		return float64(networks)
+	})
+	metrics.ManagedEndpoints.Set(func() float64 {
+		_, endpoints := d.hnsMgr.IndexedCounts()
+		return float64(endpoints)
+	})
+
+	if d.config.Metrics.Address == "" {
+		return nil
+	}
+	var err error
+	d.metricsServer, err = metrics.Listen(d.config.Metrics.Address, metrics.Default)
+	return err
+}
+
+// createRootNetwork creates the root network if it doesn't exist. An existing root network that
+// doesn't match the config is recreated if it has no endpoints. Otherwise the mismatch is logged
+// and reported by the readiness check, as removing the network would disconnect its endpoints.
+func (d *ContrailDriver) createRootNetwork(ctx context.Context) error {
+	logger := common.Logger(ctx)
+	rootNetCfg := d.config.RootNetwork
+	rootNetwork, err := d.hns.GetNetworkByName(ctx, rootNetCfg.Name)
 	if err != nil {
 		return err
 	}
-	if rootNetwork == nil {
 
-		subnets := []hcsshim.Subnet{
-			{
-				AddressPrefix:  "0.0.0.0/24",
-				GatewayAddress: "0.0.0.0",
-			},
-		}
-		configuration := &hcsshim.HNSNetwork{
-			Name:               common.RootNetworkName,
-			Type:               "transparent",
-			NetworkAdapterName: d.networkAdapter,
-			Subnets:            subnets,
+	if rootNetwork != nil {
+		mismatch := d.rootNetworkMismatch(rootNetwork)
+		if mismatch == "" {
+			logger.Infoln("Existing root HNS network found:", rootNetwork.Id)
+			return nil
 		}
-		rootNetID, err := hns.CreateHNSNetwork(configuration)
+		endpoints, err := d.hns.ListEndpointsOfNetwork(ctx, rootNetwork.Id)
 		if err != nil {
 			return err
 		}
+		if len(endpoints) > 0 {
+			logger.Errorf("Root HNS network %s doesn't match the config (%s), but it has %d "+
+				"endpoints, so it's left as it is", rootNetwork.Id, mismatch, len(endpoints))
+			return nil
+		}
+		logger.Warnf("Recreating root HNS network %s, as it doesn't match the config: %s",
+			rootNetwork.Id, mismatch)
+		if err := d.hns.DeleteNetwork(ctx, rootNetwork.Id); err != nil {
+			return err
+		}
+	}
 
-		log.Infoln("Created root HNS network:", rootNetID)
-	} else {
-		log.Infoln("Existing root HNS network found:", rootNetwork.Id)
+	configuration := &hns.Network{
+		Name:               rootNetCfg.Name,
+		Type:               rootNetCfg.Type,
+		NetworkAdapterName: d.config.RootNetworkAdapter(),
+		Subnets: []hns.Subnet{
+			{
+				AddressPrefix:  rootNetCfg.Subnet,
+				GatewayAddress: rootNetCfg.Gateway,
+			},
+		},
 	}
+	rootNetID, err := d.hns.CreateNetwork(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	if _, err := hns.WaitForNetwork(ctx, d.hns, rootNetID,
+		d.config.HNS.NetworkReadyTimeout); err != nil {
+		return err
+	}
+	logger.Infoln("Created root HNS network:", rootNetID)
 	return nil
 }
 
+// rootNetworkMismatch describes how the root network differs from the config. It returns empty
+// string if it matches. HNS may change case of type and adapter name, so they are compared
+// case-insensitively.
+func (d *ContrailDriver) rootNetworkMismatch(rootNetwork *hns.Network) string {
+	rootNetCfg := d.config.RootNetwork
+	var problems []string
+	if !strings.EqualFold(rootNetwork.Type, rootNetCfg.Type) {
+		problems = append(problems, fmt.Sprintf("type is %q instead of %q", rootNetwork.Type,
+			rootNetCfg.Type))
+	}
+	adapter := d.config.RootNetworkAdapter()
+	if !strings.EqualFold(rootNetwork.NetworkAdapterName, adapter) {
+		problems = append(problems, fmt.Sprintf("adapter is %q instead of %q",
+			rootNetwork.NetworkAdapterName, adapter))
+	}
+	hasSubnet := false
+	var subnets []string
+	for _, subnet := range rootNetwork.Subnets {
+		subnets = append(subnets, subnet.AddressPrefix)
+		hasSubnet = hasSubnet || subnet.AddressPrefix == rootNetCfg.Subnet
+	}
+	if !hasSubnet {
+		problems = append(problems, fmt.Sprintf("subnets are %v instead of %s", subnets,
+			rootNetCfg.Subnet))
+	}
+	return strings.Join(problems, ", ")
+}
+
+// StopServing stops accepting plugin requests and removes the spec file. Then it waits for
+// requests in flight to finish, but no longer than the configured shutdown timeout, in which case
+// it returns ErrDrainTimeout. Networks and endpoints created by requests still in flight then are
+// rolled back once these requests finish, and StopServing waits for that up to the shutdown
+// timeout again. It can be called even if StartServing failed.
 func (d *ContrailDriver) StopServing() error {
-	_ = os.Remove(common.PluginSpecFilePath())
+	// Docker daemon may still send requests over connections that are already open.
+	d.requests.reject()
+
+	var closeErr error
+	if d.listener != nil {
+		if closeErr = d.listener.Close(); closeErr != nil {
+			log.Errorln("Failed to close plugin listener:", closeErr)
+		}
+	}
 
-	if err := d.listener.Close(); err != nil {
-		log.Errorln(err)
+	if inFlight := d.requests.count(); inFlight > 0 {
+		log.Infoln("Waiting for", inFlight, "plugin requests in flight")
+	}
+	err := d.requests.wait(d.config.ShutdownTimeout)
+	if err != nil {
+		log.Errorf("%v: %d still running after %v, rolling back what they create", err,
+			d.requests.count(), d.config.ShutdownTimeout)
+		if d.requests.wait(d.config.ShutdownTimeout) != nil {
+			log.Errorf("%d plugin requests still running, networks and endpoints they create "+
+				"are left as orphans", d.requests.count())
+		}
+	}
+
+	if d.metricsServer != nil {
+		d.metricsServer.Close()
+	}
+	if d.healthServer != nil {
+		d.healthServer.Close()
+	}
+	if err != nil {
 		return err
 	}
+	if closeErr != nil {
+		return closeErr
+	}
 
 	log.Infoln("Stopped serving")
-
 	return nil
 }
 
 func (d *ContrailDriver) GetCapabilities() (*network.CapabilitiesResponse, error) {
-	log.Debugln("=== GetCapabilities")
+	common.Logger(requestContext("GetCapabilities", nil)).Debugln("=== GetCapabilities")
 	r := &network.CapabilitiesResponse{}
 	r.Scope = network.LocalScope
 	return r, nil
 }
 
 func (d *ContrailDriver) CreateNetwork(req *network.CreateNetworkRequest) error {
-	log.Debugln("=== CreateNetwork")
-	log.Debugln("network.NetworkID =", req.NetworkID)
-	log.Debugln(req)
-	log.Debugln("IPv4:")
-	for _, n := range req.IPv4Data {
-		log.Debugln(n)
-	}
-	log.Debugln("IPv6:")
-	for _, n := range req.IPv6Data {
-		log.Debugln(n)
-	}
-	log.Debugln("options:")
-	for k, v := range req.Options {
-		fmt.Printf("%v: %v\n", k, v)
+	ctx := requestContext("CreateNetwork", log.Fields{"networkID": req.NetworkID})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== CreateNetwork")
+	logger.WithFields(log.Fields{
+		"ipv4":    req.IPv4Data,
+		"ipv6":    req.IPv6Data,
+		"options": req.Options,
+	}).Debugln(req)
+
+	meta, genericOptions, err := d.networkMetaFromRequest(req)
+	if err != nil {
+		return err
 	}
 
-	reqGenericOptionsMap, exists := req.Options[netlabel.GenericData]
-	if !exists {
-		return errors.New("Generic options missing")
-	}
+	ctx = common.WithFields(ctx, meta.logFields())
+	logger = common.Logger(ctx)
 
-	genericOptions, ok := reqGenericOptionsMap.(map[string]interface{})
-	if !ok {
-		return errors.New("Malformed generic options")
+	// The required label isn't checked here: docker stores labels of the network only after the
+	// driver creates it and doesn't pass them in the request, whose generic options hold only
+	// the driver options given with -o. CreateEndpoint checks it instead.
+	if err := d.checkNetworkPolicy(meta); err != nil {
+		logger.Warnln(err)
+		return err
 	}
 
-	tenant, exists := genericOptions["tenant"]
-	if !exists {
-		return errors.New("Tenant not specified")
+	mode := d.config.HNS.Mode
+	if modeOption, exists := genericOptions["mode"]; exists {
+		modeName, _ := modeOption.(string)
+		mode = strings.ToLower(modeName)
+		if !common.IsNetworkMode(mode) {
+			return fmt.Errorf("Unknown HNS network mode %q", modeName)
+		}
 	}
+	ctx = common.WithFields(ctx, log.Fields{"mode": mode})
+	logger = common.Logger(ctx)
 
-	netName, exists := genericOptions["network"]
-	if !exists {
-		return errors.New("Network name not specified")
-	}
+	netKey := fmt.Sprintf("%s:%s:%s", meta.domain, meta.tenant, meta.network)
+	d.locks.lockNetwork(netKey)
+	defer d.locks.unlockNetwork(netKey)
 
 	// Check if network is already created in Contrail.
-	contrailNetwork, err := d.controller.GetNetwork(tenant.(string), netName.(string))
+	contrailNetwork, err := d.controller.GetNetwork(ctx, meta.domain, meta.tenant, meta.network)
 	if err != nil {
 		return err
 	}
@@ -188,45 +327,110 @@ func (d *ContrailDriver) CreateNetwork(req *network.CreateNetworkRequest) error
 		return errors.New("Retreived Contrail network is nil")
 	}
 
-	log.Infoln("Got Contrail network", contrailNetwork.GetDisplayName())
+	logger.WithField("networkUUID", contrailNetwork.GetUuid()).Infoln("Got Contrail network",
+		contrailNetwork.GetDisplayName())
 
-	contrailIpam, err := d.controller.GetIpamSubnet(contrailNetwork)
+	contrailIpam, err := d.controller.GetIpamSubnet(ctx, contrailNetwork)
 	if err != nil {
 		return err
 	}
 	subnet := contrailIpam.Subnet
 	subnetCIDR := fmt.Sprintf("%s/%v", subnet.IpPrefix, subnet.IpPrefixLen)
 
-	gw, err := d.controller.GetDefaultGatewayIp(contrailNetwork)
+	gw, err := d.controller.GetDefaultGatewayIp(ctx, contrailNetwork)
 	if err != nil {
 		return err
 	}
 
-	_, err = d.hnsMgr.CreateNetwork(d.networkAdapter, tenant.(string), netName.(string),
-		subnetCIDR, gw)
+	hnsConfig := &hnsManager.NetworkConfig{
+		Mode:         mode,
+		Adapter:      d.networkAdapter,
+		SubnetCIDR:   subnetCIDR,
+		DefaultGW:    gw,
+		VSID:         contrailNetworkVSID(contrailNetwork),
+		ManagementIP: d.config.HNS.ManagementIP,
+		SourceMac:    d.config.HNS.SourceMac,
+	}
+	for _, pool := range d.config.HNS.MacPools {
+		hnsConfig.MacPools = append(hnsConfig.MacPools, hns.MacPool{
+			StartMacAddress: pool.Start,
+			EndMacAddress:   pool.End,
+		})
+	}
+
+	_, err = d.hnsMgr.CreateNetwork(ctx, meta.hnsName(), hnsConfig)
 
 	return err
 }
 
+// networkMetaFromRequest returns the Contrail network named in generic options of the request,
+// together with these options.
+func (d *ContrailDriver) networkMetaFromRequest(req *network.CreateNetworkRequest) (
+	*NetworkMeta, map[string]interface{}, error) {
+	reqGenericOptionsMap, exists := req.Options[netlabel.GenericData]
+	if !exists {
+		return nil, nil, errors.New("Generic options missing")
+	}
+
+	genericOptions, ok := reqGenericOptionsMap.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("Malformed generic options")
+	}
+
+	tenant, exists := genericOptions["tenant"]
+	if !exists {
+		return nil, nil, errors.New("Tenant not specified")
+	}
+
+	netName, exists := genericOptions["network"]
+	if !exists {
+		return nil, nil, errors.New("Network name not specified")
+	}
+
+	domain, _ := genericOptions["domain"].(string)
+	meta := &NetworkMeta{
+		domain:  d.domainOrDefault(domain),
+		tenant:  tenant.(string),
+		network: netName.(string),
+	}
+	return meta, genericOptions, nil
+}
+
+// contrailNetworkVSID returns the virtual subnet ID of the Contrail network: its VxLAN identifier
+// if it was set by the user, or the network ID allocated by Contrail otherwise.
+func contrailNetworkVSID(contrailNetwork *types.VirtualNetwork) uint {
+	properties := contrailNetwork.GetVirtualNetworkProperties()
+	if properties.VxlanNetworkIdentifier > 0 {
+		return uint(properties.VxlanNetworkIdentifier)
+	}
+	return uint(contrailNetwork.GetVirtualNetworkNetworkId())
+}
+
 func (d *ContrailDriver) AllocateNetwork(req *network.AllocateNetworkRequest) (*network.AllocateNetworkResponse, error) {
-	log.Debugln("=== AllocateNetwork")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("AllocateNetwork", nil))
+	logger.Debugln("=== AllocateNetwork")
+	logger.Debugln(req)
 	// This method is used in swarm, in remote plugins. We don't implement it.
 	return nil, errors.New("AllocateNetwork is not implemented")
 }
 
 func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error {
-	log.Debugln("=== DeleteNetwork")
-	log.Debugln(req)
+	ctx := requestContext("DeleteNetwork", log.Fields{"networkID": req.NetworkID})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== DeleteNetwork")
+	logger.Debugln(req)
+
+	d.locks.lockAllNetworks()
+	defer d.locks.unlockAllNetworks()
 
 	dockerNetsMeta, err := d.dockerNetworksMeta()
-	log.Debugln("Current docker-Contrail networks meta", dockerNetsMeta)
+	logger.Debugln("Current docker-Contrail networks meta", dockerNetsMeta)
 	if err != nil {
 		return err
 	}
 
-	hnsNetsMeta, err := d.hnsNetworksMeta()
-	log.Debugln("Current HNS-Contrail networks meta", hnsNetsMeta)
+	hnsNetsMeta, err := d.hnsNetworksMeta(ctx)
+	logger.Debugln("Current HNS-Contrail networks meta", hnsNetsMeta)
 	if err != nil {
 		return err
 	}
@@ -236,7 +440,7 @@ func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error
 	for _, hnsMeta := range hnsNetsMeta {
 		matchFound := false
 		for _, dockerMeta := range dockerNetsMeta {
-			if dockerMeta.tenant == hnsMeta.tenant && dockerMeta.network == hnsMeta.network {
+			if dockerMeta == hnsMeta {
 				matchFound = true
 				break
 			}
@@ -250,36 +454,62 @@ func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error
 	if toRemove == nil {
 		return errors.New("During handling of DeleteNetwork, couldn't find net to remove")
 	}
-	return d.hnsMgr.DeleteNetwork(toRemove.tenant, toRemove.network)
+	ctx = common.WithFields(ctx, toRemove.logFields())
+	return d.hnsMgr.DeleteNetwork(ctx, toRemove.hnsName())
 }
 
 func (d *ContrailDriver) FreeNetwork(req *network.FreeNetworkRequest) error {
-	log.Debugln("=== FreeNetwork")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("FreeNetwork", nil))
+	logger.Debugln("=== FreeNetwork")
+	logger.Debugln(req)
 	// This method is used in swarm, in remote plugins. We don't implement it.
 	return errors.New("FreeNetwork is not implemented")
 }
 
 func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (*network.CreateEndpointResponse, error) {
-	log.Debugln("=== CreateEndpoint")
-	log.Debugln(req)
-	log.Debugln(req.Interface)
-	log.Debugln(req.EndpointID)
-	log.Debugln("options:")
-	for k, v := range req.Options {
-		fmt.Printf("%v: %v\n", k, v)
+	ctx := requestContext("CreateEndpoint", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== CreateEndpoint")
+	logger.WithFields(log.Fields{
+		"interface": req.Interface,
+		"options":   req.Options,
+	}).Debugln(req)
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
+
+	dockerNetwork, err := d.docker.NetworkInspect(req.NetworkID)
+	if err != nil {
+		return nil, err
 	}
-
-	meta, err := d.networkMetaFromDockerNetwork(req.NetworkID)
+	meta, err := d.networkMetaFromDockerNetwork(&dockerNetwork)
 	if err != nil {
 		return nil, err
 	}
 
-	contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
-	log.Infoln("Retreived Contrail network:", contrailNetwork.GetUuid())
+	ctx = common.WithFields(ctx, meta.logFields())
+	logger = common.Logger(ctx)
+
+	// Policy could have changed since the network was created.
+	if err := d.checkNetworkPolicy(meta); err != nil {
+		logger.Warnln(err)
+		return nil, err
+	}
+	if err := d.checkLabelPolicy(dockerNetwork.Labels); err != nil {
+		logger.Warnln(err)
+		return nil, err
+	}
+
+	contrailNetwork, err := d.controller.GetNetwork(ctx, meta.domain, meta.tenant, meta.network)
 	if err != nil {
 		return nil, err
 	}
+	ctx = common.WithFields(ctx, log.Fields{"networkUUID": contrailNetwork.GetUuid()})
+	logger = common.Logger(ctx)
+	logger.Infoln("Retreived Contrail network")
 
 	// TODO JW-187.
 	// We need to retreive Container ID here and use it instead of EndpointID as
@@ -292,59 +522,67 @@ func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (*ne
 	// containerID := req.Options["vmname"]
 	containerID := req.EndpointID
 
-	contrailVif, err := d.controller.GetOrCreateInterface(contrailNetwork, meta.tenant,
-		containerID)
+	contrailVif, err := d.controller.GetOrCreateInterface(ctx, contrailNetwork, meta.domain,
+		meta.tenant, containerID)
 	if err != nil {
 		return nil, err
 	}
+	ctx = common.WithFields(ctx, log.Fields{"interfaceUUID": contrailVif.GetUuid()})
 
-	_, err = d.controller.GetOrCreateInstance(contrailVif, containerID)
+	contrailInstance, err := d.controller.GetOrCreateInstance(ctx, contrailVif, containerID)
 	if err != nil {
 		return nil, err
 	}
+	ctx = common.WithFields(ctx, log.Fields{"instanceUUID": contrailInstance.GetUuid()})
 
-	contrailIP, err := d.controller.GetOrCreateInstanceIp(contrailNetwork, contrailVif)
-	log.Infoln("Retreived instance IP:", contrailIP.GetInstanceIpAddress())
+	contrailIP, err := d.controller.GetOrCreateInstanceIp(ctx, contrailNetwork, contrailVif)
 	if err != nil {
 		return nil, err
 	}
+	ctx = common.WithFields(ctx, log.Fields{"instanceIPUUID": contrailIP.GetUuid()})
+	logger = common.Logger(ctx)
+	logger.Infoln("Retreived instance IP:", contrailIP.GetInstanceIpAddress())
 
-	contrailGateway, err := d.controller.GetDefaultGatewayIp(contrailNetwork)
-	log.Infoln("Retreived GW address:", contrailGateway)
+	contrailGateway, err := d.controller.GetDefaultGatewayIp(ctx, contrailNetwork)
 	if err != nil {
 		return nil, err
 	}
+	logger.Infoln("Retreived GW address:", contrailGateway)
 
-	contrailMac, err := d.controller.GetInterfaceMac(contrailVif)
-	log.Infoln("Retreived MAC:", contrailMac)
+	contrailMac, err := d.controller.GetInterfaceMac(ctx, contrailVif)
 	if err != nil {
 		return nil, err
 	}
+	logger.Infoln("Retreived MAC:", contrailMac)
 	// contrail MACs are like 11:22:aa:bb:cc:dd
 	// HNS needs MACs like 11-22-AA-BB-CC-DD
 	formattedMac := strings.Replace(strings.ToUpper(contrailMac), ":", "-", -1)
 
-	hnsNet, err := d.hnsMgr.GetNetwork(meta.tenant, meta.network)
+	hnsNet, err := d.hnsMgr.GetNetwork(ctx, meta.hnsName())
 	if err != nil {
 		return nil, err
 	}
 
-	hnsEndpointConfig := &hcsshim.HNSEndpoint{
+	hnsEndpointConfig := &hns.Endpoint{
 		VirtualNetworkName: hnsNet.Name,
 		Name:               req.EndpointID,
 		IPAddress:          net.ParseIP(contrailIP.GetInstanceIpAddress()),
 		MacAddress:         formattedMac,
 		GatewayAddress:     contrailGateway,
 	}
+	hnsEndpointConfig.Policies, err = hnsManager.EndpointPolicies(hnsNet)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = hns.CreateHNSEndpoint(hnsEndpointConfig)
+	_, err = d.hnsMgr.CreateEndpoint(ctx, hnsEndpointConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	// TODO JW-12: talk to vRouter here
 
-	contrailIpam, err := d.controller.GetIpamSubnet(contrailNetwork)
+	contrailIpam, err := d.controller.GetIpamSubnet(ctx, contrailNetwork)
 	if err != nil {
 		return nil, err
 	}
@@ -362,43 +600,63 @@ func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (*ne
 }
 
 func (d *ContrailDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) error {
-	log.Debugln("=== DeleteEndpoint")
-	log.Debugln(req)
+	ctx := requestContext("DeleteEndpoint", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== DeleteEndpoint")
+	logger.Debugln(req)
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
 
 	// TODO JW-187.
 	// We need something like:
 	// containerID := req.Options["vmname"]
 	containerID := req.EndpointID
 
-	contrailInstance, err := types.VirtualMachineByName(d.controller.ApiClient, containerID)
-	if err != nil {
-		log.Warn("When handling DeleteEndpoint, Contrail vm instance wasn't found")
-	} else {
-		err = d.controller.DeleteElementRecursive(contrailInstance)
+	if d.config.Features.DeleteContrailInstances {
+		contrailInstance, err := d.controller.GetInstance(ctx, containerID)
 		if err != nil {
-			log.Warn("When handling DeleteEndpoint, failed to remove Contrail vm instance")
+			logger.Warn("When handling DeleteEndpoint, Contrail vm instance wasn't found")
+		} else {
+			instanceCtx := common.WithFields(ctx,
+				log.Fields{"instanceUUID": contrailInstance.GetUuid()})
+			err = d.controller.DeleteElementRecursive(instanceCtx, contrailInstance)
+			if err != nil {
+				common.Logger(instanceCtx).Warn("When handling DeleteEndpoint, failed to remove Contrail vm instance")
+			}
 		}
 	}
 
 	hnsEpName := req.EndpointID
-	epToDelete, err := hns.GetHNSEndpointByName(hnsEpName)
+	epToDelete, err := d.hnsMgr.GetEndpointByName(ctx, hnsEpName)
 	if err != nil {
 		return err
 	}
 	if epToDelete == nil {
-		log.Warn("When handling DeleteEndpoint, couldn't find HNS endpoint to delete")
+		logger.Warn("When handling DeleteEndpoint, couldn't find HNS endpoint to delete")
 		return nil
 	}
 
-	return hns.DeleteHNSEndpoint(epToDelete.Id)
+	return d.hnsMgr.DeleteEndpoint(ctx, epToDelete)
 }
 
 func (d *ContrailDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoResponse, error) {
-	log.Debugln("=== EndpointInfo")
-	log.Debugln(req)
+	ctx := requestContext("EndpointInfo", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== EndpointInfo")
+	logger.Debugln(req)
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
 
 	hnsEpName := req.EndpointID
-	hnsEp, err := hns.GetHNSEndpointByName(hnsEpName)
+	hnsEp, err := d.hnsMgr.GetEndpointByName(ctx, hnsEpName)
 	if err != nil {
 		return nil, err
 	}
@@ -418,14 +676,18 @@ func (d *ContrailDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoRe
 }
 
 func (d *ContrailDriver) Join(req *network.JoinRequest) (*network.JoinResponse, error) {
-	log.Debugln("=== Join")
-	log.Debugln(req)
-	log.Debugln("options:")
-	for k, v := range req.Options {
-		fmt.Printf("%v: %v\n", k, v)
-	}
-
-	hnsEp, err := hns.GetHNSEndpointByName(req.EndpointID)
+	ctx := requestContext("Join", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== Join")
+	logger.WithField("options", req.Options).Debugln(req)
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
+
+	hnsEp, err := d.hnsMgr.GetEndpointByName(ctx, req.EndpointID)
 	if err != nil {
 		return nil, err
 	}
@@ -442,10 +704,18 @@ func (d *ContrailDriver) Join(req *network.JoinRequest) (*network.JoinResponse,
 }
 
 func (d *ContrailDriver) Leave(req *network.LeaveRequest) error {
-	log.Debugln("=== Leave")
-	log.Debugln(req)
-
-	hnsEp, err := hns.GetHNSEndpointByName(req.EndpointID)
+	ctx := requestContext("Leave", log.Fields{
+		"networkID":  req.NetworkID,
+		"endpointID": req.EndpointID,
+	})
+	logger := common.Logger(ctx)
+	logger.Debugln("=== Leave")
+	logger.Debugln(req)
+
+	d.locks.lockEndpoint(req.EndpointID)
+	defer d.locks.unlockEndpoint(req.EndpointID)
+
+	hnsEp, err := d.hnsMgr.GetEndpointByName(ctx, req.EndpointID)
 	if err != nil {
 		return err
 	}
@@ -457,43 +727,37 @@ func (d *ContrailDriver) Leave(req *network.LeaveRequest) error {
 }
 
 func (d *ContrailDriver) DiscoverNew(req *network.DiscoveryNotification) error {
-	log.Debugln("=== DiscoverNew")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("DiscoverNew", nil))
+	logger.Debugln("=== DiscoverNew")
+	logger.Debugln(req)
 	// We don't care about discovery notifications.
 	return nil
 }
 
 func (d *ContrailDriver) DiscoverDelete(req *network.DiscoveryNotification) error {
-	log.Debugln("=== DiscoverDelete")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("DiscoverDelete", nil))
+	logger.Debugln("=== DiscoverDelete")
+	logger.Debugln(req)
 	// We don't care about discovery notifications.
 	return nil
 }
 
 func (d *ContrailDriver) ProgramExternalConnectivity(req *network.ProgramExternalConnectivityRequest) error {
-	log.Debugln("=== ProgramExternalConnectivity")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("ProgramExternalConnectivity", nil))
+	logger.Debugln("=== ProgramExternalConnectivity")
+	logger.Debugln(req)
 	return nil
 }
 
 func (d *ContrailDriver) RevokeExternalConnectivity(req *network.RevokeExternalConnectivityRequest) error {
-	log.Debugln("=== RevokeExternalConnectivity")
-	log.Debugln(req)
+	logger := common.Logger(requestContext("RevokeExternalConnectivity", nil))
+	logger.Debugln("=== RevokeExternalConnectivity")
+	logger.Debugln(req)
 	return nil
 }
 
-func (d *ContrailDriver) networkMetaFromDockerNetwork(dockerNetID string) (*NetworkMeta,
-	error) {
-	docker, err := dockerClient.NewEnvClient()
-	if err != nil {
-		return nil, err
-	}
-
-	dockerNetwork, err := docker.NetworkInspect(context.Background(), dockerNetID)
-	if err != nil {
-		return nil, err
-	}
-
+func (d *ContrailDriver) networkMetaFromDockerNetwork(
+	dockerNetwork *dockerTypes.NetworkResource) (*NetworkMeta, error) {
 	var meta NetworkMeta
 	var exists bool
 
@@ -507,18 +771,14 @@ func (d *ContrailDriver) networkMetaFromDockerNetwork(dockerNetID string) (*Netw
 		return nil, errors.New("Retreived network has no Contrail network name specfied")
 	}
 
+	meta.domain = d.domainOrDefault(dockerNetwork.Options["domain"])
 	return &meta, nil
 }
 
 func (d *ContrailDriver) dockerNetworksMeta() ([]NetworkMeta, error) {
 	var meta []NetworkMeta
 
-	docker, err := dockerClient.NewEnvClient()
-	if err != nil {
-		return nil, err
-	}
-
-	netList, err := docker.NetworkList(context.Background(), dockerTypes.NetworkListOptions{})
+	netList, err := d.docker.NetworkList()
 	if err != nil {
 		return nil, err
 	}
@@ -528,6 +788,7 @@ func (d *ContrailDriver) dockerNetworksMeta() ([]NetworkMeta, error) {
 		networkContrail, networkExists := net.Options["network"]
 		if tenantExists && networkExists {
 			meta = append(meta, NetworkMeta{
+				domain:  d.domainOrDefault(net.Options["domain"]),
 				tenant:  tenantContrail,
 				network: networkContrail,
 			})
@@ -536,22 +797,30 @@ func (d *ContrailDriver) dockerNetworksMeta() ([]NetworkMeta, error) {
 	return meta, nil
 }
 
-func (d *ContrailDriver) hnsNetworksMeta() ([]NetworkMeta, error) {
-	hnsNetworks, err := d.hnsMgr.ListNetworks()
+func (d *ContrailDriver) hnsNetworksMeta(ctx context.Context) ([]NetworkMeta, error) {
+	hnsNetworks, err := d.hnsMgr.ListNetworks(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var meta []NetworkMeta
 	for _, net := range hnsNetworks {
-		splitName := strings.Split(net.Name, ":")
-		// hnsManager.ListNetworks() already sanitizes network name
-		tenantName := splitName[1]
-		networkName := splitName[2]
+		// hnsManager.ListNetworks() returns only networks with valid Contrail names.
+		name, _ := hnsManager.DecodeHNSNetworkName(net.Name, d.hnsMgr.DefaultDomain)
 		meta = append(meta, NetworkMeta{
-			tenant:  tenantName,
-			network: networkName,
+			domain:  name.Domain,
+			tenant:  name.Tenant,
+			network: name.Network,
 		})
 	}
 	return meta, nil
 }
+
+// domainOrDefault returns Contrail domain given in options of docker network, or the default one
+// for networks created without it.
+func (d *ContrailDriver) domainOrDefault(domain string) string {
+	if domain == "" {
+		return d.config.DefaultDomain
+	}
+	return domain
+}
@@ -0,0 +1,608 @@
+//go:build fakes
+// +build fakes
+
+package driver
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/health"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/hnsManager"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blockingDriver holds Join and CreateEndpoint requests until release is closed.
+type blockingDriver struct {
+	*ContrailDriver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDriver) Join(req *network.JoinRequest) (*network.JoinResponse, error) {
+	d.started <- struct{}{}
+	<-d.release
+	return &network.JoinResponse{}, nil
+}
+
+func (d *blockingDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
+	*network.CreateEndpointResponse, error) {
+	d.started <- struct{}{}
+	<-d.release
+	return d.ContrailDriver.CreateEndpoint(req)
+}
+
+var _ = Describe("Contrail Network Driver with fake backends", func() {
+
+	const (
+		dockerNetID = "1234"
+		endpointID  = "5678"
+	)
+
+	var fakeController *controller.FakeController
+	var fakeHNS *hns.FakeHNS
+	var fakeDocker *FakeDockerClient
+	var d *ContrailDriver
+
+	BeforeEach(func() {
+		fakeController = controller.NewFakeController()
+		project := new(types.Project)
+		project.SetFQName("domain", []string{domainName, tenantName})
+		err := fakeController.ApiClient.Create(project)
+		Expect(err).ToNot(HaveOccurred())
+		_ = controller.CreateMockedNetworkWithSubnet(fakeController.ApiClient, networkName,
+			subnetCIDR, project)
+
+		fakeDocker = NewFakeDockerClient()
+		fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+			ID:      dockerNetID,
+			Options: map[string]string{"tenant": tenantName, "network": networkName},
+		})
+
+		fakeHNS = hns.NewFakeHNS()
+		d = NewDriver(testConfig(), fakeController, fakeHNS, fakeDocker)
+
+		err = d.CreateNetwork(&network.CreateNetworkRequest{
+			NetworkID: dockerNetID,
+			Options: map[string]interface{}{
+				netlabel.GenericData: map[string]interface{}{
+					"tenant":  tenantName,
+					"network": networkName,
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	createEndpoint := func() *network.CreateEndpointResponse {
+		resp, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("creates HNS network with subnet of Contrail network", func() {
+		nets, err := fakeHNS.ListNetworks(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nets).To(HaveLen(1))
+		Expect(nets[0].Subnets[0].AddressPrefix).To(Equal(subnetCIDR))
+		Expect(nets[0].Subnets[0].GatewayAddress).To(Equal(defaultGW))
+	})
+
+	Specify("CreateEndpoint creates HNS endpoint with address and MAC assigned by Contrail",
+		func() {
+			resp := createEndpoint()
+
+			contrailVif, err := types.VirtualMachineInterfaceByName(fakeController.ApiClient,
+				domainName+":"+tenantName+":"+endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			contrailIP, err := types.InstanceIpByName(fakeController.ApiClient,
+				contrailVif.GetName())
+			Expect(err).ToNot(HaveOccurred())
+			contrailMac, err := fakeController.GetInterfaceMac(ctx, contrailVif)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resp.Interface.Address).To(Equal(contrailIP.GetInstanceIpAddress() + "/24"))
+			Expect(resp.Interface.MacAddress).To(Equal(contrailMac))
+
+			ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ep).ToNot(BeNil())
+			Expect(ep.IPAddress.String()).To(Equal(contrailIP.GetInstanceIpAddress()))
+			Expect(ep.GatewayAddress).To(Equal(defaultGW))
+		})
+
+	Specify("DeleteEndpoint removes HNS endpoint and Contrail objects", func() {
+		_ = createEndpoint()
+
+		err := d.DeleteEndpoint(&network.DeleteEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep).To(BeNil())
+
+		_, err = fakeController.GetInstance(ctx, endpointID)
+		Expect(err).To(HaveOccurred())
+		_, err = types.VirtualMachineInterfaceByName(fakeController.ApiClient,
+			domainName+":"+tenantName+":"+endpointID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("network in another Contrail domain", func() {
+		const (
+			otherDomain      = "k8s"
+			otherDockerNetID = "4321"
+			otherSubnetCIDR  = "10.20.0.0/24"
+		)
+
+		var otherOptions map[string]string
+
+		BeforeEach(func() {
+			project := controller.CreateMockedProject(fakeController.ApiClient, otherDomain,
+				tenantName)
+			_ = controller.CreateMockedNetworkWithSubnet(fakeController.ApiClient, networkName,
+				otherSubnetCIDR, project)
+
+			otherOptions = map[string]string{
+				"domain":  otherDomain,
+				"tenant":  tenantName,
+				"network": networkName,
+			}
+			fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+				ID:      otherDockerNetID,
+				Options: otherOptions,
+			})
+			genericOptions := make(map[string]interface{})
+			for key, value := range otherOptions {
+				genericOptions[key] = value
+			}
+			err := d.CreateNetwork(&network.CreateNetworkRequest{
+				NetworkID: otherDockerNetID,
+				Options:   map[string]interface{}{netlabel.GenericData: genericOptions},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("creates separate HNS network named after the domain", func() {
+			hnsNet, err := d.hnsMgr.GetNetwork(ctx, hnsManager.NetworkName{
+				Domain:  otherDomain,
+				Tenant:  tenantName,
+				Network: networkName,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hnsNet.Subnets[0].AddressPrefix).To(Equal(otherSubnetCIDR))
+
+			defaultNet, err := d.hnsMgr.GetNetwork(ctx, hnsNetName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(defaultNet.Subnets[0].AddressPrefix).To(Equal(subnetCIDR))
+		})
+
+		It("creates Contrail interface in the domain", func() {
+			_, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+				NetworkID:  otherDockerNetID,
+				EndpointID: endpointID,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = types.VirtualMachineInterfaceByName(fakeController.ApiClient,
+				otherDomain+":"+tenantName+":"+endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = types.VirtualMachineInterfaceByName(fakeController.ApiClient,
+				domainName+":"+tenantName+":"+endpointID)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("removes only HNS network of the deleted docker network", func() {
+			fakeDocker.RemoveNetwork(otherDockerNetID)
+			Expect(d.DeleteNetwork(&network.DeleteNetworkRequest{
+				NetworkID: otherDockerNetID,
+			})).To(Succeed())
+
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+			Expect(nets[0].Name).To(Equal(hnsManager.EncodeHNSNetworkName(hnsNetName)))
+		})
+
+		It("is the default one for networks without domain option when configured", func() {
+			d.config.DefaultDomain = otherDomain
+			delete(otherOptions, "domain")
+			fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+				ID:      otherDockerNetID,
+				Options: otherOptions,
+			})
+
+			resp, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+				NetworkID:  otherDockerNetID,
+				EndpointID: endpointID,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, subnet, err := net.ParseCIDR(otherSubnetCIDR)
+			Expect(err).ToNot(HaveOccurred())
+			ip, _, err := net.ParseCIDR(resp.Interface.Address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(subnet.Contains(ip)).To(BeTrue())
+		})
+	})
+
+	Context("HNS network named in the older format", func() {
+		const legacyDockerNetID = "4321"
+
+		var legacyNetID string
+
+		BeforeEach(func() {
+			cfg := testConfig()
+			cfg.DefaultDomain = "k8s"
+			d = NewDriver(cfg, fakeController, fakeHNS, fakeDocker)
+			fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+				ID: dockerNetID,
+				Options: map[string]string{
+					"domain":  domainName,
+					"tenant":  tenantName,
+					"network": networkName,
+				},
+			})
+			fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+				ID:      legacyDockerNetID,
+				Options: map[string]string{"tenant": tenantName, "network": "legacy_net"},
+			})
+			legacyNetID = hns.MockHNSNetwork(fakeHNS, "Contrail:"+tenantName+":legacy_net",
+				netAdapter, "10.30.0.0/24", "10.30.0.1")
+		})
+
+		It("belongs to docker network in the configured default domain", func() {
+			fakeDocker.RemoveNetwork(dockerNetID)
+			Expect(d.DeleteNetwork(&network.DeleteNetworkRequest{
+				NetworkID: dockerNetID,
+			})).To(Succeed())
+
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+			Expect(nets[0].Id).To(Equal(legacyNetID))
+		})
+
+		It("is removed with its docker network", func() {
+			fakeDocker.RemoveNetwork(legacyDockerNetID)
+			Expect(d.DeleteNetwork(&network.DeleteNetworkRequest{
+				NetworkID: legacyDockerNetID,
+			})).To(Succeed())
+
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+			Expect(nets[0].Name).To(Equal(hnsManager.EncodeHNSNetworkName(hnsNetName)))
+		})
+	})
+
+	Context("with host policy", func() {
+		createNetwork := func(tenant, netName string) error {
+			return d.CreateNetwork(&network.CreateNetworkRequest{
+				NetworkID: "4321",
+				Options: map[string]interface{}{
+					netlabel.GenericData: map[string]interface{}{
+						"tenant":  tenant,
+						"network": netName,
+					},
+				},
+			})
+		}
+
+		expectDenied := func(err error) {
+			Expect(err).To(HaveOccurred())
+			Expect(err).To(BeAssignableToTypeOf(&PolicyError{}))
+			Expect(err.Error()).To(HavePrefix("Denied by host policy"))
+		}
+
+		It("denies networks of tenants that aren't allowed", func() {
+			d.config.Policy.Tenants = []common.TenantPolicy{{Name: "other"}}
+			expectDenied(createNetwork(tenantName, networkName))
+			d.config.Policy.Tenants[0].Domain = "k8s"
+			expectDenied(createNetwork("other", networkName))
+		})
+
+		It("denies networks that don't match patterns of the tenant", func() {
+			d.config.Policy.Tenants = []common.TenantPolicy{
+				{Name: tenantName, Networks: []string{"web-*"}},
+			}
+			err := createNetwork(tenantName, "db")
+			expectDenied(err)
+			Expect(err.Error()).To(ContainSubstring("network db"))
+
+			nets, err := fakeHNS.ListNetworks(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+		})
+
+		It("allows networks that match patterns of the tenant", func() {
+			d.config.Policy.Tenants = []common.TenantPolicy{
+				{Name: tenantName, Networks: []string{"web-*", "test_*"}},
+			}
+			_ = createEndpoint()
+		})
+
+		It("denies endpoints in networks that are no longer allowed", func() {
+			d.config.Policy.Tenants = []common.TenantPolicy{{Name: "other"}}
+			_, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+				NetworkID:  dockerNetID,
+				EndpointID: endpointID,
+			})
+			expectDenied(err)
+		})
+
+		It("denies endpoints in docker networks without the required label", func() {
+			d.config.Policy.RequiredLabel = "contrail=allowed"
+			_, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+				NetworkID:  dockerNetID,
+				EndpointID: endpointID,
+			})
+			expectDenied(err)
+
+			ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ep).To(BeNil())
+
+			fakeDocker.AddNetwork(dockerTypes.NetworkResource{
+				ID:      dockerNetID,
+				Options: map[string]string{"tenant": tenantName, "network": networkName},
+				Labels:  map[string]string{"contrail": "allowed"},
+			})
+			_ = createEndpoint()
+		})
+	})
+
+	Context("in overlay mode", func() {
+		const (
+			managementIP = "10.7.0.2"
+			vxlanID      = 5001
+		)
+
+		createNetwork := func(mode string) error {
+			return d.CreateNetwork(&network.CreateNetworkRequest{
+				NetworkID: dockerNetID,
+				Options: map[string]interface{}{
+					netlabel.GenericData: map[string]interface{}{
+						"tenant":  tenantName,
+						"network": networkName,
+						"mode":    mode,
+					},
+				},
+			})
+		}
+
+		BeforeEach(func() {
+			contrailNetwork, err := types.VirtualNetworkByName(fakeController.ApiClient,
+				domainName+":"+tenantName+":"+networkName)
+			Expect(err).ToNot(HaveOccurred())
+			contrailNetwork.SetVirtualNetworkProperties(
+				&types.VirtualNetworkType{VxlanNetworkIdentifier: vxlanID})
+			Expect(fakeController.ApiClient.Update(contrailNetwork)).To(Succeed())
+
+			d.config.HNS.ManagementIP = managementIP
+			Expect(d.hnsMgr.DeleteNetwork(ctx, hnsNetName)).To(Succeed())
+			Expect(createNetwork("Overlay")).To(Succeed())
+		})
+
+		It("creates HNS network with VSID of Contrail network", func() {
+			hnsNet, err := d.hnsMgr.GetNetwork(ctx, hnsNetName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hnsNet.Type).To(Equal(common.NetworkModeOverlay))
+			Expect(hnsNet.ManagementIP).To(Equal(managementIP))
+			Expect(hnsNet.Subnets[0].Policies).To(HaveLen(1))
+			Expect(string(hnsNet.Subnets[0].Policies[0])).To(
+				MatchJSON(`{"Type":"VSID","VSID":5001}`))
+		})
+
+		It("creates HNS endpoints with provider address of the host", func() {
+			_ = createEndpoint()
+			ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ep.Policies).To(HaveLen(1))
+			Expect(string(ep.Policies[0])).To(MatchJSON(`{"Type":"PA","PA":"10.7.0.2"}`))
+		})
+
+		It("rejects unknown modes", func() {
+			Expect(d.hnsMgr.DeleteNetwork(ctx, hnsNetName)).To(Succeed())
+			Expect(createNetwork("ics")).ToNot(Succeed())
+		})
+	})
+
+	It("records results of plugin requests in metrics", func() {
+		instrumented := &instrumentedDriver{driver: d}
+		failedJoins := metrics.PluginRequests.Value("Join", metrics.ResultError)
+		joins := metrics.PluginRequestDuration.Count("Join")
+
+		_, err := instrumented.Join(&network.JoinRequest{EndpointID: "nonexisting"})
+		Expect(err).To(HaveOccurred())
+
+		Expect(metrics.PluginRequests.Value("Join", metrics.ResultError)).To(
+			Equal(failedJoins + 1))
+		Expect(metrics.PluginRequestDuration.Count("Join")).To(Equal(joins + 1))
+	})
+
+	Describe("readiness", func() {
+		BeforeEach(func() {
+			Expect(d.createRootNetwork(ctx)).To(Succeed())
+		})
+
+		It("is reported when Contrail, HNS, root network and docker are available", func() {
+			report := d.readinessChecks().Run()
+			Expect(report.Status).To(Equal(health.StatusOK))
+			Expect(report.Checks).To(HaveLen(4))
+		})
+
+		It("reports unreachable docker", func() {
+			fakeDocker.SetPingError(errors.New("Cannot connect to the Docker daemon"))
+			report := d.readinessChecks().Run()
+			Expect(report.Status).To(Equal(health.StatusFailing))
+			Expect(report.Checks["docker"].LastError).To(ContainSubstring("Docker daemon"))
+		})
+
+		It("reports missing root network", func() {
+			rootNetwork, err := fakeHNS.GetNetworkByName(ctx, d.config.RootNetwork.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeHNS.DeleteNetwork(ctx, rootNetwork.Id)).To(Succeed())
+
+			report := d.readinessChecks().Run()
+			Expect(report.Checks["rootNetwork"].Status).To(Equal(health.StatusFailing))
+		})
+
+		It("doesn't check root network that the driver doesn't create", func() {
+			d.config.Features.CreateRootNetwork = false
+			rootNetwork, err := fakeHNS.GetNetworkByName(ctx, d.config.RootNetwork.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeHNS.DeleteNetwork(ctx, rootNetwork.Id)).To(Succeed())
+
+			report := d.readinessChecks().Run()
+			Expect(report.Status).To(Equal(health.StatusOK))
+			Expect(report.Checks).ToNot(HaveKey("rootNetwork"))
+		})
+	})
+
+	Describe("root network", func() {
+		rootNetwork := func() *hns.Network {
+			net, err := fakeHNS.GetNetworkByName(ctx, d.config.RootNetwork.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(net).ToNot(BeNil())
+			return net
+		}
+
+		It("is created as configured", func() {
+			d.config.RootNetwork.Type = common.RootNetworkTypeL2Bridge
+			d.config.RootNetwork.Adapter = "Ethernet1"
+			Expect(d.createRootNetwork(ctx)).To(Succeed())
+
+			net := rootNetwork()
+			Expect(net.Type).To(Equal(common.RootNetworkTypeL2Bridge))
+			Expect(net.NetworkAdapterName).To(Equal("Ethernet1"))
+			Expect(net.Subnets[0].AddressPrefix).To(Equal(d.config.RootNetwork.Subnet))
+		})
+
+		It("is waited for until it gets its adapter", func() {
+			fakeHNS.SetNetworkReadyDelay(100 * time.Millisecond)
+			Expect(d.createRootNetwork(ctx)).To(Succeed())
+			Expect(rootNetwork().NetworkAdapterName).To(Equal(d.config.Adapter))
+		})
+
+		It("is kept if it matches the config", func() {
+			Expect(d.createRootNetwork(ctx)).To(Succeed())
+			id := rootNetwork().Id
+			Expect(d.createRootNetwork(ctx)).To(Succeed())
+			Expect(rootNetwork().Id).To(Equal(id))
+		})
+
+		Context("bound to another adapter", func() {
+			var staleID string
+
+			BeforeEach(func() {
+				staleID = hns.MockHNSNetwork(fakeHNS, d.config.RootNetwork.Name, "OldAdapter",
+					d.config.RootNetwork.Subnet, d.config.RootNetwork.Gateway)
+			})
+
+			It("is recreated if it has no endpoints", func() {
+				Expect(d.createRootNetwork(ctx)).To(Succeed())
+				net := rootNetwork()
+				Expect(net.Id).ToNot(Equal(staleID))
+				Expect(net.NetworkAdapterName).To(Equal(d.config.Adapter))
+				Expect(d.checkRootNetwork()).To(Succeed())
+			})
+
+			It("is kept and reported if it has endpoints", func() {
+				hns.MockHNSEndpoint(fakeHNS, staleID)
+				Expect(d.createRootNetwork(ctx)).To(Succeed())
+				Expect(rootNetwork().Id).To(Equal(staleID))
+
+				err := d.checkRootNetwork()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("OldAdapter"))
+			})
+		})
+	})
+
+	Describe("stopping", func() {
+		var blocking *blockingDriver
+		var draining *drainingDriver
+
+		BeforeEach(func() {
+			blocking = &blockingDriver{
+				ContrailDriver: d,
+				started:        make(chan struct{}, 1),
+				release:        make(chan struct{}),
+			}
+			draining = &drainingDriver{driver: blocking, requests: d.requests, rollback: d}
+		})
+
+		join := func() {
+			go draining.Join(&network.JoinRequest{EndpointID: endpointID})
+			Eventually(blocking.started).Should(Receive())
+		}
+
+		It("rejects new requests", func() {
+			Expect(d.StopServing()).To(Succeed())
+			_, err := draining.Join(&network.JoinRequest{EndpointID: endpointID})
+			Expect(err).To(Equal(ErrShuttingDown))
+		})
+
+		It("waits for requests in flight", func() {
+			join()
+			stopped := make(chan error, 1)
+			go func() { stopped <- d.StopServing() }()
+			Consistently(stopped, "100ms").ShouldNot(Receive())
+
+			err := draining.CreateNetwork(&network.CreateNetworkRequest{NetworkID: "other"})
+			Expect(err).To(Equal(ErrShuttingDown))
+
+			close(blocking.release)
+			Eventually(stopped).Should(Receive(BeNil()))
+		})
+
+		It("gives up waiting after shutdown timeout", func() {
+			d.config.ShutdownTimeout = 50 * time.Millisecond
+			join()
+			Expect(d.StopServing()).To(Equal(ErrDrainTimeout))
+			close(blocking.release)
+		})
+
+		It("rolls back endpoint created after shutdown timeout", func() {
+			d.config.ShutdownTimeout = 50 * time.Millisecond
+			created := make(chan error, 1)
+			go func() {
+				_, err := draining.CreateEndpoint(&network.CreateEndpointRequest{
+					NetworkID:  dockerNetID,
+					EndpointID: endpointID,
+				})
+				created <- err
+			}()
+			Eventually(blocking.started).Should(Receive())
+			stopped := make(chan error, 1)
+			go func() { stopped <- d.StopServing() }()
+			Consistently(stopped, "70ms").ShouldNot(Receive())
+
+			close(blocking.release)
+			Eventually(created).Should(Receive(Equal(ErrDrainTimeout)))
+			Eventually(stopped).Should(Receive(Equal(ErrDrainTimeout)))
+
+			ep, err := fakeHNS.GetEndpointByName(ctx, endpointID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ep).To(BeNil())
+			_, err = fakeController.GetInstance(ctx, endpointID)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
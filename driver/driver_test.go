@@ -1,22 +1,30 @@
+//go:build windows && !fakes
+// +build windows,!fakes
+
 package driver
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"net"
 
 	"github.com/Juniper/contrail-go-api/types"
-	"github.com/Microsoft/hcsshim"
 	log "github.com/Sirupsen/logrus"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/controller"
 	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/hnsManager"
 	dockerTypes "github.com/docker/docker/api/types"
 	dockerTypesContainer "github.com/docker/docker/api/types/container"
 	dockerTypesNetwork "github.com/docker/docker/api/types/network"
@@ -29,7 +37,6 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-var netAdapter string
 var controllerAddr string
 var controllerPort int
 var useActualController bool
@@ -61,28 +68,26 @@ var _ = AfterSuite(func() {
 	cleanupAll()
 })
 
-func cleanupAll() {
-	err := common.HardResetHNS()
+// resetContrailHNS removes HNS networks created by the tests, leaving other networks of the
+// host intact.
+func resetContrailHNS() {
+	_, err := hnsManager.NewHNSManager(hns.NewHNS()).Cleanup(ctx, common.RootNetworkName, false)
 	Expect(err).ToNot(HaveOccurred())
-	err = common.RestartDocker()
+}
+
+func cleanupAll() {
+	resetContrailHNS()
+	err := common.RestartDocker()
 	Expect(err).ToNot(HaveOccurred())
 
 	docker := getDockerClient()
 	cleanupAllDockerNetworksAndContainers(docker)
 }
 
-var contrailController *controller.Controller
+var contrailController *controller.ContrailController
 var contrailDriver *ContrailDriver
 var project *types.Project
 
-const (
-	tenantName  = "agatka"
-	networkName = "test_net"
-	subnetCIDR  = "10.10.10.0/24"
-	defaultGW   = "10.10.10.1"
-	timeout     = time.Second * 5
-)
-
 var _ = Describe("Contrail Network Driver", func() {
 
 	BeforeEach(func() {
@@ -108,13 +113,13 @@ var _ = Describe("Contrail Network Driver", func() {
 		err := contrailDriver.StartServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		_, err = os.Stat(common.PluginSpecFilePath())
+		_, err = os.Stat(common.PluginSpecFilePath(common.DriverName))
 		Expect(os.IsNotExist(err)).To(BeFalse())
 
 		err = contrailDriver.StopServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		_, err = os.Stat(common.PluginSpecFilePath())
+		_, err = os.Stat(common.PluginSpecFilePath(common.DriverName))
 		Expect(os.IsNotExist(err)).To(BeTrue())
 	})
 
@@ -138,7 +143,7 @@ var _ = Describe("Contrail Network Driver", func() {
 		err := contrailDriver.StartServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		network, err := hns.GetHNSNetworkByName(common.RootNetworkName)
+		network, err := hns.GetHNSNetworkByName(ctx, common.RootNetworkName)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(network).ToNot(BeNil())
 
@@ -148,19 +153,19 @@ var _ = Describe("Contrail Network Driver", func() {
 		err = contrailDriver.StopServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		_, err = hns.GetHNSNetworkByName(common.RootNetworkName)
+		_, err = hns.GetHNSNetworkByName(ctx, common.RootNetworkName)
 		Expect(err).ToNot(HaveOccurred())
 
 		By("if root network exists upon driver startup, additional one is not created")
-		netsBefore, err := hns.ListHNSNetworks()
+		netsBefore, err := hns.ListHNSNetworks(ctx)
 		Expect(err).ToNot(HaveOccurred())
 
 		err = contrailDriver.StartServing()
 		Expect(err).ToNot(HaveOccurred())
-		_, err = hns.GetHNSNetworkByName(common.RootNetworkName)
+		_, err = hns.GetHNSNetworkByName(ctx, common.RootNetworkName)
 		Expect(err).ToNot(HaveOccurred())
 
-		netsAfter, err := hns.ListHNSNetworks()
+		netsAfter, err := hns.ListHNSNetworks(ctx)
 		Expect(err).ToNot(HaveOccurred())
 
 		Expect(len(netsBefore)).To(Equal(len(netsAfter)))
@@ -190,8 +195,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		err = contrailDriver.StopServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		err = common.HardResetHNS()
-		Expect(err).ToNot(HaveOccurred())
+		resetContrailHNS()
 	})
 
 	Context("on GetCapabilities request", func() {
@@ -259,13 +263,13 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 			It("creates a HNS network", func() {
-				netsBefore, err := hns.ListHNSNetworks()
+				netsBefore, err := hns.ListHNSNetworks(ctx)
 				Expect(err).ToNot(HaveOccurred())
 
 				err = contrailDriver.CreateNetwork(req)
 				Expect(err).ToNot(HaveOccurred())
 
-				netsAfter, err := hns.ListHNSNetworks()
+				netsAfter, err := hns.ListHNSNetworks(ctx)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(netsBefore).To(HaveLen(len(netsAfter) - 1))
 			})
@@ -286,7 +290,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		var contrailNet *types.VirtualNetwork
 
 		assertRemovesHNSNet := func() {
-			resp, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName)
+			resp, err := contrailDriver.hnsMgr.GetNetwork(ctx, hnsNetName)
 			Expect(err).To(HaveOccurred())
 			Expect(resp).To(BeNil())
 		}
@@ -296,7 +300,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		}
 		assertDoesNotRemoveContrailNet := func() {
 			net, err := types.VirtualNetworkByName(contrailController.ApiClient,
-				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName,
+				fmt.Sprintf("%s:%s:%s", domainName, tenantName,
 					networkName))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net).ToNot(BeNil())
@@ -320,7 +324,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		Context("HNS network doesn't exist", func() {
 			// for example, HNS was hard-reset while docker wasn't.
 			BeforeEach(func() {
-				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName)
+				contrailDriver.hnsMgr.DeleteNetwork(ctx, hnsNetName)
 				err := removeDockerNetwork(docker, dockerNetID)
 				Expect(err).ToNot(HaveOccurred())
 			})
@@ -331,7 +335,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		Context("Contrail network doesn't exist", func() {
 			// for example, somebody deleted Contrail network before removing docker/hns
 			BeforeEach(func() {
-				err := contrailController.DeleteElementRecursive(contrailNet)
+				err := controller.ForceDeleteElementRecursive(contrailController, contrailNet)
 				Expect(err).ToNot(HaveOccurred())
 				err = removeDockerNetwork(docker, dockerNetID)
 				Expect(err).ToNot(HaveOccurred())
@@ -361,7 +365,7 @@ var _ = Describe("On requests from docker daemon", func() {
 			})
 			It("allocates Contrail resources", func() {
 				net, err := types.VirtualNetworkByName(contrailController.ApiClient,
-					fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, networkName))
+					fmt.Sprintf("%s:%s:%s", domainName, tenantName, networkName))
 				Expect(err).ToNot(HaveOccurred())
 				Expect(net).ToNot(BeNil())
 
@@ -375,7 +379,7 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(inst).ToNot(BeNil())
 
-				vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName)
+				vifFQName := fmt.Sprintf("%s:%s:%s", domainName, tenantName, vmName)
 				vif, err := types.VirtualMachineInterfaceByName(contrailController.ApiClient,
 					vifFQName)
 				Expect(err).ToNot(HaveOccurred())
@@ -419,7 +423,7 @@ var _ = Describe("On requests from docker daemon", func() {
 				_ = createContrailNetwork(contrailController)
 				_ = createValidDockerNetwork(docker)
 
-				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName)
+				contrailDriver.hnsMgr.DeleteNetwork(ctx, hnsNetName)
 			})
 			It("responds with err", func() {
 				var err error
@@ -466,7 +470,7 @@ var _ = Describe("On requests from docker daemon", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(contrailInst).ToNot(BeNil())
 
-			vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName)
+			vifFQName := fmt.Sprintf("%s:%s:%s", domainName, tenantName, vmName)
 			contrailVif, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
 				vifFQName)
 			Expect(err).ToNot(HaveOccurred())
@@ -483,7 +487,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		}
 
 		assertRemovesHNSEndpoint := func() {
-			ep, err := hns.GetHNSEndpoint(hnsEndpointID)
+			ep, err := hns.GetHNSEndpoint(ctx, hnsEndpointID)
 			Expect(err).To(HaveOccurred())
 			Expect(ep).To(BeNil())
 		}
@@ -494,7 +498,7 @@ var _ = Describe("On requests from docker daemon", func() {
 			Expect(err).To(HaveOccurred())
 
 			_, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
-				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName))
+				fmt.Sprintf("%s:%s:%s", domainName, tenantName, vmName))
 			Expect(err).To(HaveOccurred())
 
 			_, err = types.InstanceIpByName(contrailController.ApiClient,
@@ -514,7 +518,7 @@ var _ = Describe("On requests from docker daemon", func() {
 
 		Context("HNS endpoint doesn't exist", func() {
 			BeforeEach(func() {
-				err := hns.DeleteHNSEndpoint(hnsEndpointID)
+				err := hns.DeleteHNSEndpoint(ctx, hnsEndpointID)
 				Expect(err).ToNot(HaveOccurred())
 				stopAndRemoveDockerContainer(docker, containerID)
 			})
@@ -525,7 +529,7 @@ var _ = Describe("On requests from docker daemon", func() {
 
 		Context("virtual-machine in Contrail doesn't exist", func() {
 			BeforeEach(func() {
-				err := contrailController.DeleteElementRecursive(contrailInst)
+				err := contrailController.DeleteElementRecursive(ctx, contrailInst)
 				Expect(err).ToNot(HaveOccurred())
 				stopAndRemoveDockerContainer(docker, containerID)
 			})
@@ -600,7 +604,8 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(resp.DisableGatewayService).To(BeTrue())
 
-				contrailNet, err := contrailController.GetNetwork(tenantName, networkName)
+				contrailNet, err := contrailController.GetNetwork(ctx, domainName, tenantName,
+					networkName)
 				Expect(err).ToNot(HaveOccurred())
 				ipams, err := contrailNet.GetNetworkIpamRefs()
 				Expect(err).ToNot(HaveOccurred())
@@ -656,6 +661,87 @@ var _ = Describe("On requests from docker daemon", func() {
 		})
 	})
 
+	Context("on concurrent requests sent through the plugin handler", func() {
+
+		const numEndpoints = 8
+		var dockerNetID string
+
+		BeforeEach(func() {
+			_ = createContrailNetwork(contrailController)
+			dockerNetID = createValidDockerNetwork(docker)
+		})
+
+		It("handles CreateEndpoint and DeleteEndpoint requests for many endpoints", func() {
+			endpointIDs := make([]string, numEndpoints)
+			for i := range endpointIDs {
+				endpointIDs[i] = fmt.Sprintf("stress_endpoint_%v", i)
+			}
+
+			By("creating all endpoints at the same time")
+			errs := make(chan error, numEndpoints)
+			var wg sync.WaitGroup
+			for _, epID := range endpointIDs {
+				wg.Add(1)
+				go func(epID string) {
+					defer wg.Done()
+					req := &network.CreateEndpointRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: epID,
+					}
+					errs <- callPlugin("NetworkDriver.CreateEndpoint", req,
+						&network.CreateEndpointResponse{})
+				}(epID)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			hnsNet, err := contrailDriver.hnsMgr.GetNetwork(ctx, hnsNetName)
+			Expect(err).ToNot(HaveOccurred())
+			eps, err := hns.ListHNSEndpointsOfNetwork(ctx, hnsNet.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(eps).To(HaveLen(numEndpoints))
+
+			vms, err := contrailController.ApiClient.List("virtual-machine")
+			Expect(err).ToNot(HaveOccurred())
+			for _, epID := range endpointIDs {
+				numVMs := 0
+				for _, vm := range vms {
+					if vm.Fq_name[len(vm.Fq_name)-1] == epID {
+						numVMs++
+					}
+				}
+				Expect(numVMs).To(Equal(1))
+			}
+
+			By("deleting all endpoints at the same time")
+			errs = make(chan error, numEndpoints)
+			for _, epID := range endpointIDs {
+				wg.Add(1)
+				go func(epID string) {
+					defer wg.Done()
+					req := &network.DeleteEndpointRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: epID,
+					}
+					errs <- callPlugin("NetworkDriver.DeleteEndpoint", req,
+						&map[string]string{})
+				}(epID)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			eps, err = hns.ListHNSEndpointsOfNetwork(ctx, hnsNet.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(eps).To(BeEmpty())
+		})
+	})
+
 	Context("on DiscoverNew request", func() {
 		It("responds with nil", func() {
 			req := network.DiscoveryNotification{}
@@ -689,20 +775,55 @@ var _ = Describe("On requests from docker daemon", func() {
 	})
 })
 
-func startDriver() (*ContrailDriver, *controller.Controller, *types.Project) {
-	var c *controller.Controller
+func startDriver() (*ContrailDriver, *controller.ContrailController, *types.Project) {
+	var c *controller.ContrailController
 	var p *types.Project
 
 	if useActualController {
-		c, p = controller.NewClientAndProject(tenantName, controllerAddr, controllerPort)
+		c, p = controller.NewClientAndProject(domainName, tenantName, controllerAddr,
+			controllerPort)
 	} else {
-		c, p = controller.NewMockedClientAndProject(tenantName)
+		c, p = controller.NewMockedClientAndProject(domainName, tenantName)
 	}
-	d := NewDriver(netAdapter, c)
+	docker, err := NewDockerClient(common.DockerConfig{})
+	Expect(err).ToNot(HaveOccurred())
+	d := NewDriver(testConfig(), c, hns.NewHNS(), docker)
 
 	return d, c, p
 }
 
+// callPlugin sends a request to the driver the same way docker daemon does: as a JSON POST
+// to the plugin's named pipe.
+func callPlugin(method string, req, resp interface{}) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return sockets.DialPipe("//./pipe/"+common.DriverName, timeout)
+			},
+		},
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpResp, err := client.Post("http://plugin/"+method,
+		"application/vnd.docker.plugins.v1.2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		var errResp network.ErrorResponse
+		if err := json.NewDecoder(httpResp.Body).Decode(&errResp); err != nil {
+			return err
+		}
+		return errors.New(errResp.Err)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
 func getDockerClient() *dockerClient.Client {
 	docker, err := dockerClient.NewEnvClient()
 	Expect(err).ToNot(HaveOccurred())
@@ -793,32 +914,32 @@ func cleanupAllDockerNetworksAndContainers(docker *dockerClient.Client) {
 	}
 }
 
-func createContrailNetwork(c *controller.Controller) *types.VirtualNetwork {
+func createContrailNetwork(c *controller.ContrailController) *types.VirtualNetwork {
 	return controller.CreateMockedNetworkWithSubnet(
 		c.ApiClient, networkName, subnetCIDR, project)
 }
 
 func deleteTheOnlyHNSEndpoint(d *ContrailDriver) {
 	_, hnsEndpointID := getTheOnlyHNSEndpoint(d)
-	err := hns.DeleteHNSEndpoint(hnsEndpointID)
+	err := hns.DeleteHNSEndpoint(ctx, hnsEndpointID)
 	Expect(err).ToNot(HaveOccurred())
 }
 
-func getTheOnlyHNSEndpoint(d *ContrailDriver) (*hcsshim.HNSEndpoint, string) {
-	hnsNets, err := contrailDriver.hnsMgr.ListNetworks()
+func getTheOnlyHNSEndpoint(d *ContrailDriver) (*hns.Endpoint, string) {
+	hnsNets, err := contrailDriver.hnsMgr.ListNetworks(ctx)
 	Expect(err).ToNot(HaveOccurred())
 	Expect(hnsNets).To(HaveLen(1))
-	eps, err := hns.ListHNSEndpointsOfNetwork(hnsNets[0].Id)
+	eps, err := hns.ListHNSEndpointsOfNetwork(ctx, hnsNets[0].Id)
 	Expect(err).ToNot(HaveOccurred())
 	Expect(eps).To(HaveLen(1))
 	hnsEndpointID := eps[0].Id
-	hnsEndpoint, err := hns.GetHNSEndpoint(hnsEndpointID)
+	hnsEndpoint, err := hns.GetHNSEndpoint(ctx, hnsEndpointID)
 	Expect(err).ToNot(HaveOccurred())
 	Expect(hnsEndpoint).ToNot(BeNil())
 	return hnsEndpoint, hnsEndpointID
 }
 
-func setupNetworksAndEndpoints(c *controller.Controller, docker *dockerClient.Client) (
+func setupNetworksAndEndpoints(c *controller.ContrailController, docker *dockerClient.Client) (
 	*types.VirtualNetwork, string, string) {
 	contrailNet := createContrailNetwork(c)
 	dockerNetID := createValidDockerNetwork(docker)
@@ -0,0 +1,21 @@
+//go:build fakes
+// +build fakes
+
+package driver
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+// TestDriverWithFakes runs specs of the driver with fake Contrail, HNS and docker. They are built
+// with -tags fakes into a suite of their own that doesn't restart docker or clean up HNS.
+func TestDriverWithFakes(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("driver_fakes_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Contrail Network Driver with fakes test suite",
+		[]Reporter{junitReporter})
+}
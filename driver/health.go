@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codilime/contrail-windows-docker/health"
+)
+
+// livenessChecks tell whether the driver is running and accepting plugin requests.
+func (d *ContrailDriver) livenessChecks() *health.Checker {
+	return health.NewChecker(d.config.Health.CheckTimeout,
+		health.Check{Name: "pluginListener", Fn: d.checkPluginListener},
+	)
+}
+
+// readinessChecks tell whether the driver is able to handle plugin requests, i.e. whether all
+// the services it depends on are reachable. The root network is checked only if the driver
+// creates it; otherwise it's managed by someone else, possibly in a different way.
+func (d *ContrailDriver) readinessChecks() *health.Checker {
+	checks := []health.Check{
+		{Name: "contrail", Fn: d.checkContrail},
+		{Name: "hns", Fn: d.checkHNS},
+	}
+	if d.config.Features.CreateRootNetwork {
+		checks = append(checks, health.Check{Name: "rootNetwork", Fn: d.checkRootNetwork})
+	}
+	checks = append(checks, health.Check{Name: "docker", Fn: d.docker.Ping})
+	return health.NewChecker(d.config.Health.CheckTimeout, checks...)
+}
+
+// checkPluginListener connects to the plugin listener, like docker daemon does.
+func (d *ContrailDriver) checkPluginListener() error {
+	conn, err := d.listener.Dial(d.config.Health.CheckTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (d *ContrailDriver) checkContrail() error {
+	return d.controller.CheckConnection(context.Background())
+}
+
+func (d *ContrailDriver) checkHNS() error {
+	_, err := d.hns.ListNetworks(context.Background())
+	return err
+}
+
+func (d *ContrailDriver) checkRootNetwork() error {
+	name := d.config.RootNetwork.Name
+	rootNetwork, err := d.hns.GetNetworkByName(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	if rootNetwork == nil {
+		return fmt.Errorf("Root HNS network %s doesn't exist", name)
+	}
+	if mismatch := d.rootNetworkMismatch(rootNetwork); mismatch != "" {
+		return fmt.Errorf("Root HNS network %s doesn't match the config: %s", name, mismatch)
+	}
+	return nil
+}
+
+// startServingHealth starts the health listener, if it's configured.
+func (d *ContrailDriver) startServingHealth() error {
+	if d.config.Health.Address == "" {
+		return nil
+	}
+	var err error
+	d.healthServer, err = health.Listen(d.config.Health.Address, d.livenessChecks(),
+		d.readinessChecks())
+	return err
+}
@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/docker/go-plugins-helpers/network"
+)
+
+// instrumentedDriver records count, result and latency of every plugin request in metrics
+// before passing it on.
+type instrumentedDriver struct {
+	driver network.Driver
+}
+
+func observe(method string, start time.Time, err error) {
+	result := metrics.ResultSuccess
+	if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.PluginRequests.Inc(method, result)
+	metrics.PluginRequestDuration.ObserveDuration(start, method)
+}
+
+func (d *instrumentedDriver) GetCapabilities() (*network.CapabilitiesResponse, error) {
+	start := time.Now()
+	resp, err := d.driver.GetCapabilities()
+	observe("GetCapabilities", start, err)
+	return resp, err
+}
+
+func (d *instrumentedDriver) CreateNetwork(req *network.CreateNetworkRequest) error {
+	start := time.Now()
+	err := d.driver.CreateNetwork(req)
+	observe("CreateNetwork", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) AllocateNetwork(req *network.AllocateNetworkRequest) (
+	*network.AllocateNetworkResponse, error) {
+	start := time.Now()
+	resp, err := d.driver.AllocateNetwork(req)
+	observe("AllocateNetwork", start, err)
+	return resp, err
+}
+
+func (d *instrumentedDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error {
+	start := time.Now()
+	err := d.driver.DeleteNetwork(req)
+	observe("DeleteNetwork", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) FreeNetwork(req *network.FreeNetworkRequest) error {
+	start := time.Now()
+	err := d.driver.FreeNetwork(req)
+	observe("FreeNetwork", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
+	*network.CreateEndpointResponse, error) {
+	start := time.Now()
+	resp, err := d.driver.CreateEndpoint(req)
+	observe("CreateEndpoint", start, err)
+	return resp, err
+}
+
+func (d *instrumentedDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) error {
+	start := time.Now()
+	err := d.driver.DeleteEndpoint(req)
+	observe("DeleteEndpoint", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoResponse,
+	error) {
+	start := time.Now()
+	resp, err := d.driver.EndpointInfo(req)
+	observe("EndpointInfo", start, err)
+	return resp, err
+}
+
+func (d *instrumentedDriver) Join(req *network.JoinRequest) (*network.JoinResponse, error) {
+	start := time.Now()
+	resp, err := d.driver.Join(req)
+	observe("Join", start, err)
+	return resp, err
+}
+
+func (d *instrumentedDriver) Leave(req *network.LeaveRequest) error {
+	start := time.Now()
+	err := d.driver.Leave(req)
+	observe("Leave", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) DiscoverNew(req *network.DiscoveryNotification) error {
+	start := time.Now()
+	err := d.driver.DiscoverNew(req)
+	observe("DiscoverNew", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) DiscoverDelete(req *network.DiscoveryNotification) error {
+	start := time.Now()
+	err := d.driver.DiscoverDelete(req)
+	observe("DiscoverDelete", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) ProgramExternalConnectivity(
+	req *network.ProgramExternalConnectivityRequest) error {
+	start := time.Now()
+	err := d.driver.ProgramExternalConnectivity(req)
+	observe("ProgramExternalConnectivity", start, err)
+	return err
+}
+
+func (d *instrumentedDriver) RevokeExternalConnectivity(
+	req *network.RevokeExternalConnectivityRequest) error {
+	start := time.Now()
+	err := d.driver.RevokeExternalConnectivity(req)
+	observe("RevokeExternalConnectivity", start, err)
+	return err
+}
@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"sync"
+
+	"github.com/docker/docker/pkg/locker"
+)
+
+// lockManager serializes docker requests that operate on the same network or endpoint.
+// Docker daemon may call the plugin for several containers at once, and most of our handlers
+// are check-then-act sequences on HNS and Contrail.
+type lockManager struct {
+	networks  *locker.Locker
+	endpoints *locker.Locker
+
+	// DeleteNetwork finds the network to remove by diffing HNS networks against docker
+	// networks, so it can't run while any other request creates or uses an HNS network.
+	// Such requests hold this lock for reading, DeleteNetwork holds it for writing.
+	hnsNetworks sync.RWMutex
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		networks:  locker.New(),
+		endpoints: locker.New(),
+	}
+}
+
+// lockNetwork locks the network with given key (e.g. Contrail tenant and network name) and
+// prevents DeleteNetwork from running until unlockNetwork is called.
+func (l *lockManager) lockNetwork(key string) {
+	l.hnsNetworks.RLock()
+	l.networks.Lock(key)
+}
+
+func (l *lockManager) unlockNetwork(key string) {
+	_ = l.networks.Unlock(key)
+	l.hnsNetworks.RUnlock()
+}
+
+// lockAllNetworks waits for all requests holding network locks and blocks new ones.
+func (l *lockManager) lockAllNetworks() {
+	l.hnsNetworks.Lock()
+}
+
+func (l *lockManager) unlockAllNetworks() {
+	l.hnsNetworks.Unlock()
+}
+
+// lockEndpoint locks the endpoint with given docker endpoint ID. Network of the endpoint can't be
+// deleted while it's locked.
+func (l *lockManager) lockEndpoint(endpointID string) {
+	l.hnsNetworks.RLock()
+	l.endpoints.Lock(endpointID)
+}
+
+func (l *lockManager) unlockEndpoint(endpointID string) {
+	_ = l.endpoints.Unlock(endpointID)
+	l.hnsNetworks.RUnlock()
+}
@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"fmt"
+	"path"
+)
+
+// PolicyError is returned for plugin requests that the host policy doesn't allow.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "Denied by host policy of Contrail driver: " + e.Reason
+}
+
+// checkNetworkPolicy returns PolicyError if the host policy doesn't allow binding the host to the
+// Contrail network.
+func (d *ContrailDriver) checkNetworkPolicy(meta *NetworkMeta) error {
+	tenants := d.config.Policy.Tenants
+	if len(tenants) == 0 {
+		return nil
+	}
+	tenantAllowed := false
+	for _, tenant := range tenants {
+		if d.domainOrDefault(tenant.Domain) != meta.domain || tenant.Name != meta.tenant {
+			continue
+		}
+		tenantAllowed = true
+		if len(tenant.Networks) == 0 {
+			return nil
+		}
+		for _, pattern := range tenant.Networks {
+			// Patterns were validated with the config.
+			if matched, _ := path.Match(pattern, meta.network); matched {
+				return nil
+			}
+		}
+	}
+	if !tenantAllowed {
+		return &PolicyError{fmt.Sprintf("tenant %s in domain %s is not allowed on this host",
+			meta.tenant, meta.domain)}
+	}
+	return &PolicyError{fmt.Sprintf("network %s is not allowed for tenant %s in domain %s",
+		meta.network, meta.tenant, meta.domain)}
+}
+
+// checkLabelPolicy returns PolicyError if the docker network doesn't have the label required by
+// the host policy.
+func (d *ContrailDriver) checkLabelPolicy(labels map[string]string) error {
+	if d.config.Policy.RequiredLabel == "" {
+		return nil
+	}
+	key, value := d.config.Policy.RequiredLabelKeyValue()
+	if actual, exists := labels[key]; exists && (value == "" || actual == value) {
+		return nil
+	}
+	return &PolicyError{fmt.Sprintf("docker network doesn't have label %s",
+		d.config.Policy.RequiredLabel)}
+}
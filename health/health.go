@@ -0,0 +1,158 @@
+// Package health serves liveness and readiness of the driver over HTTP, as JSON reports of named
+// checks.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultCheckTimeout limits how long a single check may take before it's reported as failed.
+const DefaultCheckTimeout = 5 * time.Second
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+var errCheckTimeout = errors.New("check timed out")
+
+// Check is a single named check. It returns nil if checked component works.
+type Check struct {
+	Name string
+	Fn   func() error
+}
+
+// CheckResult is a status of a single check in the report. Last error and time when it
+// occurred are kept after the check passes again, to help with diagnosing flapping components.
+type CheckResult struct {
+	Status      string     `json:"status"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Report is the JSON document served by health endpoints.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Checker runs a set of checks and remembers their last errors.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	results map[string]CheckResult
+}
+
+// NewChecker returns a checker of given checks. DefaultCheckTimeout is used if timeout isn't
+// positive.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Checker{
+		checks:  checks,
+		timeout: timeout,
+		results: make(map[string]CheckResult),
+	}
+}
+
+// Run runs all checks concurrently and returns the report. A check that doesn't finish in time
+// fails; it's left to finish in background.
+func (c *Checker) Run() Report {
+	errs := make([]error, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			errs[i] = runWithTimeout(check.Fn, c.timeout)
+		}(i, check)
+	}
+	wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult)}
+	for i, check := range c.checks {
+		result := c.results[check.Name]
+		result.Status = StatusOK
+		if errs[i] != nil {
+			now := time.Now()
+			result.Status = StatusFailing
+			result.LastError = errs[i].Error()
+			result.LastErrorAt = &now
+			report.Status = StatusFailing
+			log.Warnf("Health check %s failed: %v", check.Name, errs[i])
+		}
+		c.results[check.Name] = result
+		report.Checks[check.Name] = result
+	}
+	return report
+}
+
+func runWithTimeout(fn func() error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errCheckTimeout
+	}
+}
+
+// ServeHTTP runs the checks and writes the report. Status is 200 if all checks pass and 503
+// otherwise.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := c.Run()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Warnln("Failed to write health report:", err)
+	}
+}
+
+// Server serves liveness checks on /healthz and readiness checks on /readyz.
+type Server struct {
+	listener net.Listener
+}
+
+// Listen starts serving health endpoints on given TCP address, e.g. "127.0.0.1:9274".
+func Listen(address string, liveness, readiness *Checker) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", liveness)
+	mux.Handle("/readyz", readiness)
+	go http.Serve(listener, mux)
+	log.Infoln("Serving health checks on", listener.Addr())
+	return &Server{listener: listener}, nil
+}
+
+// Addr returns address that the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections. Checks that are in progress are finished.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
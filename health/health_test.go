@@ -0,0 +1,115 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("health_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Health test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Health checks", func() {
+
+	var hnsErr error
+	var checker *Checker
+
+	BeforeEach(func() {
+		hnsErr = nil
+		checker = NewChecker(50*time.Millisecond,
+			Check{Name: "contrail", Fn: func() error { return nil }},
+			Check{Name: "hns", Fn: func() error { return hnsErr }},
+		)
+	})
+
+	It("reports ok when all checks pass", func() {
+		report := checker.Run()
+		Expect(report.Status).To(Equal(StatusOK))
+		Expect(report.Checks).To(HaveLen(2))
+		Expect(report.Checks["hns"]).To(Equal(CheckResult{Status: StatusOK}))
+	})
+
+	It("reports failing check with its error", func() {
+		hnsErr = errors.New("HNS failed with error : Element not found.")
+		report := checker.Run()
+		Expect(report.Status).To(Equal(StatusFailing))
+		Expect(report.Checks["contrail"].Status).To(Equal(StatusOK))
+		Expect(report.Checks["hns"].Status).To(Equal(StatusFailing))
+		Expect(report.Checks["hns"].LastError).To(Equal(hnsErr.Error()))
+		Expect(report.Checks["hns"].LastErrorAt).ToNot(BeNil())
+	})
+
+	It("keeps last error after check passes again", func() {
+		hnsErr = errors.New("HNS failed with error : Element not found.")
+		checker.Run()
+		hnsErr = nil
+
+		report := checker.Run()
+		Expect(report.Status).To(Equal(StatusOK))
+		Expect(report.Checks["hns"].Status).To(Equal(StatusOK))
+		Expect(report.Checks["hns"].LastError).To(ContainSubstring("Element not found"))
+	})
+
+	It("fails checks that don't finish in time", func() {
+		release := make(chan struct{})
+		defer close(release)
+		checker = NewChecker(20*time.Millisecond, Check{Name: "docker", Fn: func() error {
+			<-release
+			return nil
+		}})
+
+		report := checker.Run()
+		Expect(report.Checks["docker"].Status).To(Equal(StatusFailing))
+		Expect(report.Checks["docker"].LastError).To(Equal(errCheckTimeout.Error()))
+	})
+
+	Context("served over HTTP", func() {
+		var server *Server
+
+		BeforeEach(func() {
+			liveness := NewChecker(time.Second, Check{Name: "pluginListener",
+				Fn: func() error { return nil }})
+			var err error
+			server, err = Listen("127.0.0.1:0", liveness, checker)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		get := func(path string) (int, Report) {
+			resp, err := http.Get("http://" + server.Addr().String() + path)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			var report Report
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			return resp.StatusCode, report
+		}
+
+		It("serves liveness on /healthz", func() {
+			status, report := get("/healthz")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(report.Checks).To(HaveKey("pluginListener"))
+		})
+
+		It("serves readiness on /readyz with 503 when a check fails", func() {
+			hnsErr = errors.New("HNS is not running")
+			status, report := get("/readyz")
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(report.Status).To(Equal(StatusFailing))
+			Expect(report.Checks["hns"].LastError).To(Equal("HNS is not running"))
+		})
+	})
+})
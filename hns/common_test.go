@@ -0,0 +1,16 @@
+package hns
+
+import "context"
+
+// netAdapter is set by -netAdapter flag in the suite that uses actual HNS.
+var netAdapter = "Ethernet0"
+
+// ctx is the context of HNS calls made by tests.
+var ctx = context.Background()
+
+const (
+	tenantName  = "agatka"
+	networkName = "test_net"
+	subnetCIDR  = "10.0.0.0/24"
+	defaultGW   = "10.0.0.1"
+)
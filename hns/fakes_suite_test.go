@@ -0,0 +1,20 @@
+//go:build fakes
+// +build fakes
+
+package hns
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+// TestFakeHNS runs specs of the in-memory fake of HNS. They don't need actual HNS, so they are
+// built with -tags fakes into a suite of their own that doesn't reset HNS of the host.
+func TestFakeHNS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("hns_fakes_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Fake HNS test suite", []Reporter{junitReporter})
+}
@@ -1,145 +1,69 @@
 package hns
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
-	"github.com/Microsoft/hcsshim"
-	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
 )
 
-func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
-	log.Infoln("Creating HNS network")
-	configBytes, err := json.Marshal(configuration)
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	log.Debugln("Config:", string(configBytes))
-	response, err := hcsshim.HNSNetworkRequest("POST", "", string(configBytes))
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	// Annoying HNS issue, sleep as a workaround.
-	// https://github.com/Microsoft/hcsshim/issues/108
-	time.Sleep(time.Second * 2)
-	return response.Id, nil
-}
-
-func DeleteHNSNetwork(hnsID string) error {
-	log.Infoln("Deleting HNS network", hnsID)
-	_, err := hcsshim.HNSNetworkRequest("DELETE", hnsID, "")
-	if err != nil {
-		log.Errorln(err)
-		return err
-	}
-	return nil
-}
-
-func ListHNSNetworks() ([]hcsshim.HNSNetwork, error) {
-	log.Infoln("Listing HNS networks")
-	nets, err := hcsshim.HNSListNetworkRequest("GET", "", "")
-	if err != nil {
-		log.Errorln(err)
-		return nil, err
-	}
-	return nets, nil
-}
-
-func GetHNSNetwork(hnsID string) (*hcsshim.HNSNetwork, error) {
-	log.Infoln("Getting HNS network", hnsID)
-	net, err := hcsshim.HNSNetworkRequest("GET", hnsID, "")
+// WaitForNetwork waits until HNS network with given ID exists and, if its type binds it to a
+// network adapter, until it reports the adapter. Connections of the host may fail until then
+// (https://github.com/Microsoft/hcsshim/issues/108), so it should be called after creating
+// a network. Returns the ready network, or an error if it isn't ready after timeout.
+func WaitForNetwork(ctx context.Context, h HNS, hnsID string,
+	timeout time.Duration) (*Network, error) {
+	var net *Network
+	err := common.Poll(timeout, func() error {
+		var err error
+		if net, err = h.GetNetwork(ctx, hnsID); err != nil {
+			return err
+		}
+		if net == nil {
+			return fmt.Errorf("HNS network %s doesn't exist", hnsID)
+		}
+		if needsAdapter(net.Type) && net.NetworkAdapterName == "" {
+			return fmt.Errorf("HNS network %s has no network adapter yet", hnsID)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Errorln(err)
 		return nil, err
 	}
+	common.Logger(ctx).Debugln("HNS network", hnsID, "is ready")
 	return net, nil
 }
 
-func GetHNSNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
-	log.Infoln("Getting HNS network by name:", name)
-	nets, err := hcsshim.HNSListNetworkRequest("GET", "", "")
-	if err != nil {
-		log.Errorln(err)
-		return nil, err
-	}
-	for _, n := range nets {
-		if n.Name == name {
-			return &n, nil
-		}
-	}
-	return nil, nil
-}
-
-func CreateHNSEndpoint(configuration *hcsshim.HNSEndpoint) (string, error) {
-	log.Infoln("Creating HNS endpoint")
-	configBytes, err := json.Marshal(configuration)
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	log.Debugln("Config: ", string(configBytes))
-	response, err := hcsshim.HNSEndpointRequest("POST", "", string(configBytes))
-	if err != nil {
-		return "", err
-	}
-	return response.Id, nil
-}
-
-func DeleteHNSEndpoint(endpointID string) error {
-	log.Infoln("Deleting HNS endpoint", endpointID)
-	_, err := hcsshim.HNSEndpointRequest("DELETE", endpointID, "")
-	if err != nil {
-		log.Errorln(err)
-		return err
+// needsAdapter tells whether HNS networks of given type are bound to a network adapter.
+func needsAdapter(networkType string) bool {
+	switch strings.ToLower(networkType) {
+	case "transparent", "l2bridge", "l2tunnel", "overlay":
+		return true
 	}
-	return nil
+	return false
 }
 
-func GetHNSEndpoint(endpointID string) (*hcsshim.HNSEndpoint, error) {
-	log.Infoln("Getting HNS endpoint", endpointID)
-	endpoint, err := hcsshim.HNSEndpointRequest("GET", endpointID, "")
-	if err != nil {
-		log.Errorln(err)
-		return nil, err
-	}
-	return endpoint, nil
-}
-
-func GetHNSEndpointByName(name string) (*hcsshim.HNSEndpoint, error) {
-	log.Infoln("Getting HNS endpoint by name:", name)
-	eps, err := hcsshim.HNSListEndpointRequest("GET", "", "")
-	if err != nil {
-		log.Errorln(err)
-		return nil, err
-	}
-	for _, ep := range eps {
-		if ep.Name == name {
-			return &ep, nil
-		}
-	}
-	return nil, nil
-}
+// HNS is the set of Host Networking Service operations used by the driver. It's implemented
+// by the real HNS (see NewHNS) and by an in-memory fake (see NewFakeHNS).
+type HNS interface {
+	CreateNetwork(ctx context.Context, configuration *Network) (string, error)
+	DeleteNetwork(ctx context.Context, hnsID string) error
+	ListNetworks(ctx context.Context) ([]Network, error)
+	GetNetwork(ctx context.Context, hnsID string) (*Network, error)
+	// GetNetworkByName returns nil if network doesn't exist.
+	GetNetworkByName(ctx context.Context, name string) (*Network, error)
 
-func ListHNSEndpoints() ([]hcsshim.HNSEndpoint, error) {
-	endpoints, err := hcsshim.HNSListEndpointRequest("GET", "", "")
-	if err != nil {
-		return nil, err
-	}
-	return endpoints, nil
-}
+	CreateEndpoint(ctx context.Context, configuration *Endpoint) (string, error)
+	DeleteEndpoint(ctx context.Context, endpointID string) error
+	ListEndpoints(ctx context.Context) ([]Endpoint, error)
+	ListEndpointsOfNetwork(ctx context.Context, netID string) ([]Endpoint, error)
+	GetEndpoint(ctx context.Context, endpointID string) (*Endpoint, error)
+	// GetEndpointByName returns nil if endpoint doesn't exist.
+	GetEndpointByName(ctx context.Context, name string) (*Endpoint, error)
 
-func ListHNSEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error) {
-	eps, err := ListHNSEndpoints()
-	if err != nil {
-		return nil, err
-	}
-	var epsInNetwork []hcsshim.HNSEndpoint
-	for _, ep := range eps {
-		if ep.VirtualNetwork == netID {
-			epsInNetwork = append(epsInNetwork, ep)
-		}
-	}
-	return epsInNetwork, nil
+	// SetEndpointPolicies replaces policies of existing endpoint.
+	SetEndpointPolicies(ctx context.Context, endpointID string, policies []json.RawMessage) error
 }
@@ -0,0 +1,239 @@
+package hns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// FakeHNS is an in-memory implementation of HNS interface. It mimics behaviour of the actual
+// HNS that the driver relies on: it assigns IDs to created objects, finds them by name, attaches
+// endpoints to networks and refuses to delete a network that still has endpoints.
+type FakeHNS struct {
+	mutex     sync.Mutex
+	networks  map[string]*Network
+	endpoints map[string]*Endpoint
+
+	// Networks created with networkReadyDelay set have no adapter until their readyAt time, like
+	// networks of the actual HNS that are still being attached to the adapter.
+	networkReadyDelay time.Duration
+	readyAt           map[string]time.Time
+}
+
+// ErrNotFound is returned by FakeHNS when requested object doesn't exist, like HNS does.
+var ErrNotFound = errors.New("HNS failed with error : Element not found.")
+
+func NewFakeHNS() *FakeHNS {
+	return &FakeHNS{
+		networks:  make(map[string]*Network),
+		endpoints: make(map[string]*Endpoint),
+		readyAt:   make(map[string]time.Time),
+	}
+}
+
+// SetNetworkReadyDelay makes networks created afterwards report their adapter only after delay.
+func (f *FakeHNS) SetNetworkReadyDelay(delay time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.networkReadyDelay = delay
+}
+
+// visibleNetwork returns copy of the network as HNS reports it at the moment.
+func (f *FakeHNS) visibleNetwork(net *Network) *Network {
+	netCopy := copyNetwork(net)
+	if time.Now().Before(f.readyAt[net.Id]) {
+		netCopy.NetworkAdapterName = ""
+	}
+	return netCopy
+}
+
+func newFakeHNSID() string {
+	return strings.ToUpper(uuid.New())
+}
+
+func (f *FakeHNS) CreateNetwork(ctx context.Context, configuration *Network) (string,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if configuration.Type == "" {
+		return "", errors.New("HNS failed with error : The parameter is incorrect.")
+	}
+	net := copyNetwork(configuration)
+	net.Id = newFakeHNSID()
+	f.networks[net.Id] = net
+	if f.networkReadyDelay > 0 {
+		f.readyAt[net.Id] = time.Now().Add(f.networkReadyDelay)
+	}
+	return net.Id, nil
+}
+
+func (f *FakeHNS) DeleteNetwork(ctx context.Context, hnsID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.networks[hnsID]; !exists {
+		return ErrNotFound
+	}
+	for _, ep := range f.endpoints {
+		if ep.VirtualNetwork == hnsID {
+			return errors.New("HNS failed with error : Network has active endpoints.")
+		}
+	}
+	delete(f.networks, hnsID)
+	delete(f.readyAt, hnsID)
+	return nil
+}
+
+func (f *FakeHNS) ListNetworks(ctx context.Context) ([]Network, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	nets := []Network{}
+	for _, net := range f.networks {
+		nets = append(nets, *f.visibleNetwork(net))
+	}
+	return nets, nil
+}
+
+func (f *FakeHNS) GetNetwork(ctx context.Context, hnsID string) (*Network, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	net, exists := f.networks[hnsID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return f.visibleNetwork(net), nil
+}
+
+func (f *FakeHNS) GetNetworkByName(ctx context.Context, name string) (*Network, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, net := range f.networks {
+		if net.Name == name {
+			return f.visibleNetwork(net), nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeHNS) CreateEndpoint(ctx context.Context, configuration *Endpoint) (string,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var net *Network
+	for _, n := range f.networks {
+		if n.Id == configuration.VirtualNetwork ||
+			(configuration.VirtualNetworkName != "" && n.Name == configuration.VirtualNetworkName) {
+			net = n
+			break
+		}
+	}
+	if net == nil {
+		return "", ErrNotFound
+	}
+
+	ep := copyEndpoint(configuration)
+	ep.Id = newFakeHNSID()
+	ep.VirtualNetwork = net.Id
+	ep.VirtualNetworkName = net.Name
+	f.endpoints[ep.Id] = ep
+	return ep.Id, nil
+}
+
+func (f *FakeHNS) DeleteEndpoint(ctx context.Context, endpointID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.endpoints[endpointID]; !exists {
+		return ErrNotFound
+	}
+	delete(f.endpoints, endpointID)
+	return nil
+}
+
+func (f *FakeHNS) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	eps := []Endpoint{}
+	for _, ep := range f.endpoints {
+		eps = append(eps, *copyEndpoint(ep))
+	}
+	return eps, nil
+}
+
+func (f *FakeHNS) ListEndpointsOfNetwork(ctx context.Context,
+	netID string) ([]Endpoint, error) {
+	eps, err := f.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var epsInNetwork []Endpoint
+	for _, ep := range eps {
+		if ep.VirtualNetwork == netID {
+			epsInNetwork = append(epsInNetwork, ep)
+		}
+	}
+	return epsInNetwork, nil
+}
+
+func (f *FakeHNS) GetEndpoint(ctx context.Context, endpointID string) (*Endpoint,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ep, exists := f.endpoints[endpointID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return copyEndpoint(ep), nil
+}
+
+func (f *FakeHNS) GetEndpointByName(ctx context.Context, name string) (*Endpoint,
+	error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, ep := range f.endpoints {
+		if ep.Name == name {
+			return copyEndpoint(ep), nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeHNS) SetEndpointPolicies(ctx context.Context, endpointID string,
+	policies []json.RawMessage) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ep, exists := f.endpoints[endpointID]
+	if !exists {
+		return ErrNotFound
+	}
+	ep.Policies = append([]json.RawMessage{}, policies...)
+	return nil
+}
+
+func copyNetwork(net *Network) *Network {
+	netCopy := *net
+	netCopy.Policies = append([]json.RawMessage(nil), net.Policies...)
+	netCopy.MacPools = append([]MacPool(nil), net.MacPools...)
+	netCopy.Subnets = append([]Subnet(nil), net.Subnets...)
+	return &netCopy
+}
+
+func copyEndpoint(ep *Endpoint) *Endpoint {
+	epCopy := *ep
+	epCopy.Policies = append([]json.RawMessage(nil), ep.Policies...)
+	return &epCopy
+}
@@ -0,0 +1,189 @@
+//go:build fakes
+// +build fakes
+
+package hns
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake HNS", func() {
+
+	const testNetName = "TestNetwork"
+
+	var fake *FakeHNS
+
+	BeforeEach(func() {
+		fake = NewFakeHNS()
+	})
+
+	Specify("created network can be found by ID and by name", func() {
+		netID := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+
+		net, err := fake.GetNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Name).To(Equal(testNetName))
+		Expect(net.Subnets[0].AddressPrefix).To(Equal(subnetCIDR))
+
+		net, err = fake.GetNetworkByName(ctx, testNetName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Id).To(Equal(netID))
+	})
+
+	Specify("created objects get unique IDs", func() {
+		netID1 := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+		netID2 := MockHNSNetwork(fake, "other_net_name", netAdapter, subnetCIDR, defaultGW)
+		Expect(netID1).ToNot(Equal(netID2))
+
+		epID1 := MockHNSEndpoint(fake, netID1)
+		epID2 := MockHNSEndpoint(fake, netID1)
+		Expect(epID1).ToNot(Equal(epID2))
+	})
+
+	Specify("getting nonexisting objects by name returns nil", func() {
+		net, err := fake.GetNetworkByName(ctx, testNetName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net).To(BeNil())
+
+		ep, err := fake.GetEndpointByName(ctx, "nonexisting")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep).To(BeNil())
+	})
+
+	Specify("getting nonexisting objects by ID returns error", func() {
+		_, err := fake.GetNetwork(ctx, "1234")
+		Expect(err).To(HaveOccurred())
+
+		_, err = fake.GetEndpoint(ctx, "1234")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("endpoint can't be created in nonexisting network", func() {
+		_, err := fake.CreateEndpoint(ctx, &Endpoint{
+			VirtualNetworkName: testNetName,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("endpoint created by network name is attached to it", func() {
+		netID := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+		epID, err := fake.CreateEndpoint(ctx, &Endpoint{
+			Name:               "ep",
+			VirtualNetworkName: testNetName,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		eps, err := fake.ListEndpointsOfNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eps).To(HaveLen(1))
+		Expect(eps[0].Id).To(Equal(epID))
+
+		ep, err := fake.GetEndpointByName(ctx, "ep")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep.VirtualNetwork).To(Equal(netID))
+	})
+
+	Specify("network with endpoints can't be deleted", func() {
+		netID := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+		epID := MockHNSEndpoint(fake, netID)
+
+		err := fake.DeleteNetwork(ctx, netID)
+		Expect(err).To(HaveOccurred())
+
+		err = fake.DeleteEndpoint(ctx, epID)
+		Expect(err).ToNot(HaveOccurred())
+		err = fake.DeleteNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+
+		nets, err := fake.ListNetworks(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nets).To(BeEmpty())
+	})
+
+	Specify("endpoint policies can be replaced", func() {
+		netID := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+		epID := MockHNSEndpoint(fake, netID)
+
+		policy, err := json.Marshal(VlanPolicy{Type: "VLAN", VLAN: 7})
+		Expect(err).ToNot(HaveOccurred())
+		err = fake.SetEndpointPolicies(ctx, epID, []json.RawMessage{policy})
+		Expect(err).ToNot(HaveOccurred())
+
+		ep, err := fake.GetEndpoint(ctx, epID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep.Policies).To(HaveLen(1))
+		Expect(string(ep.Policies[0])).To(Equal(string(policy)))
+	})
+
+	Specify("modifying returned objects doesn't change stored ones", func() {
+		netID := MockHNSNetwork(fake, testNetName, netAdapter, subnetCIDR, defaultGW)
+
+		net, err := fake.GetNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+		net.Name = "changed"
+		net.Subnets[0].AddressPrefix = "1.2.3.0/24"
+
+		net, err = fake.GetNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Name).To(Equal(testNetName))
+		Expect(net.Subnets[0].AddressPrefix).To(Equal(subnetCIDR))
+	})
+})
+
+var _ = Describe("Waiting for HNS network", func() {
+
+	const delay = 200 * time.Millisecond
+
+	var fake *FakeHNS
+
+	BeforeEach(func() {
+		fake = NewFakeHNS()
+		fake.SetNetworkReadyDelay(delay)
+	})
+
+	createNetwork := func(networkType, adapter string) string {
+		netID, err := fake.CreateNetwork(ctx, &Network{
+			Name:               "TestNetwork",
+			Type:               networkType,
+			NetworkAdapterName: adapter,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return netID
+	}
+
+	It("waits until network gets its adapter", func() {
+		start := time.Now()
+		netID := createNetwork("transparent", netAdapter)
+		net, err := fake.GetNetwork(ctx, netID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.NetworkAdapterName).To(BeEmpty())
+
+		net, err = WaitForNetwork(ctx, fake, netID, 5*time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.NetworkAdapterName).To(Equal(netAdapter))
+		Expect(time.Since(start)).To(BeNumerically(">=", delay))
+		Expect(time.Since(start)).To(BeNumerically("<", delay+time.Second))
+	})
+
+	It("gives up after timeout", func() {
+		netID := createNetwork("transparent", netAdapter)
+		_, err := WaitForNetwork(ctx, fake, netID, delay/4)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("no network adapter"))
+	})
+
+	It("doesn't wait for adapter of networks that have none", func() {
+		netID := createNetwork("nat", "")
+		_, err := WaitForNetwork(ctx, fake, netID, delay/4)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reports network that doesn't exist", func() {
+		_, err := WaitForNetwork(ctx, fake, "1234", delay/4)
+		Expect(err).To(HaveOccurred())
+	})
+})
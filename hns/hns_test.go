@@ -1,3 +1,6 @@
+//go:build windows && !fakes
+// +build windows,!fakes
+
 package hns
 
 import (
@@ -6,10 +9,10 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
-	"github.com/Microsoft/hcsshim"
 	"github.com/codilime/contrail-windows-docker/common"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -17,7 +20,6 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-var netAdapter string
 var controllerAddr string
 var controllerPort int
 var useActualController bool
@@ -50,19 +52,12 @@ var _ = AfterSuite(func() {
 	Expect(err).ToNot(HaveOccurred())
 })
 
-const (
-	tenantName  = "agatka"
-	networkName = "test_net"
-	subnetCIDR  = "10.0.0.0/24"
-	defaultGW   = "10.0.0.1"
-)
-
 var _ = Describe("HNS wrapper", func() {
 
 	var originalNumNetworks int
 
 	BeforeEach(func() {
-		nets, err := ListHNSNetworks()
+		nets, err := ListHNSNetworks(ctx)
 		Expect(err).ToNot(HaveOccurred())
 		originalNumNetworks = len(nets)
 	})
@@ -76,40 +71,40 @@ var _ = Describe("HNS wrapper", func() {
 			expectNumberOfEndpoints(0)
 
 			Expect(testHnsNetID).To(Equal(""))
-			testHnsNetID = MockHNSNetwork(testNetName, netAdapter, subnetCIDR, defaultGW)
+			testHnsNetID = MockHNSNetwork(NewHNS(), testNetName, netAdapter, subnetCIDR, defaultGW)
 			Expect(testHnsNetID).ToNot(Equal(""))
 
-			net, err := GetHNSNetwork(testHnsNetID)
+			net, err := GetHNSNetwork(ctx, testHnsNetID)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net).ToNot(BeNil())
 		})
 
 		AfterEach(func() {
-			endpoints, err := ListHNSEndpoints()
+			endpoints, err := ListHNSEndpoints(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			if len(endpoints) > 0 {
 				// Cleanup lingering endpoints.
 				for _, ep := range endpoints {
-					err = DeleteHNSEndpoint(ep.Id)
+					err = DeleteHNSEndpoint(ctx, ep.Id)
 					Expect(err).ToNot(HaveOccurred())
 				}
 				expectNumberOfEndpoints(0)
 			}
 
 			Expect(testHnsNetID).ToNot(Equal(""))
-			err = DeleteHNSNetwork(testHnsNetID)
+			err = DeleteHNSNetwork(ctx, testHnsNetID)
 			Expect(err).ToNot(HaveOccurred())
-			_, err = GetHNSNetwork(testHnsNetID)
+			_, err = GetHNSNetwork(ctx, testHnsNetID)
 			Expect(err).To(HaveOccurred())
 			testHnsNetID = ""
-			nets, err := ListHNSNetworks()
+			nets, err := ListHNSNetworks(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(nets).ToNot(BeNil())
 			Expect(len(nets)).To(Equal(originalNumNetworks))
 		})
 
 		Specify("listing all HNS networks works", func() {
-			nets, err := ListHNSNetworks()
+			nets, err := ListHNSNetworks(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(nets).ToNot(BeNil())
 			Expect(len(nets)).To(Equal(originalNumNetworks + 1))
@@ -124,30 +119,30 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("getting a single HNS network works", func() {
-			net, err := GetHNSNetwork(testHnsNetID)
+			net, err := GetHNSNetwork(ctx, testHnsNetID)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net).ToNot(BeNil())
 			Expect(net.Id).To(Equal(testHnsNetID))
 		})
 
 		Specify("getting a single HNS network by name works", func() {
-			net, err := GetHNSNetworkByName(testNetName)
+			net, err := GetHNSNetworkByName(ctx, testNetName)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net).ToNot(BeNil())
 			Expect(net.Id).To(Equal(testHnsNetID))
 		})
 
 		Specify("HNS endpoint operations work", func() {
-			hnsEndpointConfig := &hcsshim.HNSEndpoint{
+			hnsEndpointConfig := &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				Name:           "ep_name",
 			}
 
-			endpointID, err := CreateHNSEndpoint(hnsEndpointConfig)
+			endpointID, err := CreateHNSEndpoint(ctx, hnsEndpointConfig)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(endpointID).ToNot(Equal(""))
 
-			endpoint, err := GetHNSEndpoint(endpointID)
+			endpoint, err := GetHNSEndpoint(ctx, endpointID)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(endpoint).ToNot(BeNil())
 
@@ -155,26 +150,26 @@ var _ = Describe("HNS wrapper", func() {
 
 			log.Infoln(endpoint)
 
-			err = DeleteHNSEndpoint(endpointID)
+			err = DeleteHNSEndpoint(ctx, endpointID)
 			Expect(err).ToNot(HaveOccurred())
 
-			endpoint, err = GetHNSEndpoint(endpointID)
+			endpoint, err = GetHNSEndpoint(ctx, endpointID)
 			Expect(err).To(HaveOccurred())
 			Expect(endpoint).To(BeNil())
 		})
 
 		Specify("Listing HNS endpoints works", func() {
-			hnsEndpointConfig := &hcsshim.HNSEndpoint{
+			hnsEndpointConfig := &Endpoint{
 				VirtualNetwork: testHnsNetID,
 			}
 
-			endpointsList, err := ListHNSEndpoints()
+			endpointsList, err := ListHNSEndpoints(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			numEndpointsOriginal := len(endpointsList)
 
 			var endpoints [2]string
 			for i := 0; i < 2; i++ {
-				endpoints[i], err = CreateHNSEndpoint(hnsEndpointConfig)
+				endpoints[i], err = CreateHNSEndpoint(ctx, hnsEndpointConfig)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(endpoints[i]).ToNot(Equal(""))
 			}
@@ -182,7 +177,7 @@ var _ = Describe("HNS wrapper", func() {
 			expectNumberOfEndpoints(numEndpointsOriginal + 2)
 
 			for _, ep := range endpoints {
-				err = DeleteHNSEndpoint(ep)
+				err = DeleteHNSEndpoint(ctx, ep)
 				Expect(err).ToNot(HaveOccurred())
 			}
 
@@ -192,15 +187,15 @@ var _ = Describe("HNS wrapper", func() {
 		Specify("Getting HNS endpoint by name works", func() {
 			names := []string{"name1", "name2", "name3"}
 			for _, name := range names {
-				hnsEndpointConfig := &hcsshim.HNSEndpoint{
+				hnsEndpointConfig := &Endpoint{
 					VirtualNetwork: testHnsNetID,
 					Name:           name,
 				}
-				_, err := CreateHNSEndpoint(hnsEndpointConfig)
+				_, err := CreateHNSEndpoint(ctx, hnsEndpointConfig)
 				Expect(err).ToNot(HaveOccurred())
 			}
 
-			ep, err := GetHNSEndpointByName("name2")
+			ep, err := GetHNSEndpointByName(ctx, "name2")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(ep.Name).To(Equal("name2"))
 		})
@@ -208,18 +203,18 @@ var _ = Describe("HNS wrapper", func() {
 		Context("There's a second HNS network", func() {
 			secondHNSNetID := ""
 			BeforeEach(func() {
-				secondHNSNetID = MockHNSNetwork("other_net_name", netAdapter, subnetCIDR,
+				secondHNSNetID = MockHNSNetwork(NewHNS(), "other_net_name", netAdapter, subnetCIDR,
 					defaultGW)
 			})
 			AfterEach(func() {
-				err := DeleteHNSNetwork(secondHNSNetID)
+				err := DeleteHNSNetwork(ctx, secondHNSNetID)
 				Expect(err).ToNot(HaveOccurred())
 			})
 			Specify("Listing HNS endpoints of specific network works", func() {
-				config1 := &hcsshim.HNSEndpoint{
+				config1 := &Endpoint{
 					VirtualNetwork: testHnsNetID,
 				}
-				config2 := &hcsshim.HNSEndpoint{
+				config2 := &Endpoint{
 					VirtualNetwork: secondHNSNetID,
 				}
 
@@ -228,18 +223,18 @@ var _ = Describe("HNS wrapper", func() {
 
 				// create 3 endpoints in each network
 				for i := 0; i < 3; i++ {
-					ep1, err := CreateHNSEndpoint(config1)
+					ep1, err := CreateHNSEndpoint(ctx, config1)
 					Expect(err).ToNot(HaveOccurred())
 
 					epsInFirstNet = append(epsInFirstNet, ep1)
 
-					ep2, err := CreateHNSEndpoint(config2)
+					ep2, err := CreateHNSEndpoint(ctx, config2)
 					Expect(err).ToNot(HaveOccurred())
 
 					epsInSecondNet = append(epsInSecondNet, ep2)
 				}
 
-				foundEpsOfFirstNet, err := ListHNSEndpointsOfNetwork(testHnsNetID)
+				foundEpsOfFirstNet, err := ListHNSEndpointsOfNetwork(ctx, testHnsNetID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(foundEpsOfFirstNet).To(HaveLen(3))
 				for _, ep := range foundEpsOfFirstNet {
@@ -247,7 +242,7 @@ var _ = Describe("HNS wrapper", func() {
 					Expect(epsInSecondNet).ToNot(ContainElement(ep.Id))
 				}
 
-				foundEpsOfSecondNet, err := ListHNSEndpointsOfNetwork(secondHNSNetID)
+				foundEpsOfSecondNet, err := ListHNSEndpointsOfNetwork(ctx, secondHNSNetID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(foundEpsOfSecondNet).To(HaveLen(3))
 				for _, ep := range foundEpsOfSecondNet {
@@ -258,7 +253,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating endpoint in same subnet works", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(ctx, &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
@@ -267,7 +262,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating endpoint in different subnet fails", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(ctx, &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.1.0.4"),
 			})
@@ -276,13 +271,13 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating two endpoints with same IP works in same subnet fails", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(ctx, &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			_, err = CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err = CreateHNSEndpoint(ctx, &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
@@ -297,7 +292,7 @@ var _ = Describe("HNS wrapper", func() {
 		}
 		DescribeTable("Creating an endpoint with specific MACs",
 			func(t MACTestCase) {
-				epID, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+				epID, err := CreateHNSEndpoint(ctx, &Endpoint{
 					VirtualNetwork: testHnsNetID,
 					MacAddress:     t.MAC,
 				})
@@ -306,7 +301,7 @@ var _ = Describe("HNS wrapper", func() {
 					expectNumberOfEndpoints(0)
 				} else {
 					Expect(err).ToNot(HaveOccurred())
-					ep, err := GetHNSEndpoint(epID)
+					ep, err := GetHNSEndpoint(ctx, epID)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(ep.MacAddress).To(Equal(t.MAC))
 					expectNumberOfEndpoints(1)
@@ -327,12 +322,12 @@ var _ = Describe("HNS wrapper", func() {
 		)
 
 		Specify("Creating multiple endpoints with conflicting MACs works", func() {
-			cfg := &hcsshim.HNSEndpoint{
+			cfg := &Endpoint{
 				VirtualNetwork: testHnsNetID,
 				MacAddress:     "11-22-33-44-55-66",
 			}
 			for i := 0; i < 3; i++ {
-				_, err := CreateHNSEndpoint(cfg)
+				_, err := CreateHNSEndpoint(ctx, cfg)
 				Expect(err).ToNot(HaveOccurred())
 			}
 			expectNumberOfEndpoints(3)
@@ -342,31 +337,31 @@ var _ = Describe("HNS wrapper", func() {
 	Context("HNS network doesn't exist", func() {
 
 		BeforeEach(func() {
-			nets, err := ListHNSNetworks()
+			nets, err := ListHNSNetworks(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(len(nets)).To(Equal(originalNumNetworks))
 		})
 
 		AfterEach(func() {
-			nets, err := ListHNSNetworks()
+			nets, err := ListHNSNetworks(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			for _, n := range nets {
 				if strings.Contains(n.Name, "nat") {
 					continue
 				}
-				err = DeleteHNSNetwork(n.Id)
+				err = DeleteHNSNetwork(ctx, n.Id)
 				Expect(err).ToNot(HaveOccurred())
 			}
 		})
 
 		Specify("getting single HNS network returns error", func() {
-			net, err := GetHNSNetwork("1234abcd")
+			net, err := GetHNSNetwork(ctx, "1234abcd")
 			Expect(err).To(HaveOccurred())
 			Expect(net).To(BeNil())
 		})
 
 		Specify("getting single HNS network by name returns nil, nil", func() {
-			net, err := GetHNSNetworkByName("asdf")
+			net, err := GetHNSNetworkByName(ctx, "asdf")
 			Expect(err).To(BeNil())
 			Expect(net).To(BeNil())
 		})
@@ -377,7 +372,8 @@ var _ = Describe("HNS race conditions workarounds", func() {
 
 	var targetAddr string
 	const (
-		numTries = 20
+		numTries            = 20
+		networkReadyTimeout = 10 * time.Second
 	)
 
 	BeforeEach(func() {
@@ -397,13 +393,13 @@ var _ = Describe("HNS race conditions workarounds", func() {
 
 	Context("subnet is specified in new HNS switch config", func() {
 
-		subnets := []hcsshim.Subnet{
+		subnets := []Subnet{
 			{
 				AddressPrefix:  "10.0.0.0/24",
 				GatewayAddress: "10.0.0.1",
 			},
 		}
-		configuration := &hcsshim.HNSNetwork{
+		configuration := &Network{
 			Type:               "transparent",
 			NetworkAdapterName: netAdapter,
 			Subnets:            subnets,
@@ -416,13 +412,15 @@ var _ = Describe("HNS race conditions workarounds", func() {
 			for i := 0; i < numTries; i++ {
 				networkIDMsg := fmt.Sprintf("net%v", i)
 				By(fmt.Sprintf("HNS network %s was just created", networkIDMsg))
-				netID, err := CreateHNSNetwork(configuration)
+				netID, err := CreateHNSNetwork(ctx, configuration)
+				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
+				_, err = WaitForNetwork(ctx, NewHNS(), netID, networkReadyTimeout)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 				_, err = net.Dial("tcp", targetAddr)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 
 				By(fmt.Sprintf("HNS network %s was just deleted", networkIDMsg))
-				err = DeleteHNSNetwork(netID)
+				err = DeleteHNSNetwork(ctx, netID)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 				_, err = net.Dial("tcp", targetAddr)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
@@ -437,9 +435,11 @@ var _ = Describe("HNS race conditions workarounds", func() {
 				networkIDMsg := fmt.Sprintf("net%v", i)
 				By(fmt.Sprintf("HNS network %s was just created", networkIDMsg))
 				configuration.Name = networkIDMsg
-				netID, err := CreateHNSNetwork(configuration)
+				netID, err := CreateHNSNetwork(ctx, configuration)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 				netIDs = append(netIDs, netID)
+				_, err = WaitForNetwork(ctx, NewHNS(), netID, networkReadyTimeout)
+				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 				_, err = net.Dial("tcp", targetAddr)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 			}
@@ -447,7 +447,7 @@ var _ = Describe("HNS race conditions workarounds", func() {
 			for i, netID := range netIDs {
 				networkIDMsg := fmt.Sprintf("net%v", i)
 				By(fmt.Sprintf("HNS network %s was just deleted", networkIDMsg))
-				err := DeleteHNSNetwork(netID)
+				err := DeleteHNSNetwork(ctx, netID)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
 				_, err = net.Dial("tcp", targetAddr)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
@@ -457,7 +457,7 @@ var _ = Describe("HNS race conditions workarounds", func() {
 
 	Context("subnet is NOT specified in new HNS switch config", func() {
 
-		configuration := &hcsshim.HNSNetwork{
+		configuration := &Network{
 			Type:               "transparent",
 			NetworkAdapterName: netAdapter,
 		}
@@ -468,16 +468,16 @@ var _ = Describe("HNS race conditions workarounds", func() {
 			for i := 0; i < numTries; i++ {
 				networkIDMsg := fmt.Sprintf("net%v", i)
 				By(fmt.Sprintf("HNS network %s was just created", networkIDMsg))
-				netID, err := CreateHNSNetwork(configuration)
+				netID, err := CreateHNSNetwork(ctx, configuration)
 				Expect(err).ToNot(HaveOccurred(), networkIDMsg)
-				hcsshim.HNSNetworkRequest("DELETE", netID, "")
+				networkRequest("DELETE", netID, "")
 			}
 		})
 	})
 })
 
 func expectNumberOfEndpoints(num int) {
-	eps, err := ListHNSEndpoints()
+	eps, err := ListHNSEndpoints(ctx)
 	Expect(err).ToNot(HaveOccurred())
 	Expect(eps).To(HaveLen(num))
 }
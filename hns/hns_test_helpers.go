@@ -1,34 +1,37 @@
 package hns
 
 import (
-	"github.com/Microsoft/hcsshim"
+	"context"
+	"time"
+
 	. "github.com/onsi/gomega"
 )
 
-func MockHNSNetwork(name, netAdapter, subnetCIDR, defaultGW string) string {
-	subnets := []hcsshim.Subnet{
+func MockHNSNetwork(h HNS, name, netAdapter, subnetCIDR, defaultGW string) string {
+	subnets := []Subnet{
 		{
 			AddressPrefix:  subnetCIDR,
 			GatewayAddress: defaultGW,
 		},
 	}
-	netConfig := &hcsshim.HNSNetwork{
+	netConfig := &Network{
 		Name:               name,
 		Type:               "transparent",
 		NetworkAdapterName: netAdapter,
 		Subnets:            subnets,
 	}
-	var err error
-	netID, err := CreateHNSNetwork(netConfig)
+	netID, err := h.CreateNetwork(context.Background(), netConfig)
+	Expect(err).ToNot(HaveOccurred())
+	_, err = WaitForNetwork(context.Background(), h, netID, 10*time.Second)
 	Expect(err).ToNot(HaveOccurred())
 	return netID
 }
 
-func MockHNSEndpoint(netID string) string {
-	epConfig := &hcsshim.HNSEndpoint{
+func MockHNSEndpoint(h HNS, netID string) string {
+	epConfig := &Endpoint{
 		VirtualNetwork: netID,
 	}
-	epID, err := CreateHNSEndpoint(epConfig)
+	epID, err := h.CreateEndpoint(context.Background(), epConfig)
 	Expect(err).ToNot(HaveOccurred())
 	return epID
 }
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package hns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+var errHNSUnsupported = errors.New("HNS is available only on Windows")
+
+type unsupportedHNS struct{}
+
+// NewHNS returns HNS implementation that fails every call, as there is no Host Networking Service
+// on this platform. Only the fake can be used here.
+func NewHNS() HNS {
+	return &unsupportedHNS{}
+}
+
+func (*unsupportedHNS) CreateNetwork(ctx context.Context, configuration *Network) (string,
+	error) {
+	return "", errHNSUnsupported
+}
+
+func (*unsupportedHNS) DeleteNetwork(ctx context.Context, hnsID string) error {
+	return errHNSUnsupported
+}
+
+func (*unsupportedHNS) ListNetworks(ctx context.Context) ([]Network, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) GetNetwork(ctx context.Context, hnsID string) (*Network, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) GetNetworkByName(ctx context.Context, name string) (*Network, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) CreateEndpoint(ctx context.Context, configuration *Endpoint) (string,
+	error) {
+	return "", errHNSUnsupported
+}
+
+func (*unsupportedHNS) DeleteEndpoint(ctx context.Context, endpointID string) error {
+	return errHNSUnsupported
+}
+
+func (*unsupportedHNS) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) ListEndpointsOfNetwork(ctx context.Context, netID string) ([]Endpoint,
+	error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) GetEndpoint(ctx context.Context, endpointID string) (*Endpoint, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) GetEndpointByName(ctx context.Context, name string) (*Endpoint, error) {
+	return nil, errHNSUnsupported
+}
+
+func (*unsupportedHNS) SetEndpointPolicies(ctx context.Context, endpointID string,
+	policies []json.RawMessage) error {
+	return errHNSUnsupported
+}
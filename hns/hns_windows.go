@@ -0,0 +1,320 @@
+package hns
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Microsoft/hcsshim"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/metrics"
+)
+
+func CreateHNSNetwork(ctx context.Context, configuration *Network) (string, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Creating HNS network")
+	configBytes, err := json.Marshal(configuration)
+	if err != nil {
+		logger.Errorln(err)
+		return "", err
+	}
+	logger.Debugln("Config:", string(configBytes))
+	response, err := networkRequest("POST", "", string(configBytes))
+	if err != nil {
+		logger.Errorln(err)
+		return "", err
+	}
+	return response.Id, nil
+}
+
+func DeleteHNSNetwork(ctx context.Context, hnsID string) error {
+	logger := common.Logger(ctx)
+	logger.Infoln("Deleting HNS network", hnsID)
+	_, err := networkRequest("DELETE", hnsID, "")
+	if err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+func ListHNSNetworks(ctx context.Context) ([]Network, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Listing HNS networks")
+	nets, err := listNetworksRequest("GET", "", "")
+	if err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	return nets, nil
+}
+
+func GetHNSNetwork(ctx context.Context, hnsID string) (*Network, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Getting HNS network", hnsID)
+	net, err := networkRequest("GET", hnsID, "")
+	if err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	return net, nil
+}
+
+func GetHNSNetworkByName(ctx context.Context, name string) (*Network, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Getting HNS network by name:", name)
+	nets, err := listNetworksRequest("GET", "", "")
+	if err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	for _, n := range nets {
+		if n.Name == name {
+			return &n, nil
+		}
+	}
+	return nil, nil
+}
+
+func CreateHNSEndpoint(ctx context.Context, configuration *Endpoint) (string, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Creating HNS endpoint")
+	configBytes, err := json.Marshal(configuration)
+	if err != nil {
+		logger.Errorln(err)
+		return "", err
+	}
+	logger.Debugln("Config: ", string(configBytes))
+	response, err := endpointRequest("POST", "", string(configBytes))
+	if err != nil {
+		return "", err
+	}
+	return response.Id, nil
+}
+
+func DeleteHNSEndpoint(ctx context.Context, endpointID string) error {
+	logger := common.Logger(ctx)
+	logger.Infoln("Deleting HNS endpoint", endpointID)
+	_, err := endpointRequest("DELETE", endpointID, "")
+	if err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+func SetHNSEndpointPolicies(ctx context.Context, endpointID string,
+	policies []json.RawMessage) error {
+	logger := common.Logger(ctx)
+	logger.Infoln("Setting policies of HNS endpoint", endpointID)
+	endpoint, err := endpointRequest("GET", endpointID, "")
+	if err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	endpoint.Policies = policies
+	configBytes, err := json.Marshal(endpoint)
+	if err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	logger.Debugln("Config: ", string(configBytes))
+	_, err = endpointRequest("POST", endpointID, string(configBytes))
+	if err != nil {
+		logger.Errorln(err)
+		return err
+	}
+	return nil
+}
+
+func GetHNSEndpoint(ctx context.Context, endpointID string) (*Endpoint, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Getting HNS endpoint", endpointID)
+	endpoint, err := endpointRequest("GET", endpointID, "")
+	if err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func GetHNSEndpointByName(ctx context.Context, name string) (*Endpoint, error) {
+	logger := common.Logger(ctx)
+	logger.Infoln("Getting HNS endpoint by name:", name)
+	eps, err := listEndpointsRequest("GET", "", "")
+	if err != nil {
+		logger.Errorln(err)
+		return nil, err
+	}
+	for _, ep := range eps {
+		if ep.Name == name {
+			return &ep, nil
+		}
+	}
+	return nil, nil
+}
+
+func ListHNSEndpoints(ctx context.Context) ([]Endpoint, error) {
+	endpoints, err := listEndpointsRequest("GET", "", "")
+	if err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func ListHNSEndpointsOfNetwork(ctx context.Context, netID string) ([]Endpoint, error) {
+	eps, err := ListHNSEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var epsInNetwork []Endpoint
+	for _, ep := range eps {
+		if ep.VirtualNetwork == netID {
+			epsInNetwork = append(epsInNetwork, ep)
+		}
+	}
+	return epsInNetwork, nil
+}
+
+type hcsshimHNS struct{}
+
+// NewHNS returns HNS implementation that talks to the actual Host Networking Service. Latency
+// and errors of its calls are recorded in metrics.
+func NewHNS() HNS {
+	return &hcsshimHNS{}
+}
+
+func observe(operation string, start time.Time, err *error) {
+	metrics.HNSCallDuration.ObserveDuration(start, operation)
+	if *err != nil {
+		metrics.HNSErrors.Inc(operation)
+	}
+}
+
+func (*hcsshimHNS) CreateNetwork(ctx context.Context,
+	configuration *Network) (id string, err error) {
+	defer observe("CreateNetwork", time.Now(), &err)
+	return CreateHNSNetwork(ctx, configuration)
+}
+
+func (*hcsshimHNS) DeleteNetwork(ctx context.Context, hnsID string) (err error) {
+	defer observe("DeleteNetwork", time.Now(), &err)
+	return DeleteHNSNetwork(ctx, hnsID)
+}
+
+func (*hcsshimHNS) ListNetworks(ctx context.Context) (nets []Network, err error) {
+	defer observe("ListNetworks", time.Now(), &err)
+	return ListHNSNetworks(ctx)
+}
+
+func (*hcsshimHNS) GetNetwork(ctx context.Context, hnsID string) (net *Network,
+	err error) {
+	defer observe("GetNetwork", time.Now(), &err)
+	return GetHNSNetwork(ctx, hnsID)
+}
+
+func (*hcsshimHNS) GetNetworkByName(ctx context.Context, name string) (net *Network,
+	err error) {
+	defer observe("GetNetworkByName", time.Now(), &err)
+	return GetHNSNetworkByName(ctx, name)
+}
+
+func (*hcsshimHNS) CreateEndpoint(ctx context.Context,
+	configuration *Endpoint) (id string, err error) {
+	defer observe("CreateEndpoint", time.Now(), &err)
+	return CreateHNSEndpoint(ctx, configuration)
+}
+
+func (*hcsshimHNS) DeleteEndpoint(ctx context.Context, endpointID string) (err error) {
+	defer observe("DeleteEndpoint", time.Now(), &err)
+	return DeleteHNSEndpoint(ctx, endpointID)
+}
+
+func (*hcsshimHNS) ListEndpoints(ctx context.Context) (eps []Endpoint, err error) {
+	defer observe("ListEndpoints", time.Now(), &err)
+	return ListHNSEndpoints(ctx)
+}
+
+func (*hcsshimHNS) ListEndpointsOfNetwork(ctx context.Context,
+	netID string) (eps []Endpoint, err error) {
+	defer observe("ListEndpointsOfNetwork", time.Now(), &err)
+	return ListHNSEndpointsOfNetwork(ctx, netID)
+}
+
+func (*hcsshimHNS) GetEndpoint(ctx context.Context, endpointID string) (ep *Endpoint,
+	err error) {
+	defer observe("GetEndpoint", time.Now(), &err)
+	return GetHNSEndpoint(ctx, endpointID)
+}
+
+func (*hcsshimHNS) GetEndpointByName(ctx context.Context, name string) (ep *Endpoint,
+	err error) {
+	defer observe("GetEndpointByName", time.Now(), &err)
+	return GetHNSEndpointByName(ctx, name)
+}
+
+func (*hcsshimHNS) SetEndpointPolicies(ctx context.Context, endpointID string,
+	policies []json.RawMessage) (err error) {
+	defer observe("SetEndpointPolicies", time.Now(), &err)
+	return SetHNSEndpointPolicies(ctx, endpointID, policies)
+}
+
+// Requests below call HNS through hcsshim and convert results to types of this package.
+
+func networkRequest(method, path, request string) (*Network, error) {
+	net, err := hcsshim.HNSNetworkRequest(method, path, request)
+	if err != nil {
+		return nil, err
+	}
+	var converted Network
+	if err := convert(net, &converted); err != nil {
+		return nil, err
+	}
+	return &converted, nil
+}
+
+func listNetworksRequest(method, path, request string) ([]Network, error) {
+	nets, err := hcsshim.HNSListNetworkRequest(method, path, request)
+	if err != nil {
+		return nil, err
+	}
+	var converted []Network
+	if err := convert(nets, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+func endpointRequest(method, path, request string) (*Endpoint, error) {
+	ep, err := hcsshim.HNSEndpointRequest(method, path, request)
+	if err != nil {
+		return nil, err
+	}
+	var converted Endpoint
+	if err := convert(ep, &converted); err != nil {
+		return nil, err
+	}
+	return &converted, nil
+}
+
+func listEndpointsRequest(method, path, request string) ([]Endpoint, error) {
+	eps, err := hcsshim.HNSListEndpointRequest(method, path, request)
+	if err != nil {
+		return nil, err
+	}
+	var converted []Endpoint
+	if err := convert(eps, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+// convert copies HNS object of hcsshim type to the mirroring type of this package. Both have the
+// same JSON form, as it is what HNS sends and receives.
+func convert(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
@@ -0,0 +1,75 @@
+package hns
+
+import (
+	"encoding/json"
+	"net"
+)
+
+// Types below mirror HNS types of hcsshim, which builds only on Windows, so that packages using
+// HNS can be built and tested with the fake on other platforms too. They are encoded to the same
+// JSON as hcsshim types, which is what HNS receives and returns.
+
+// Subnet is a subnet of HNS network.
+type Subnet struct {
+	AddressPrefix  string            `json:",omitempty"`
+	GatewayAddress string            `json:",omitempty"`
+	Policies       []json.RawMessage `json:",omitempty"`
+}
+
+// MacPool is a range of MAC addresses available to HNS network.
+type MacPool struct {
+	StartMacAddress string `json:",omitempty"`
+	EndMacAddress   string `json:",omitempty"`
+}
+
+// Network is an HNS network.
+type Network struct {
+	Id                   string            `json:"ID,omitempty"`
+	Name                 string            `json:",omitempty"`
+	Type                 string            `json:",omitempty"`
+	NetworkAdapterName   string            `json:",omitempty"`
+	SourceMac            string            `json:",omitempty"`
+	Policies             []json.RawMessage `json:",omitempty"`
+	MacPools             []MacPool         `json:",omitempty"`
+	Subnets              []Subnet          `json:",omitempty"`
+	DNSSuffix            string            `json:",omitempty"`
+	DNSServerList        string            `json:",omitempty"`
+	DNSServerCompartment uint32            `json:",omitempty"`
+	ManagementIP         string            `json:",omitempty"`
+}
+
+// Endpoint is an HNS endpoint.
+type Endpoint struct {
+	Id                 string            `json:"ID,omitempty"`
+	Name               string            `json:",omitempty"`
+	VirtualNetwork     string            `json:",omitempty"`
+	VirtualNetworkName string            `json:",omitempty"`
+	Policies           []json.RawMessage `json:",omitempty"`
+	MacAddress         string            `json:",omitempty"`
+	IPAddress          net.IP            `json:",omitempty"`
+	DNSSuffix          string            `json:",omitempty"`
+	DNSServerList      string            `json:",omitempty"`
+	GatewayAddress     string            `json:",omitempty"`
+	EnableInternalDNS  bool              `json:",omitempty"`
+	DisableICC         bool              `json:",omitempty"`
+	PrefixLength       uint8             `json:",omitempty"`
+	IsRemoteEndpoint   bool              `json:",omitempty"`
+}
+
+// VsidPolicy sets virtual subnet ID of overlay subnet.
+type VsidPolicy struct {
+	Type string
+	VSID uint
+}
+
+// PaPolicy sets provider address of overlay endpoint.
+type PaPolicy struct {
+	Type string
+	PA   string
+}
+
+// VlanPolicy sets VLAN of endpoint.
+type VlanPolicy struct {
+	Type string
+	VLAN uint
+}
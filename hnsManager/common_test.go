@@ -0,0 +1,21 @@
+package hnsManager
+
+import (
+	"context"
+
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// netAdapter is set by -netAdapter flag in the suite that uses actual HNS.
+var netAdapter = "Ethernet0"
+
+var ctx = context.Background()
+
+func transparentNetwork(subnetCIDR, defaultGW string) *NetworkConfig {
+	return &NetworkConfig{
+		Mode:       common.NetworkModeTransparent,
+		Adapter:    netAdapter,
+		SubnetCIDR: subnetCIDR,
+		DefaultGW:  defaultGW,
+	}
+}
@@ -0,0 +1,21 @@
+//go:build fakes
+// +build fakes
+
+package hnsManager
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+// TestHNSManagerWithFakes runs specs that use the in-memory fake of HNS or no HNS at all. They
+// are built with -tags fakes into a suite of their own that doesn't reset HNS of the host.
+func TestHNSManagerWithFakes(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("hns_manager_fakes_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "HNS manager with fakes test suite",
+		[]Reporter{junitReporter})
+}
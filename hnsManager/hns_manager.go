@@ -1,65 +1,107 @@
 package hnsManager
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"strings"
+	"sync"
+	"time"
 
-	"github.com/Microsoft/hcsshim"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/hns"
 )
 
 // HNSManager manages HNS networks that are used by the driver.
+// It keeps an index of Contrail HNS networks and their endpoints, so that lookups don't
+// have to list all of HNS. Index entries are verified with a single GET request on every hit.
+// When a lookup misses, the index is refreshed from HNS.
 type HNSManager struct {
-	// TODO JW-154: store networks here that you know about. If not found here, look in HNS.
-	// for now, just look in HNS by name.
-}
+	hns hns.HNS
+	// mutex guards only the index, HNS is called without it, so that work on different networks
+	// and endpoints doesn't wait for each other. The driver serializes work on the same network
+	// or endpoint. Entries of the index may be stale, e.g. when a refresh races with a create,
+	// which is why they're verified on every hit.
+	mutex sync.Mutex
 
-func contrailHNSNetName(tenant, netName string) string {
-	return fmt.Sprintf("%s:%s:%s", common.HNSNetworkPrefix, tenant, netName)
-}
+	// NetworkReadyTimeout limits how long CreateNetwork waits for the new network to get its
+	// network adapter.
+	NetworkReadyTimeout time.Duration
+	// DefaultDomain is the Contrail domain of networks named in the older format. It must be set
+	// before the manager is used.
+	DefaultDomain string
 
-func (m *HNSManager) CreateNetwork(netAdapter, tenantName, networkName, subnetCIDR,
-	defaultGW string) (*hcsshim.HNSNetwork, error) {
+	// networks are keyed by Contrail network decoded from HNS network name, so that networks
+	// named in the older format are found too.
+	networks     map[NetworkName]*hns.Network
+	networksByID map[string]*hns.Network
 
-	hnsNetName := contrailHNSNetName(tenantName, networkName)
+	endpoints     map[string]*hns.Endpoint
+	endpointsByID map[string]*hns.Endpoint
+}
 
-	net, err := hns.GetHNSNetworkByName(hnsNetName)
-	if net != nil {
-		return nil, errors.New("Such HNS network already exists")
-	}
+const defaultNetworkReadyTimeout = 10 * time.Second
 
-	subnets := []hcsshim.Subnet{
-		{
-			AddressPrefix:  subnetCIDR,
-			GatewayAddress: defaultGW,
-		},
+func NewHNSManager(h hns.HNS) *HNSManager {
+	m := &HNSManager{
+		hns:                 h,
+		NetworkReadyTimeout: defaultNetworkReadyTimeout,
+		DefaultDomain:       common.DomainName,
 	}
+	m.resetNetworks()
+	m.resetEndpoints()
+	return m
+}
 
-	configuration := &hcsshim.HNSNetwork{
-		Name:               hnsNetName,
-		Type:               "transparent",
-		NetworkAdapterName: netAdapter,
-		Subnets:            subnets,
+// Refresh rebuilds the index of Contrail networks and endpoints from HNS. It's meant to be called
+// on startup.
+func (m *HNSManager) Refresh(ctx context.Context) error {
+	nets, err := m.hns.ListNetworks(ctx)
+	if err != nil {
+		return err
+	}
+	eps, err := m.hns.ListEndpoints(ctx)
+	if err != nil {
+		return err
 	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.indexNetworks(nets)
+	m.indexEndpoints(eps)
+	return nil
+}
 
-	hnsNetworkID, err := hns.CreateHNSNetwork(configuration)
+// CreateNetwork creates HNS network of the Contrail network in the mode given in config.
+func (m *HNSManager) CreateNetwork(ctx context.Context, name NetworkName,
+	config *NetworkConfig) (*hns.Network, error) {
+	net, err := m.lookupNetwork(ctx, name)
 	if err != nil {
 		return nil, err
 	}
+	if net != nil {
+		return nil, errors.New("Such HNS network already exists")
+	}
 
-	hnsNetwork, err := hns.GetHNSNetwork(hnsNetworkID)
+	configuration, err := newHNSNetwork(name, config)
+	if err != nil {
+		return nil, err
+	}
+	hnsNetworkID, err := m.hns.CreateNetwork(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+	hnsNetwork, err := hns.WaitForNetwork(ctx, m.hns, hnsNetworkID, m.NetworkReadyTimeout)
 	if err != nil {
 		return nil, err
 	}
 
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addNetwork(hnsNetwork)
 	return hnsNetwork, nil
 }
 
-func (m *HNSManager) GetNetwork(tenantName, networkName string) (*hcsshim.HNSNetwork, error) {
-	hnsNetName := contrailHNSNetName(tenantName, networkName)
-	hnsNetwork, err := hns.GetHNSNetworkByName(hnsNetName)
+func (m *HNSManager) GetNetwork(ctx context.Context, name NetworkName) (*hns.Network,
+	error) {
+	hnsNetwork, err := m.lookupNetwork(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -69,12 +111,19 @@ func (m *HNSManager) GetNetwork(tenantName, networkName string) (*hcsshim.HNSNet
 	return hnsNetwork, nil
 }
 
-func (m *HNSManager) DeleteNetwork(tenantName, networkName string) error {
-	hnsNetwork, err := m.GetNetwork(tenantName, networkName)
+// DeleteNetwork deletes HNS network of the Contrail network, if it has no endpoints. HNS refuses
+// to delete a network that has endpoints as well, so one created after the check isn't lost.
+func (m *HNSManager) DeleteNetwork(ctx context.Context, name NetworkName) error {
+	hnsNetwork, err := m.lookupNetwork(ctx, name)
 	if err != nil {
 		return err
 	}
-	endpoints, err := hns.ListHNSEndpoints()
+	if hnsNetwork == nil {
+		return errors.New("Such HNS network does not exist")
+	}
+
+	// Endpoints could have been created without our knowledge, so ask HNS directly.
+	endpoints, err := m.hns.ListEndpoints(ctx)
 	if err != nil {
 		return err
 	}
@@ -84,22 +133,274 @@ func (m *HNSManager) DeleteNetwork(tenantName, networkName string) error {
 			return errors.New("Cannot delete network with active endpoints")
 		}
 	}
-	return hns.DeleteHNSNetwork(hnsNetwork.Id)
+	if err := m.hns.DeleteNetwork(ctx, hnsNetwork.Id); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.removeNetwork(hnsNetwork)
+	return nil
 }
 
-func (m *HNSManager) ListNetworks() ([]hcsshim.HNSNetwork, error) {
-	var validNets []hcsshim.HNSNetwork
-	nets, err := hns.ListHNSNetworks()
+// ListNetworks returns all Contrail HNS networks. It always queries HNS, as its result is used to
+// find out which networks are no longer used by docker.
+func (m *HNSManager) ListNetworks(ctx context.Context) ([]hns.Network, error) {
+	nets, err := m.hns.ListNetworks(ctx)
 	if err != nil {
-		return validNets, err
+		return nil, err
 	}
-	for _, net := range nets {
-		splitName := strings.Split(net.Name, ":")
-		if len(splitName) == 3 {
-			if splitName[0] == common.HNSNetworkPrefix {
-				validNets = append(validNets, net)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.indexNetworks(nets)
+	var validNets []hns.Network
+	for _, net := range m.networks {
+		validNets = append(validNets, *net)
+	}
+	return validNets, nil
+}
+
+// ListEndpoints returns all endpoints of Contrail HNS networks. Like ListNetworks, it always
+// queries HNS.
+func (m *HNSManager) ListEndpoints(ctx context.Context) ([]hns.Endpoint, error) {
+	if err := m.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var eps []hns.Endpoint
+	for _, ep := range m.endpoints {
+		eps = append(eps, *ep)
+	}
+	return eps, nil
+}
+
+// Removed lists HNS networks and endpoints removed by Cleanup.
+type Removed struct {
+	Networks  []hns.Network
+	Endpoints []hns.Endpoint
+}
+
+// Cleanup removes all Contrail HNS networks with their endpoints. Unlike common.HardResetHNS, it
+// leaves networks of other owners, like NAT, intact. If rootNetworkName isn't empty, the root
+// network of that name is removed as well. With dryRun nothing is removed. Returns what was (or
+// would be) removed, which is also what was removed before an error.
+func (m *HNSManager) Cleanup(ctx context.Context, rootNetworkName string,
+	dryRun bool) (*Removed, error) {
+	logger := common.Logger(ctx)
+
+	allNets, err := m.hns.ListNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nets := make(map[string]hns.Network)
+	for _, net := range allNets {
+		isRoot := rootNetworkName != "" && net.Name == rootNetworkName
+		_, isContrail := DecodeHNSNetworkName(net.Name, m.DefaultDomain)
+		if isContrail || isRoot {
+			nets[net.Id] = net
+		}
+	}
+	allEps, err := m.hns.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := &Removed{}
+	for _, ep := range allEps {
+		if _, ok := nets[ep.VirtualNetwork]; !ok {
+			continue
+		}
+		if !dryRun {
+			logger.Infoln("Removing HNS endpoint", ep.Name, ep.Id)
+			if err := m.DeleteEndpoint(ctx, &ep); err != nil {
+				return removed, err
 			}
 		}
+		removed.Endpoints = append(removed.Endpoints, ep)
 	}
-	return validNets, nil
+	for _, net := range allNets {
+		if _, ok := nets[net.Id]; !ok {
+			continue
+		}
+		if !dryRun {
+			logger.Infoln("Removing HNS network", net.Name, net.Id)
+			if err := m.hns.DeleteNetwork(ctx, net.Id); err != nil {
+				return removed, err
+			}
+			m.mutex.Lock()
+			m.removeNetwork(&net)
+			m.mutex.Unlock()
+		}
+		removed.Networks = append(removed.Networks, net)
+	}
+	return removed, nil
+}
+
+func (m *HNSManager) CreateEndpoint(ctx context.Context,
+	configuration *hns.Endpoint) (*hns.Endpoint, error) {
+	endpointID, err := m.hns.CreateEndpoint(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := m.hns.GetEndpoint(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addEndpoint(endpoint)
+	return endpoint, nil
+}
+
+// GetEndpointByName returns HNS endpoint with given name or nil, if it doesn't exist.
+func (m *HNSManager) GetEndpointByName(ctx context.Context, name string) (*hns.Endpoint,
+	error) {
+	return m.lookupEndpoint(ctx, name)
+}
+
+func (m *HNSManager) DeleteEndpoint(ctx context.Context, endpoint *hns.Endpoint) error {
+	if err := m.hns.DeleteEndpoint(ctx, endpoint.Id); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.removeEndpoint(endpoint)
+	return nil
+}
+
+// IndexedCounts returns number of Contrail networks and endpoints in the index, without asking
+// HNS.
+func (m *HNSManager) IndexedCounts() (int, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.networks), len(m.endpoints)
+}
+
+// lookupNetwork finds HNS network of the Contrail network, first in the index and then in HNS.
+// Returns nil if it doesn't exist.
+func (m *HNSManager) lookupNetwork(ctx context.Context, name NetworkName) (*hns.Network,
+	error) {
+	m.mutex.Lock()
+	cached, exists := m.networks[name]
+	m.mutex.Unlock()
+	if exists {
+		net, err := m.hns.GetNetwork(ctx, cached.Id)
+		if err == nil && net != nil && net.Name == cached.Name {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.addNetwork(net)
+			return net, nil
+		}
+		common.Logger(ctx).Debugln("Cached HNS network", cached.Name, "is stale")
+	}
+
+	nets, err := m.hns.ListNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.indexNetworks(nets)
+	return m.networks[name], nil
+}
+
+// lookupEndpoint finds HNS endpoint by name, first in the index and then in HNS. Returns nil if
+// it doesn't exist.
+func (m *HNSManager) lookupEndpoint(ctx context.Context, name string) (*hns.Endpoint,
+	error) {
+	m.mutex.Lock()
+	cached, exists := m.endpoints[name]
+	m.mutex.Unlock()
+	if exists {
+		ep, err := m.hns.GetEndpoint(ctx, cached.Id)
+		if err == nil && ep != nil && ep.Name == name {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.addEndpoint(ep)
+			return ep, nil
+		}
+		common.Logger(ctx).Debugln("Cached HNS endpoint", name, "is stale")
+	}
+
+	if err := m.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.endpoints[name], nil
+}
+
+// Methods below update the index, so they must be called with mutex held.
+
+// indexNetworks replaces Contrail networks in the index with the ones listed by HNS.
+func (m *HNSManager) indexNetworks(nets []hns.Network) {
+	m.resetNetworks()
+	for i := range nets {
+		m.addNetwork(&nets[i])
+	}
+}
+
+// indexEndpoints replaces endpoints in the index with the ones listed by HNS that belong to known
+// Contrail networks, so it should be called after indexNetworks.
+func (m *HNSManager) indexEndpoints(eps []hns.Endpoint) {
+	m.resetEndpoints()
+	for i := range eps {
+		if _, isContrail := m.networksByID[eps[i].VirtualNetwork]; isContrail {
+			m.addEndpoint(&eps[i])
+		}
+	}
+}
+
+func (m *HNSManager) resetNetworks() {
+	m.networks = make(map[NetworkName]*hns.Network)
+	m.networksByID = make(map[string]*hns.Network)
+}
+
+func (m *HNSManager) resetEndpoints() {
+	m.endpoints = make(map[string]*hns.Endpoint)
+	m.endpointsByID = make(map[string]*hns.Endpoint)
+}
+
+// addNetwork indexes the network if it's a Contrail network. If there are networks of both name
+// formats for the same Contrail network, the one named in the current format is used.
+func (m *HNSManager) addNetwork(net *hns.Network) {
+	name, isContrail := DecodeHNSNetworkName(net.Name, m.DefaultDomain)
+	if !isContrail {
+		return
+	}
+	if indexed, exists := m.networks[name]; exists && indexed.Id != net.Id &&
+		isLegacyHNSNetworkName(net.Name) && !isLegacyHNSNetworkName(indexed.Name) {
+		return
+	}
+	m.networks[name] = net
+	m.networksByID[net.Id] = net
+}
+
+func (m *HNSManager) removeNetwork(net *hns.Network) {
+	if name, isContrail := DecodeHNSNetworkName(net.Name, m.DefaultDomain); isContrail {
+		if indexed, exists := m.networks[name]; exists && indexed.Id == net.Id {
+			delete(m.networks, name)
+		}
+	}
+	delete(m.networksByID, net.Id)
+	for _, ep := range m.endpointsByID {
+		if ep.VirtualNetwork == net.Id {
+			m.removeEndpoint(ep)
+		}
+	}
+}
+
+func (m *HNSManager) addEndpoint(ep *hns.Endpoint) {
+	m.endpoints[ep.Name] = ep
+	m.endpointsByID[ep.Id] = ep
+}
+
+func (m *HNSManager) removeEndpoint(ep *hns.Endpoint) {
+	delete(m.endpoints, ep.Name)
+	delete(m.endpointsByID, ep.Id)
 }
@@ -0,0 +1,412 @@
+//go:build fakes
+// +build fakes
+
+package hnsManager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/hns"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blockingHNS holds endpoint creation until release is closed.
+type blockingHNS struct {
+	*hns.FakeHNS
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHNS) CreateEndpoint(ctx context.Context,
+	configuration *hns.Endpoint) (string, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return h.FakeHNS.CreateEndpoint(ctx, configuration)
+}
+
+var _ = Describe("HNS network names", func() {
+
+	It("are decoded back to Contrail names containing separators", func() {
+		for _, name := range []NetworkName{
+			{Domain: common.DomainName, Tenant: "admin", Network: "net"},
+			{Domain: "dom:1", Tenant: "ten:ant", Network: "net%3A:"},
+			{Domain: "", Tenant: "100%", Network: "a:b:c"},
+		} {
+			encoded := EncodeHNSNetworkName(name)
+			Expect(encoded).To(HavePrefix("Contrail:v1:"))
+			decoded, ok := DecodeHNSNetworkName(encoded, common.DomainName)
+			Expect(ok).To(BeTrue(), encoded)
+			Expect(decoded).To(Equal(name))
+		}
+	})
+
+	It("escape only separators and escape character", func() {
+		name := NetworkName{Domain: "default-domain", Tenant: "admin", Network: "net:1 (test)"}
+		Expect(EncodeHNSNetworkName(name)).To(
+			Equal("Contrail:v1:default-domain:admin:net%3A1 (test)"))
+	})
+
+	It("of the older format are decoded with the given default domain", func() {
+		name, ok := DecodeHNSNetworkName("Contrail:admin:net", "k8s")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal(NetworkName{
+			Domain:  "k8s",
+			Tenant:  "admin",
+			Network: "net",
+		}))
+	})
+
+	It("aren't decoded if they aren't Contrail names", func() {
+		for _, hnsName := range []string{
+			"nat",
+			"Contrail",
+			"Contrail:admin",
+			"Other:admin:net",
+			"Contrail:v2:default-domain:admin:net",
+			"Contrail:v1:default-domain:admin:net%41",
+		} {
+			_, ok := DecodeHNSNetworkName(hnsName, common.DomainName)
+			Expect(ok).To(BeFalse(), hnsName)
+		}
+	})
+})
+
+var _ = Describe("Networks named in the older format", func() {
+
+	var name = NetworkName{Domain: common.DomainName, Tenant: "agatka", Network: "test_net"}
+
+	var fakeHNS *hns.FakeHNS
+	var hnsMgr *HNSManager
+	var legacyNetID string
+
+	BeforeEach(func() {
+		fakeHNS = hns.NewFakeHNS()
+		hnsMgr = NewHNSManager(fakeHNS)
+		legacyNetID = hns.MockHNSNetwork(fakeHNS, "Contrail:agatka:test_net", netAdapter,
+			"10.0.0.0/24", "10.0.0.1")
+	})
+
+	Specify("are found like the ones in the current format", func() {
+		net, err := hnsMgr.GetNetwork(ctx, name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Id).To(Equal(legacyNetID))
+	})
+
+	Specify("aren't created again in the current format", func() {
+		_, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork("10.0.0.0/24", "10.0.0.1"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("can be deleted", func() {
+		Expect(hnsMgr.DeleteNetwork(ctx, name)).To(Succeed())
+		nets, err := fakeHNS.ListNetworks(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nets).To(BeEmpty())
+	})
+
+	Specify("are found in the configured default domain", func() {
+		hnsMgr.DefaultDomain = "k8s"
+		net, err := hnsMgr.GetNetwork(ctx, NetworkName{
+			Domain:  "k8s",
+			Tenant:  name.Tenant,
+			Network: name.Network,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Id).To(Equal(legacyNetID))
+
+		_, err = hnsMgr.GetNetwork(ctx, name)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("are superseded by networks in the current format", func() {
+		currentNetID := hns.MockHNSNetwork(fakeHNS, EncodeHNSNetworkName(name), netAdapter,
+			"10.0.0.0/24", "10.0.0.1")
+
+		net, err := hnsMgr.GetNetwork(ctx, name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.Id).To(Equal(currentNetID))
+	})
+})
+
+var _ = Describe("Cleaning up Contrail networks", func() {
+
+	const (
+		subnetCIDR = "10.0.0.0/24"
+		defaultGW  = "10.0.0.1"
+	)
+
+	var fakeHNS *hns.FakeHNS
+	var hnsMgr *HNSManager
+	var contrailNetID, rootNetID, natNetID string
+
+	BeforeEach(func() {
+		fakeHNS = hns.NewFakeHNS()
+		hnsMgr = NewHNSManager(fakeHNS)
+		contrailNetID = hns.MockHNSNetwork(fakeHNS, "Contrail:agatka:test_net", netAdapter,
+			subnetCIDR, defaultGW)
+		hns.MockHNSEndpoint(fakeHNS, contrailNetID)
+		rootNetID = hns.MockHNSNetwork(fakeHNS, common.RootNetworkName, netAdapter, "", "")
+		natNetID = hns.MockHNSNetwork(fakeHNS, "nat", netAdapter, "172.16.0.0/24", "172.16.0.1")
+		hns.MockHNSEndpoint(fakeHNS, natNetID)
+		Expect(hnsMgr.Refresh(ctx)).To(Succeed())
+	})
+
+	networkIDs := func() []string {
+		nets, err := fakeHNS.ListNetworks(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		var ids []string
+		for _, net := range nets {
+			ids = append(ids, net.Id)
+		}
+		return ids
+	}
+
+	Specify("Contrail networks and their endpoints are removed", func() {
+		removed, err := hnsMgr.Cleanup(ctx, "", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed.Networks).To(HaveLen(1))
+		Expect(removed.Networks[0].Id).To(Equal(contrailNetID))
+		Expect(removed.Endpoints).To(HaveLen(1))
+
+		Expect(networkIDs()).To(ConsistOf(rootNetID, natNetID))
+		eps, err := fakeHNS.ListEndpoints(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eps).To(HaveLen(1))
+		Expect(eps[0].VirtualNetwork).To(Equal(natNetID))
+		netCount, epCount := hnsMgr.IndexedCounts()
+		Expect(netCount).To(Equal(0))
+		Expect(epCount).To(Equal(0))
+	})
+
+	Specify("root network is removed when requested", func() {
+		removed, err := hnsMgr.Cleanup(ctx, common.RootNetworkName, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed.Networks).To(HaveLen(2))
+		Expect(networkIDs()).To(ConsistOf(natNetID))
+	})
+
+	Specify("nothing is removed in dry run", func() {
+		removed, err := hnsMgr.Cleanup(ctx, common.RootNetworkName, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed.Networks).To(HaveLen(2))
+		Expect(removed.Endpoints).To(HaveLen(1))
+		Expect(networkIDs()).To(HaveLen(3))
+		eps, err := fakeHNS.ListEndpoints(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eps).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Creating network in slow HNS", func() {
+
+	const delay = 200 * time.Millisecond
+
+	var name = NetworkName{Domain: common.DomainName, Tenant: "agatka", Network: "test_net"}
+
+	var fakeHNS *hns.FakeHNS
+	var hnsMgr *HNSManager
+
+	BeforeEach(func() {
+		fakeHNS = hns.NewFakeHNS()
+		fakeHNS.SetNetworkReadyDelay(delay)
+		hnsMgr = NewHNSManager(fakeHNS)
+	})
+
+	Specify("returns network once it has its adapter", func() {
+		net, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork("10.0.0.0/24", "10.0.0.1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(net.NetworkAdapterName).To(Equal(netAdapter))
+	})
+
+	Specify("fails if network isn't ready in time", func() {
+		hnsMgr.NetworkReadyTimeout = delay / 4
+		_, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork("10.0.0.0/24", "10.0.0.1"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("doesn't block the index while waiting for the network", func() {
+		created := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			_, err := hnsMgr.CreateNetwork(ctx, name,
+				transparentNetwork("10.0.0.0/24", "10.0.0.1"))
+			created <- err
+		}()
+		Eventually(func() int {
+			nets, _ := fakeHNS.ListNetworks(ctx)
+			return len(nets)
+		}).Should(Equal(1))
+
+		start := time.Now()
+		nets, _ := hnsMgr.IndexedCounts()
+		Expect(time.Since(start)).To(BeNumerically("<", delay/2))
+		Expect(nets).To(Equal(0))
+		Eventually(created).Should(Receive(BeNil()))
+	})
+})
+
+var _ = Describe("HNS manager with HNS call in progress", func() {
+
+	var blocking *blockingHNS
+	var hnsMgr *HNSManager
+	var netID string
+
+	BeforeEach(func() {
+		blocking = &blockingHNS{
+			FakeHNS: hns.NewFakeHNS(),
+			started: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+		hnsMgr = NewHNSManager(blocking)
+		netID = hns.MockHNSNetwork(blocking.FakeHNS, "Contrail:agatka:test_net", netAdapter,
+			"10.0.0.0/24", "10.0.0.1")
+		_, err := blocking.FakeHNS.CreateEndpoint(ctx, &hns.Endpoint{
+			VirtualNetwork: netID,
+			Name:           "existing",
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Specify("looks up other endpoints meanwhile", func() {
+		created := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			_, err := hnsMgr.CreateEndpoint(ctx, &hns.Endpoint{
+				VirtualNetwork: netID,
+				Name:           "new",
+			})
+			created <- err
+		}()
+		Eventually(blocking.started).Should(Receive())
+
+		found := make(chan *hns.Endpoint, 1)
+		go func() {
+			defer GinkgoRecover()
+			ep, err := hnsMgr.GetEndpointByName(ctx, "existing")
+			Expect(err).ToNot(HaveOccurred())
+			found <- ep
+		}()
+		Eventually(found).Should(Receive(Not(BeNil())))
+
+		close(blocking.release)
+		Eventually(created).Should(Receive(BeNil()))
+	})
+})
+
+var _ = Describe("HNS network modes", func() {
+
+	var name = NetworkName{Domain: common.DomainName, Tenant: "agatka", Network: "test_net"}
+	var config *NetworkConfig
+
+	BeforeEach(func() {
+		config = &NetworkConfig{
+			Adapter:      "Ethernet0",
+			SubnetCIDR:   "10.0.0.0/24",
+			DefaultGW:    "10.0.0.1",
+			VSID:         5001,
+			ManagementIP: "10.7.0.2",
+			SourceMac:    "00-15-5D-10-00-01",
+			MacPools: []hns.MacPool{
+				{StartMacAddress: "00-15-5D-10-00-00", EndMacAddress: "00-15-5D-10-FF-FF"},
+			},
+		}
+	})
+
+	networkJSON := func(mode string) string {
+		config.Mode = mode
+		network, err := newHNSNetwork(name, config)
+		Expect(err).ToNot(HaveOccurred())
+		bytes, err := json.Marshal(network)
+		Expect(err).ToNot(HaveOccurred())
+		return string(bytes)
+	}
+
+	endpointPoliciesJSON := func(mode string) string {
+		config.Mode = mode
+		network, err := newHNSNetwork(name, config)
+		Expect(err).ToNot(HaveOccurred())
+		policies, err := EndpointPolicies(network)
+		Expect(err).ToNot(HaveOccurred())
+		bytes, err := json.Marshal(policies)
+		Expect(err).ToNot(HaveOccurred())
+		return string(bytes)
+	}
+
+	hnsName := EncodeHNSNetworkName(name)
+
+	Specify("transparent network has only adapter and subnet", func() {
+		Expect(networkJSON(common.NetworkModeTransparent)).To(MatchJSON(fmt.Sprintf(`{
+			"Name": %q,
+			"Type": "transparent",
+			"NetworkAdapterName": "Ethernet0",
+			"Subnets": [{"AddressPrefix": "10.0.0.0/24", "GatewayAddress": "10.0.0.1"}]
+		}`, hnsName)))
+		Expect(endpointPoliciesJSON(common.NetworkModeTransparent)).To(MatchJSON(`null`))
+	})
+
+	for _, mode := range []string{common.NetworkModeL2Bridge, common.NetworkModeL2Tunnel} {
+		mode := mode
+		Specify(mode+" network has management IP, source MAC and MAC pools", func() {
+			Expect(networkJSON(mode)).To(MatchJSON(fmt.Sprintf(`{
+				"Name": %q,
+				"Type": %q,
+				"NetworkAdapterName": "Ethernet0",
+				"SourceMac": "00-15-5D-10-00-01",
+				"MacPools": [{
+					"StartMacAddress": "00-15-5D-10-00-00",
+					"EndMacAddress": "00-15-5D-10-FF-FF"
+				}],
+				"Subnets": [{"AddressPrefix": "10.0.0.0/24", "GatewayAddress": "10.0.0.1"}],
+				"ManagementIP": "10.7.0.2"
+			}`, hnsName, mode)))
+			Expect(endpointPoliciesJSON(mode)).To(MatchJSON(`null`))
+		})
+	}
+
+	Specify("overlay network has VSID policy of its subnet", func() {
+		Expect(networkJSON(common.NetworkModeOverlay)).To(MatchJSON(fmt.Sprintf(`{
+			"Name": %q,
+			"Type": "overlay",
+			"NetworkAdapterName": "Ethernet0",
+			"SourceMac": "00-15-5D-10-00-01",
+			"MacPools": [{
+				"StartMacAddress": "00-15-5D-10-00-00",
+				"EndMacAddress": "00-15-5D-10-FF-FF"
+			}],
+			"Subnets": [{
+				"AddressPrefix": "10.0.0.0/24",
+				"GatewayAddress": "10.0.0.1",
+				"Policies": [{"Type": "VSID", "VSID": 5001}]
+			}],
+			"ManagementIP": "10.7.0.2"
+		}`, hnsName)))
+	})
+
+	Specify("overlay endpoints have provider address policy", func() {
+		Expect(endpointPoliciesJSON(common.NetworkModeOverlay)).To(MatchJSON(
+			`[{"Type": "PA", "PA": "10.7.0.2"}]`))
+	})
+
+	Specify("overlay network requires VSID and management IP", func() {
+		config.Mode = common.NetworkModeOverlay
+		config.VSID = 0
+		_, err := newHNSNetwork(name, config)
+		Expect(err).To(HaveOccurred())
+
+		config.VSID = 5001
+		config.ManagementIP = ""
+		_, err = newHNSNetwork(name, config)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Specify("unknown modes are rejected", func() {
+		config.Mode = "ics"
+		_, err := newHNSNetwork(name, config)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,3 +1,6 @@
+//go:build windows && !fakes
+// +build windows,!fakes
+
 package hnsManager
 
 import (
@@ -5,18 +8,20 @@ import (
 	"fmt"
 	"testing"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/hns"
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/reporters"
 	. "github.com/onsi/gomega"
-	log "github.com/sirupsen/logrus"
 )
 
-var netAdapter string
+var useActualHNS bool
 
 func init() {
 	flag.StringVar(&netAdapter, "netAdapter", "Ethernet0", "Ethernet adapter name to use")
+	flag.BoolVar(&useActualHNS, "useActualHNS", true,
+		"Whether to use in-memory fake of HNS or actual.")
 	log.SetLevel(log.DebugLevel)
 }
 
@@ -27,8 +32,10 @@ func TestHNSManager(t *testing.T) {
 }
 
 var _ = BeforeSuite(func() {
-	err := common.HardResetHNS()
-	Expect(err).ToNot(HaveOccurred())
+	if useActualHNS {
+		err := common.HardResetHNS()
+		Expect(err).ToNot(HaveOccurred())
+	}
 })
 
 var _ = Describe("HNS manager", func() {
@@ -40,68 +47,165 @@ var _ = Describe("HNS manager", func() {
 		defaultGW   = "10.0.0.1"
 	)
 
+	var name = NetworkName{Domain: common.DomainName, Tenant: tenantName, Network: networkName}
+
+	var hnsAPI hns.HNS
 	var hnsMgr *HNSManager
 
 	BeforeEach(func() {
-		hnsMgr = &HNSManager{}
+		if useActualHNS {
+			hnsAPI = hns.NewHNS()
+		} else {
+			hnsAPI = hns.NewFakeHNS()
+		}
+		hnsMgr = NewHNSManager(hnsAPI)
 	})
 
 	AfterEach(func() {
-		err := common.HardResetHNS()
-		Expect(err).ToNot(HaveOccurred())
+		if useActualHNS {
+			err := common.HardResetHNS()
+			Expect(err).ToNot(HaveOccurred())
+		}
 	})
 
 	Context("specified network does not exist", func() {
 		Specify("creating a new HNS network works", func() {
-			_, err := hnsMgr.CreateNetwork(netAdapter, tenantName, networkName,
-				subnetCIDR, defaultGW)
+			_, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork(subnetCIDR, defaultGW))
 			Expect(err).ToNot(HaveOccurred())
 		})
 		Specify("getting the HNS network returns error", func() {
-			net, err := hnsMgr.GetNetwork(tenantName, networkName)
+			net, err := hnsMgr.GetNetwork(ctx, name)
 			Expect(err).To(HaveOccurred())
 			Expect(net).To(BeNil())
 		})
 	})
 
+	Specify("networks of the same name in different domains are separate", func() {
+		_, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork(subnetCIDR, defaultGW))
+		Expect(err).ToNot(HaveOccurred())
+		otherName := name
+		otherName.Domain = "other-domain"
+		_, err = hnsMgr.GetNetwork(ctx, otherName)
+		Expect(err).To(HaveOccurred())
+
+		otherNet, err := hnsMgr.CreateNetwork(ctx, otherName,
+			transparentNetwork(subnetCIDR, defaultGW))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(otherNet.Name).To(Equal(EncodeHNSNetworkName(otherName)))
+
+		Expect(hnsMgr.DeleteNetwork(ctx, otherName)).To(Succeed())
+		_, err = hnsMgr.GetNetwork(ctx, name)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
 	Context("specified network already exists", func() {
 		var existingNetID string
 		BeforeEach(func() {
 			hnsNetName := fmt.Sprintf("Contrail:%s:%s", tenantName, networkName)
-			existingNetID = hns.MockHNSNetwork(hnsNetName, netAdapter, subnetCIDR, defaultGW)
+			existingNetID = hns.MockHNSNetwork(hnsAPI, hnsNetName, netAdapter, subnetCIDR,
+				defaultGW)
 		})
 
 		Specify("creating a new network with same params returns error", func() {
-			net, err := hnsMgr.CreateNetwork(netAdapter, tenantName, networkName,
-				subnetCIDR, defaultGW)
+			net, err := hnsMgr.CreateNetwork(ctx, name, transparentNetwork(subnetCIDR, defaultGW))
 			Expect(err).To(HaveOccurred())
 			Expect(net).To(BeNil())
 		})
 
 		Specify("getting the network returns it", func() {
-			net, err := hnsMgr.GetNetwork(tenantName, networkName)
+			net, err := hnsMgr.GetNetwork(ctx, name)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net.Id).To(Equal(existingNetID))
 		})
 
+		Specify("getting the network after it was removed outside of manager returns error",
+			func() {
+				_, err := hnsMgr.GetNetwork(ctx, name)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = hnsAPI.DeleteNetwork(ctx, existingNetID)
+				Expect(err).ToNot(HaveOccurred())
+
+				net, err := hnsMgr.GetNetwork(ctx, name)
+				Expect(err).To(HaveOccurred())
+				Expect(net).To(BeNil())
+			})
+
+		Context("endpoints", func() {
+			const endpointName = "test_endpoint"
+
+			var epConfig *hns.Endpoint
+
+			BeforeEach(func() {
+				epConfig = &hns.Endpoint{
+					VirtualNetwork: existingNetID,
+					Name:           endpointName,
+				}
+			})
+
+			Specify("created endpoint can be found by name", func() {
+				createdEp, err := hnsMgr.CreateEndpoint(ctx, epConfig)
+				Expect(err).ToNot(HaveOccurred())
+
+				ep, err := hnsMgr.GetEndpointByName(ctx, endpointName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep).ToNot(BeNil())
+				Expect(ep.Id).To(Equal(createdEp.Id))
+			})
+
+			Specify("endpoint created outside of manager can be found by name", func() {
+				epID, err := hnsAPI.CreateEndpoint(ctx, epConfig)
+				Expect(err).ToNot(HaveOccurred())
+
+				ep, err := hnsMgr.GetEndpointByName(ctx, endpointName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep).ToNot(BeNil())
+				Expect(ep.Id).To(Equal(epID))
+			})
+
+			Specify("endpoint removed outside of manager can't be found", func() {
+				createdEp, err := hnsMgr.CreateEndpoint(ctx, epConfig)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = hnsAPI.DeleteEndpoint(ctx, createdEp.Id)
+				Expect(err).ToNot(HaveOccurred())
+
+				ep, err := hnsMgr.GetEndpointByName(ctx, endpointName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep).To(BeNil())
+			})
+
+			Specify("deleted endpoint can't be found", func() {
+				createdEp, err := hnsMgr.CreateEndpoint(ctx, epConfig)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = hnsMgr.DeleteEndpoint(ctx, createdEp)
+				Expect(err).ToNot(HaveOccurred())
+
+				ep, err := hnsMgr.GetEndpointByName(ctx, endpointName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep).To(BeNil())
+			})
+		})
+
 		Context("network has active endpoints", func() {
 			BeforeEach(func() {
-				eps, err := hns.ListHNSEndpoints()
+				eps, err := hnsAPI.ListEndpoints(ctx)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(eps).To(BeEmpty())
 
-				_ = hns.MockHNSEndpoint(existingNetID)
+				_ = hns.MockHNSEndpoint(hnsAPI, existingNetID)
 
-				eps, err = hns.ListHNSEndpoints()
+				eps, err = hnsAPI.ListEndpoints(ctx)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(eps).ToNot(BeEmpty())
 			})
 
 			Specify("deleting the network returns error", func() {
-				err := hnsMgr.DeleteNetwork(tenantName, networkName)
+				err := hnsMgr.DeleteNetwork(ctx, name)
 				Expect(err).To(HaveOccurred())
 
-				eps, err := hns.ListHNSEndpoints()
+				eps, err := hnsAPI.ListEndpoints(ctx)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(eps).ToNot(BeEmpty())
 			})
@@ -109,11 +213,11 @@ var _ = Describe("HNS manager", func() {
 
 		Context("network has no active endpoints", func() {
 			Specify("deleting the network removes it", func() {
-				netsBefore, err := hns.ListHNSNetworks()
+				netsBefore, err := hnsAPI.ListNetworks(ctx)
 				Expect(err).ToNot(HaveOccurred())
-				err = hnsMgr.DeleteNetwork(tenantName, networkName)
+				err = hnsMgr.DeleteNetwork(ctx, name)
 				Expect(err).ToNot(HaveOccurred())
-				netsAfter, err := hns.ListHNSNetworks()
+				netsAfter, err := hnsAPI.ListNetworks(ctx)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(netsBefore).To(HaveLen(len(netsAfter) + 1))
 			})
@@ -129,16 +233,37 @@ var _ = Describe("HNS manager", func() {
 				"some_other_name",
 			}
 			for _, n := range names {
-				hns.MockHNSNetwork(n, netAdapter, subnetCIDR, defaultGW)
+				hns.MockHNSNetwork(hnsAPI, n, netAdapter, subnetCIDR, defaultGW)
 			}
 		})
 		Specify("Listing only Contrail networks works", func() {
-			nets, err := hnsMgr.ListNetworks()
+			nets, err := hnsMgr.ListNetworks(ctx)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(nets).To(HaveLen(2))
 			for _, n := range nets {
 				Expect(n.Name).To(ContainSubstring("Contrail:"))
 			}
 		})
+		Specify("Listing only endpoints of Contrail networks works", func() {
+			other, err := hnsAPI.GetNetworkByName(ctx, "some_other_name")
+			Expect(err).ToNot(HaveOccurred())
+			hns.MockHNSEndpoint(hnsAPI, other.Id)
+			contrailNet, err := hnsAPI.GetNetworkByName(ctx, "Contrail:tenant1:netname1")
+			Expect(err).ToNot(HaveOccurred())
+			epID := hns.MockHNSEndpoint(hnsAPI, contrailNet.Id)
+
+			eps, err := hnsMgr.ListEndpoints(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(eps).To(HaveLen(1))
+			Expect(eps[0].Id).To(Equal(epID))
+		})
+		Specify("Tenant and network are parsed from Contrail network names", func() {
+			name, ok := DecodeHNSNetworkName("Contrail:tenant1:netname1", common.DomainName)
+			Expect(ok).To(BeTrue())
+			Expect(name.Tenant).To(Equal("tenant1"))
+			Expect(name.Network).To(Equal("netname1"))
+			_, ok = DecodeHNSNetworkName("some_other_name", common.DomainName)
+			Expect(ok).To(BeFalse())
+		})
 	})
 })
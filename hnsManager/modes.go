@@ -0,0 +1,82 @@
+package hnsManager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/hns"
+)
+
+// NetworkConfig describes HNS network of a Contrail network.
+type NetworkConfig struct {
+	// Mode is one of common.NetworkMode* modes.
+	Mode       string
+	Adapter    string
+	SubnetCIDR string
+	DefaultGW  string
+	// VSID is the virtual subnet ID of the network. It's required in overlay mode only.
+	VSID uint
+	// ManagementIP, SourceMac and MacPools are used in modes other than transparent.
+	// ManagementIP is required in overlay mode.
+	ManagementIP string
+	SourceMac    string
+	MacPools     []hns.MacPool
+}
+
+// newHNSNetwork returns HNS network of the Contrail network in the mode given in config. In
+// overlay mode, the VSID is a policy of the subnet, as HNS expects it there.
+func newHNSNetwork(name NetworkName, config *NetworkConfig) (*hns.Network, error) {
+	if !common.IsNetworkMode(config.Mode) {
+		return nil, fmt.Errorf("Unknown HNS network mode %q", config.Mode)
+	}
+	subnet := hns.Subnet{
+		AddressPrefix:  config.SubnetCIDR,
+		GatewayAddress: config.DefaultGW,
+	}
+	network := &hns.Network{
+		Name:               EncodeHNSNetworkName(name),
+		Type:               config.Mode,
+		NetworkAdapterName: config.Adapter,
+	}
+	if config.Mode != common.NetworkModeTransparent {
+		network.ManagementIP = config.ManagementIP
+		network.SourceMac = config.SourceMac
+		network.MacPools = config.MacPools
+	}
+	if config.Mode == common.NetworkModeOverlay {
+		if config.VSID == 0 {
+			return nil, errors.New("HNS network in overlay mode requires VSID")
+		}
+		if config.ManagementIP == "" {
+			return nil, errors.New("HNS network in overlay mode requires management IP")
+		}
+		policy, err := json.Marshal(hns.VsidPolicy{Type: "VSID", VSID: config.VSID})
+		if err != nil {
+			return nil, err
+		}
+		subnet.Policies = append(subnet.Policies, policy)
+	}
+	network.Subnets = []hns.Subnet{subnet}
+	return network, nil
+}
+
+// EndpointPolicies returns HNS policies that endpoints of the network need in its mode. Overlay
+// endpoints get the management IP of the network as their provider address; endpoints in other
+// modes need no policies.
+func EndpointPolicies(network *hns.Network) ([]json.RawMessage, error) {
+	if !strings.EqualFold(network.Type, common.NetworkModeOverlay) {
+		return nil, nil
+	}
+	if network.ManagementIP == "" {
+		return nil, fmt.Errorf("HNS network %s in overlay mode has no management IP",
+			network.Name)
+	}
+	policy, err := json.Marshal(hns.PaPolicy{Type: "PA", PA: network.ManagementIP})
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{policy}, nil
+}
@@ -0,0 +1,69 @@
+package hnsManager
+
+import (
+	"strings"
+
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// NetworkName identifies Contrail network that an HNS network was created for.
+type NetworkName struct {
+	Domain  string
+	Tenant  string
+	Network string
+}
+
+// hnsNameVersion tags names in the current format, so that it can change again.
+const hnsNameVersion = "v1"
+
+// Only the separator and the escape character itself are escaped, so that names stay readable
+// in HNS and PowerShell.
+var (
+	nameEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	nameUnescaper = strings.NewReplacer("%3A", ":", "%25", "%")
+)
+
+// EncodeHNSNetworkName returns name of HNS network of the Contrail network, e.g.
+// "Contrail:v1:default-domain:admin:net%3A1" for network "net:1" of project admin. Parts of
+// the name are escaped, so that Contrail names containing colons can be decoded back.
+func EncodeHNSNetworkName(name NetworkName) string {
+	return strings.Join([]string{
+		common.HNSNetworkPrefix,
+		hnsNameVersion,
+		nameEscaper.Replace(name.Domain),
+		nameEscaper.Replace(name.Tenant),
+		nameEscaper.Replace(name.Network),
+	}, ":")
+}
+
+// DecodeHNSNetworkName returns Contrail network encoded in name of HNS network. Names of the
+// older format, "Contrail:tenant:network", which didn't escape anything, are decoded too; their
+// domain is defaultDomain, the one of docker networks without the "domain" option. The last
+// result is false if it isn't a name of Contrail network.
+func DecodeHNSNetworkName(hnsName, defaultDomain string) (NetworkName, bool) {
+	parts := strings.Split(hnsName, ":")
+	if parts[0] != common.HNSNetworkPrefix {
+		return NetworkName{}, false
+	}
+	switch {
+	case len(parts) == 5 && parts[1] == hnsNameVersion:
+		name := NetworkName{
+			Domain:  nameUnescaper.Replace(parts[2]),
+			Tenant:  nameUnescaper.Replace(parts[3]),
+			Network: nameUnescaper.Replace(parts[4]),
+		}
+		// Accept only the canonical encoding, so that every network has a single HNS name.
+		if EncodeHNSNetworkName(name) != hnsName {
+			return NetworkName{}, false
+		}
+		return name, true
+	case len(parts) == 3:
+		return NetworkName{Domain: defaultDomain, Tenant: parts[1], Network: parts[2]}, true
+	}
+	return NetworkName{}, false
+}
+
+// isLegacyHNSNetworkName tells whether the name of Contrail HNS network is of the older format.
+func isLegacyHNSNetworkName(hnsName string) bool {
+	return len(strings.Split(hnsName, ":")) == 3
+}
@@ -0,0 +1,210 @@
+// Package listener opens the socket that docker daemon sends network plugin requests to, and
+// writes the plugin spec file that points docker to it.
+package listener
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// Listener accepts connections of docker daemon. Closing it removes the spec file.
+type Listener struct {
+	net.Listener
+	network  string
+	address  string
+	specFile string
+	// clientTLS is what docker daemon uses to connect to a TLS listener.
+	clientTLS *tls.Config
+}
+
+// spec is the content of a JSON spec file. Unlike plain text spec files, which contain just the
+// URL, it can tell docker daemon how to connect over TLS.
+type spec struct {
+	Name      string
+	Addr      string
+	TLSConfig *specTLSConfig `json:",omitempty"`
+}
+
+type specTLSConfig struct {
+	InsecureSkipVerify bool
+	CAFile             string `json:",omitempty"`
+	CertFile           string `json:",omitempty"`
+	KeyFile            string `json:",omitempty"`
+}
+
+// Listen opens the listener described by the config and writes spec file of the plugin to
+// specDir. Spec files of the plugin left by listeners of other types are removed, because docker
+// daemon could prefer them.
+func Listen(cfg common.ListenerConfig, pluginName, specDir string) (*Listener, error) {
+	l := &Listener{network: cfg.Type, address: cfg.Address}
+	var err error
+	switch cfg.Type {
+	case common.ListenerNamedPipe:
+		if l.address == "" {
+			l.address = "//./pipe/" + pluginName
+		}
+		l.Listener, err = listenPipe(l.address)
+	case common.ListenerTCP:
+		err = l.listenTCP(cfg)
+	case common.ListenerUnix:
+		if l.address == "" {
+			l.address = common.PluginSocketPath(pluginName)
+		}
+		l.Listener, err = listenUnix(l.address)
+	default:
+		return nil, fmt.Errorf("Unknown listener type %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.writeSpec(cfg, pluginName, specDir); err != nil {
+		l.Listener.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Listener) listenTCP(cfg common.ListenerConfig) error {
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return err
+	}
+	// Address with the actual port, in case port 0 was requested.
+	l.address = listener.Addr().String()
+	l.Listener = listener
+	if !cfg.TLS.Enabled() {
+		return nil
+	}
+
+	serverTLS, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	l.clientTLS, err = cfg.SpecTLS.ClientConfig()
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("Invalid listener spec TLS configuration: %v", err)
+	}
+	// Docker daemon doesn't verify the listener if it isn't given a CA.
+	if cfg.SpecTLS.CAFile == "" {
+		l.clientTLS.InsecureSkipVerify = true
+	}
+	l.Listener = tls.NewListener(listener, serverTLS)
+	return nil
+}
+
+// listenUnix listens on a Unix socket, replacing the socket left by previous run of the driver.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Can't listen on %s, it's not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l *Listener) writeSpec(cfg common.ListenerConfig, pluginName, specDir string) error {
+	if err := os.MkdirAll(specDir, 0755); err != nil {
+		return err
+	}
+	url := l.URL()
+
+	var content []byte
+	if l.clientTLS != nil {
+		l.specFile = filepath.Join(specDir, pluginName+".json")
+		var err error
+		content, err = json.MarshalIndent(&spec{
+			Name: pluginName,
+			Addr: url,
+			TLSConfig: &specTLSConfig{
+				InsecureSkipVerify: cfg.SpecTLS.InsecureSkipVerify,
+				CAFile:             cfg.SpecTLS.CAFile,
+				CertFile:           cfg.SpecTLS.CertFile,
+				KeyFile:            cfg.SpecTLS.KeyFile,
+			},
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+	} else {
+		l.specFile = filepath.Join(specDir, pluginName+".spec")
+		content = []byte(url)
+	}
+
+	for _, ext := range []string{".spec", ".json"} {
+		stale := filepath.Join(specDir, pluginName+ext)
+		if stale != l.specFile {
+			if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return ioutil.WriteFile(l.specFile, content, 0644)
+}
+
+// SpecFile returns path of the spec file written for the listener.
+func (l *Listener) SpecFile() string {
+	return l.specFile
+}
+
+// URL returns address of the listener as written to the spec file, e.g. "tcp://10.7.0.10:9275".
+func (l *Listener) URL() string {
+	return l.network + "://" + l.address
+}
+
+// Dial connects to the listener like docker daemon does, including TLS handshake.
+func (l *Listener) Dial(timeout time.Duration) (net.Conn, error) {
+	switch {
+	case l.network == common.ListenerNamedPipe:
+		return dialPipe(l.address, timeout)
+	case l.clientTLS != nil:
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", l.address, l.clientTLS)
+	default:
+		return net.DialTimeout(l.network, l.address, timeout)
+	}
+}
+
+// WaitAccepting waits until the listener accepts connections, but no longer than timeout. Over
+// TLS, it also waits for the handshake, which needs the listener to be served.
+func (l *Listener) WaitAccepting(timeout time.Duration) error {
+	return common.Poll(timeout, func() error {
+		conn, err := l.Dial(timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// Close stops listening and removes the spec file.
+func (l *Listener) Close() error {
+	if err := os.Remove(l.specFile); err != nil && !os.IsNotExist(err) {
+		log.Warnln("Failed to remove plugin spec file:", err)
+	}
+	return l.Listener.Close()
+}
@@ -0,0 +1,269 @@
+package listener
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/docker/go-plugins-helpers/network"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestListener(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("listener_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Listener test suite",
+		[]Reporter{junitReporter})
+}
+
+const timeout = 5 * time.Second
+
+// capabilitiesDriver answers only GetCapabilities requests.
+type capabilitiesDriver struct {
+	network.Driver
+}
+
+func (d *capabilitiesDriver) GetCapabilities() (*network.CapabilitiesResponse, error) {
+	return &network.CapabilitiesResponse{Scope: network.LocalScope}, nil
+}
+
+// getCapabilities sends GetCapabilities request over connection made by the listener's Dial,
+// like docker daemon would.
+func getCapabilities(l *Listener) (*network.CapabilitiesResponse, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return l.Dial(timeout)
+			},
+		},
+		Timeout: timeout,
+	}
+	resp, err := client.Post("http://plugin/NetworkDriver.GetCapabilities",
+		"application/json", strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var capabilities network.CapabilitiesResponse
+	err = json.NewDecoder(resp.Body).Decode(&capabilities)
+	return &capabilities, err
+}
+
+// generateCert writes a new self-signed certificate for 127.0.0.1 and its key to dir, both
+// usable by servers and clients. It returns paths of both files.
+func generateCert(dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+"-key.pem")
+	Expect(ioutil.WriteFile(certPath, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyPath, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).To(Succeed())
+	return certPath, keyPath
+}
+
+var _ = Describe("Plugin listener", func() {
+
+	var dir, specDir string
+	var cfg common.ListenerConfig
+	var l *Listener
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "listener")
+		Expect(err).ToNot(HaveOccurred())
+		specDir = filepath.Join(dir, "plugins")
+		cfg = common.ListenerConfig{Type: common.ListenerTCP, Address: "127.0.0.1:0"}
+		l = nil
+	})
+
+	AfterEach(func() {
+		if l != nil {
+			l.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	listen := func() {
+		var err error
+		l, err = Listen(cfg, "Contrail", specDir)
+		Expect(err).ToNot(HaveOccurred())
+		go network.NewHandler(&capabilitiesDriver{}).Serve(l)
+	}
+
+	readSpec := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(specDir, name))
+		Expect(err).ToNot(HaveOccurred())
+		return string(content)
+	}
+
+	It("serves plugin requests over TCP", func() {
+		listen()
+		Expect(readSpec("Contrail.spec")).To(Equal("tcp://" + l.Addr().String()))
+
+		capabilities, err := getCapabilities(l)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(capabilities.Scope).To(Equal(network.LocalScope))
+	})
+
+	It("serves plugin requests over Unix socket", func() {
+		cfg = common.ListenerConfig{
+			Type:    common.ListenerUnix,
+			Address: filepath.Join(dir, "Contrail.sock"),
+		}
+		listen()
+		Expect(readSpec("Contrail.spec")).To(Equal("unix://" + cfg.Address))
+
+		capabilities, err := getCapabilities(l)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(capabilities.Scope).To(Equal(network.LocalScope))
+	})
+
+	It("replaces Unix socket left by previous run", func() {
+		cfg = common.ListenerConfig{
+			Type:    common.ListenerUnix,
+			Address: filepath.Join(dir, "Contrail.sock"),
+		}
+		stale, err := net.Listen("unix", cfg.Address)
+		Expect(err).ToNot(HaveOccurred())
+		// Keep the socket file in place, like a crashed process would.
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		listen()
+		_, err = getCapabilities(l)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("refuses to replace a file that isn't a socket", func() {
+		cfg = common.ListenerConfig{
+			Type:    common.ListenerUnix,
+			Address: filepath.Join(dir, "Contrail.sock"),
+		}
+		Expect(ioutil.WriteFile(cfg.Address, []byte("data"), 0644)).To(Succeed())
+
+		_, err := Listen(cfg, "Contrail", specDir)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with TLS", func() {
+
+		BeforeEach(func() {
+			serverCert, serverKey := generateCert(dir, "plugin")
+			dockerCert, dockerKey := generateCert(dir, "docker")
+			cfg.TLS = common.ListenerTLSConfig{
+				CertFile:     serverCert,
+				KeyFile:      serverKey,
+				ClientCAFile: dockerCert,
+			}
+			cfg.SpecTLS = common.TLSConfig{
+				CAFile:   serverCert,
+				CertFile: dockerCert,
+				KeyFile:  dockerKey,
+			}
+		})
+
+		It("writes JSON spec with TLS configuration for docker", func() {
+			listen()
+			var written map[string]interface{}
+			Expect(json.Unmarshal([]byte(readSpec("Contrail.json")), &written)).To(Succeed())
+			Expect(written).To(Equal(map[string]interface{}{
+				"Name": "Contrail",
+				"Addr": "tcp://" + l.Addr().String(),
+				"TLSConfig": map[string]interface{}{
+					"InsecureSkipVerify": false,
+					"CAFile":             cfg.SpecTLS.CAFile,
+					"CertFile":           cfg.SpecTLS.CertFile,
+					"KeyFile":            cfg.SpecTLS.KeyFile,
+				},
+			}))
+		})
+
+		It("serves plugin requests to clients with certificate", func() {
+			listen()
+			capabilities, err := getCapabilities(l)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capabilities.Scope).To(Equal(network.LocalScope))
+		})
+
+		It("waits until the listener is served", func() {
+			var err error
+			l, err = Listen(cfg, "Contrail", specDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.WaitAccepting(100 * time.Millisecond)).ToNot(Succeed())
+
+			go network.NewHandler(&capabilitiesDriver{}).Serve(l)
+			Expect(l.WaitAccepting(timeout)).To(Succeed())
+		})
+
+		It("rejects clients without certificate", func() {
+			cfg.SpecTLS.CertFile = ""
+			cfg.SpecTLS.KeyFile = ""
+			listen()
+			_, err := getCapabilities(l)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("removes spec file left by listener of another type", func() {
+		Expect(os.MkdirAll(specDir, 0755)).To(Succeed())
+		stale := filepath.Join(specDir, "Contrail.json")
+		Expect(ioutil.WriteFile(stale, []byte("{}"), 0644)).To(Succeed())
+
+		listen()
+		_, err := os.Stat(stale)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("removes spec file when closed", func() {
+		listen()
+		Expect(l.SpecFile()).To(BeAnExistingFile())
+
+		Expect(l.Close()).To(Succeed())
+		Expect(l.SpecFile()).ToNot(BeAnExistingFile())
+		_, err := l.Dial(timeout)
+		Expect(err).To(HaveOccurred())
+		l = nil
+	})
+
+	It("rejects unknown listener type", func() {
+		cfg.Type = "udp"
+		_, err := Listen(cfg, "Contrail", specDir)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package listener
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errPipeUnsupported = errors.New("Named pipe listener is supported only on Windows")
+
+func listenPipe(address string) (net.Listener, error) {
+	return nil, errPipeUnsupported
+}
+
+func dialPipe(address string, timeout time.Duration) (net.Conn, error) {
+	return nil, errPipeUnsupported
+}
@@ -0,0 +1,25 @@
+package listener
+
+import (
+	"net"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+var pipeConfig = winio.PipeConfig{
+	// This will set permissions for Service, System, Adminstrator group and account to
+	// have full access
+	SecurityDescriptor: "D:(A;ID;FA;;;SY)(A;ID;FA;;;BA)(A;ID;FA;;;LA)(A;ID;FA;;;LS)",
+	MessageMode:        true,
+	InputBufferSize:    4096,
+	OutputBufferSize:   4096,
+}
+
+func listenPipe(address string) (net.Listener, error) {
+	return winio.ListenPipe(address, &pipeConfig)
+}
+
+func dialPipe(address string, timeout time.Duration) (net.Conn, error) {
+	return winio.DialPipe(address, &timeout)
+}
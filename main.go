@@ -1,43 +1,229 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/admin"
+	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/controller"
 	"github.com/codilime/contrail-windows-docker/driver"
+	"github.com/codilime/contrail-windows-docker/hns"
+)
+
+// Exit codes of the driver.
+const (
+	exitOK = 0
+	// exitFailure means invalid configuration, failed startup or failed subcommand.
+	exitFailure = 1
+	exitUsage   = 2
+	// exitDrainTimeout means plugin requests were still running when the driver exited.
+	exitDrainTimeout = 3
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	var configPath = flag.String("config", "",
+		"path to YAML config file; flags and OS_* environment variables override its settings")
 	var adapter = flag.String("adapter", "Ethernet0",
 		"net adapter for HNS switch, must be physical")
+	var listenerType = flag.String("listenerType", common.ListenerNamedPipe,
+		"where to listen for plugin requests: npipe, tcp or unix")
+	var listenerAddress = flag.String("listenerAddress", "",
+		"named pipe path, TCP host:port or Unix socket path; named after plugin if empty")
 	var controllerIP = flag.String("controllerIP", "127.0.0.1",
-		"IP address of Contrail Controller API")
+		"IP address of Contrail Controller API, or comma separated list of addresses")
 	var controllerPort = flag.Int("controllerPort", 8082,
 		"port of Contrail Controller API")
+	var logLevel = flag.String("logLevel", "info",
+		"log level: debug, info, warning, error, fatal or panic")
+	var logFormat = flag.String("logFormat", common.LogFormatText,
+		"log format: text or json")
+	var metricsAddress = flag.String("metricsAddress", "",
+		"address to serve Prometheus metrics on, e.g. 127.0.0.1:9273; disabled if empty")
+	var healthAddress = flag.String("healthAddress", "",
+		"address to serve /healthz and /readyz on, e.g. 127.0.0.1:9274; disabled if empty")
+	var logFile = flag.String("logFile", "",
+		"path to log file, which is rotated when it grows too big; stderr is used if not set")
+	var dockerHost = flag.String("dockerHost", "",
+		"docker daemon address, DOCKER_HOST is used if not set")
+	var dockerAPIVersion = flag.String("dockerAPIVersion", "",
+		"docker API version, DOCKER_API_VERSION is used if not set")
+	var dockerTLSCACert = flag.String("dockerTLSCACert", "",
+		"path to CA certificate of docker daemon")
+	var dockerTLSCert = flag.String("dockerTLSCert", "",
+		"path to client certificate for docker daemon")
+	var dockerTLSKey = flag.String("dockerTLSKey", "",
+		"path to client key for docker daemon")
+	var dockerTLSVerify = flag.Bool("dockerTLSVerify", false,
+		"verify certificate of docker daemon")
+	var shutdownTimeout = flag.Duration("shutdownTimeout", 0,
+		"how long to wait for plugin requests in flight when stopping; 30s if not set")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [subcommand]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Without subcommand, runs the driver.")
+		fmt.Fprint(os.Stderr, admin.Usage)
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	if flag.NArg() > 0 && !admin.IsCommand(flag.Arg(0)) {
+		flag.Usage()
+		return exitUsage
+	}
+
 	var d *driver.ContrailDriver
-	var c *controller.Controller
+	var c *controller.ContrailController
+	var docker driver.DockerClient
 	var err error
 
+	cfg := common.DefaultConfig()
+	if *configPath != "" {
+		if err = cfg.LoadFile(*configPath); err != nil {
+			log.Error(err)
+			return exitFailure
+		}
+	}
+	cfg.LoadFromEnvironment()
+
+	// Only flags that were given explicitly override the config file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "adapter":
+			cfg.Adapter = *adapter
+		case "listenerType":
+			cfg.Listener.Type = *listenerType
+		case "listenerAddress":
+			cfg.Listener.Address = *listenerAddress
+		case "controllerIP":
+			cfg.Controller.Endpoints = strings.Split(*controllerIP, ",")
+		case "controllerPort":
+			cfg.Controller.Port = *controllerPort
+		case "logLevel":
+			cfg.Log.Level = *logLevel
+		case "logFormat":
+			cfg.Log.Format = *logFormat
+		case "logFile":
+			cfg.Log.File = *logFile
+		case "metricsAddress":
+			cfg.Metrics.Address = *metricsAddress
+		case "healthAddress":
+			cfg.Health.Address = *healthAddress
+		case "dockerHost":
+			cfg.Docker.Host = *dockerHost
+		case "dockerAPIVersion":
+			cfg.Docker.APIVersion = *dockerAPIVersion
+		case "dockerTLSCACert":
+			cfg.Docker.TLSCACertPath = *dockerTLSCACert
+		case "dockerTLSCert":
+			cfg.Docker.TLSCertPath = *dockerTLSCert
+		case "dockerTLSKey":
+			cfg.Docker.TLSKeyPath = *dockerTLSKey
+		case "dockerTLSVerify":
+			cfg.Docker.TLSVerify = *dockerTLSVerify
+		case "shutdownTimeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		}
+	})
+
+	if err = cfg.Validate(); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	if err = cfg.Log.ConfigureLogging(); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+
 	keys := &controller.KeystoneEnvs{}
-	keys.LoadFromEnvironment()
+	keys.LoadFromConfig(cfg.Keystone)
 
-	if c, err = controller.NewController(*controllerIP, *controllerPort, keys); err != nil {
+	if c, err = controller.NewController(cfg.Controller, keys); err != nil {
 		log.Error(err)
-		return
+		return exitFailure
 	}
+	defer c.Close()
 
-	d = driver.NewDriver(*adapter, c)
+	if docker, err = driver.NewDockerClient(cfg.Docker); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+
+	if flag.NArg() > 0 {
+		a := admin.New(c, hns.NewHNS(), docker, cfg.RootNetwork.Name, cfg.DefaultDomain)
+		err = a.Run(context.Background(), flag.Args(), os.Stdout)
+		if err != nil {
+			log.Error(err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	stop := newStopper(cfg)
+	d = driver.NewDriver(cfg, c, hns.NewHNS(), docker)
 	if err = d.StartServing(); err != nil {
 		log.Error(err)
+		d.StopServing()
+		stop.stopped(exitFailure)
+		return exitFailure
 	}
-	defer d.StopServing()
+	stop.running()
 
+	log.Infoln(<-stop.stopRequested(), "- stopping")
+	exitCode := exitOK
+	if err = d.StopServing(); err == driver.ErrDrainTimeout {
+		exitCode = exitDrainTimeout
+	} else if err != nil {
+		exitCode = exitFailure
+	}
+	stop.stopped(exitCode)
+	return exitCode
+}
+
+// stopper tells the driver when to stop. When the driver runs as a Windows service, it also
+// reports state of the driver to the service control manager.
+type stopper interface {
+	// running reports that the driver serves plugin requests.
+	running()
+	// stopRequested returns channel that receives the reason to stop.
+	stopRequested() <-chan string
+	// stopped reports that the driver stopped with given exit code. It returns once the service
+	// control manager knows about it.
+	stopped(exitCode int)
+}
+
+// signalStopper stops the driver on Ctrl+C or SIGTERM. On Windows, Go delivers only Ctrl+C and
+// Ctrl+Break, as os.Interrupt; SIGTERM is never sent. Console close and system shutdown events
+// aren't reported, so plugin requests in flight aren't drained when a driver that doesn't run as
+// a service is stopped in those ways.
+type signalStopper struct {
+	reasons chan string
+}
+
+func newSignalStopper() *signalStopper {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	s := &signalStopper{reasons: make(chan string, 1)}
+	go func() {
+		s.reasons <- fmt.Sprint("Received ", <-sigChan)
+	}()
+	return s
 }
+
+func (s *signalStopper) running() {}
+
+func (s *signalStopper) stopRequested() <-chan string {
+	return s.reasons
+}
+
+func (s *signalStopper) stopped(exitCode int) {}
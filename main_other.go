@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "github.com/codilime/contrail-windows-docker/common"
+
+func newStopper(cfg *common.Config) stopper {
+	return newSignalStopper()
+}
@@ -0,0 +1,110 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/common"
+	"golang.org/x/sys/windows/svc"
+)
+
+// newStopper returns serviceStopper if the driver was started by the service control manager, and
+// signalStopper otherwise.
+func newStopper(cfg *common.Config) stopper {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		log.Warnln("Failed to tell whether the driver runs as a service, assuming it doesn't:",
+			err)
+	}
+	if err != nil || interactive {
+		return newSignalStopper()
+	}
+
+	s := &serviceStopper{
+		reasons:   make(chan string, 1),
+		isRunning: make(chan struct{}),
+		exitCodes: make(chan int, 1),
+		done:      make(chan struct{}),
+		// StopServing waits for requests in flight and then for their rollback.
+		stopWaitHint: 2*cfg.ShutdownTimeout + 10*time.Second,
+	}
+	go func() {
+		err := svc.Run(cfg.PluginName, s)
+		close(s.done)
+		if err != nil {
+			log.Errorln("Failed to run as a service, stopping on Ctrl+C only:", err)
+			s.requestStop(<-newSignalStopper().stopRequested())
+		}
+	}()
+	return s
+}
+
+// serviceStopper runs the driver as a Windows service. It turns stop and shutdown requests of the
+// service control manager into requests to stop the driver, and reports state of the driver back.
+type serviceStopper struct {
+	reasons chan string
+	// isRunning is closed when the driver starts serving plugin requests.
+	isRunning chan struct{}
+	exitCodes chan int
+	// done is closed when the service control manager knows that the driver stopped.
+	done         chan struct{}
+	stopWaitHint time.Duration
+}
+
+func (s *serviceStopper) running() {
+	close(s.isRunning)
+}
+
+func (s *serviceStopper) stopRequested() <-chan string {
+	return s.reasons
+}
+
+func (s *serviceStopper) stopped(exitCode int) {
+	s.exitCodes <- exitCode
+	<-s.done
+}
+
+// requestStop passes the reason on, unless the driver was already requested to stop.
+func (s *serviceStopper) requestStop(reason string) {
+	select {
+	case s.reasons <- reason:
+	default:
+	}
+}
+
+// Execute implements svc.Handler. Exit codes of the driver are reported as service specific.
+func (s *serviceStopper) Execute(args []string, requests <-chan svc.ChangeRequest,
+	status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	isRunning := s.isRunning
+	for {
+		select {
+		case <-isRunning:
+			isRunning = nil
+			status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop:
+				status <- s.stopPending()
+				s.requestStop("Received service stop request")
+			case svc.Shutdown:
+				status <- s.stopPending()
+				s.requestStop("Received system shutdown request")
+			}
+		case exitCode := <-s.exitCodes:
+			return exitCode != exitOK, uint32(exitCode)
+		}
+	}
+}
+
+func (s *serviceStopper) stopPending() svc.Status {
+	return svc.Status{
+		State:    svc.StopPending,
+		WaitHint: uint32(s.stopWaitHint / time.Millisecond),
+	}
+}
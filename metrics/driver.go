@@ -0,0 +1,41 @@
+package metrics
+
+// Default is the registry of driver metrics, served by the metrics listener.
+var Default = NewRegistry()
+
+// Results of plugin requests.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// PluginRequests counts requests from docker by plugin method (e.g. "CreateEndpoint") and
+	// result.
+	PluginRequests = Default.NewCounterVec("contrail_docker_plugin_requests_total",
+		"Number of network plugin requests handled, by method and result.", "method", "result")
+	PluginRequestDuration = Default.NewHistogramVec(
+		"contrail_docker_plugin_request_duration_seconds",
+		"Time spent handling network plugin requests, by method.", DefaultBuckets, "method")
+
+	// ContrailAPICallDuration measures every attempt of a Contrail API call by operation (e.g.
+	// "create") and object type (e.g. "virtual-network"), so retries are counted separately.
+	ContrailAPICallDuration = Default.NewHistogramVec(
+		"contrail_docker_contrail_api_call_duration_seconds",
+		"Latency of Contrail API calls, by operation and object type.", DefaultBuckets,
+		"operation", "type")
+	ContrailAPIErrors = Default.NewCounterVec("contrail_docker_contrail_api_errors_total",
+		"Number of failed Contrail API calls, by operation and object type.", "operation", "type")
+
+	// HNSCallDuration measures calls to Host Networking Service by operation (e.g.
+	// "CreateNetwork").
+	HNSCallDuration = Default.NewHistogramVec("contrail_docker_hns_call_duration_seconds",
+		"Latency of HNS calls, by operation.", DefaultBuckets, "operation")
+	HNSErrors = Default.NewCounterVec("contrail_docker_hns_errors_total",
+		"Number of failed HNS calls, by operation.", "operation")
+
+	ManagedNetworks = Default.NewGaugeFunc("contrail_docker_managed_networks",
+		"Number of Contrail HNS networks managed by the driver.")
+	ManagedEndpoints = Default.NewGaugeFunc("contrail_docker_managed_endpoints",
+		"Number of HNS endpoints in Contrail networks managed by the driver.")
+)
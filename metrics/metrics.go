@@ -0,0 +1,351 @@
+// Package metrics collects statistics of the driver and serves them in Prometheus text format.
+// It implements only the small part of the format that the driver needs: counters, histograms
+// and gauges, with labels.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultBuckets are upper bounds of latency histograms, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metric is a family of samples that share name, help and type.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds metrics that are exposed together.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s is already registered", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics in Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP serves metrics to Prometheus scrapers.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := r.WriteText(w); err != nil {
+		log.Warnln("Failed to write metrics:", err)
+	}
+}
+
+// Server serves metrics of a registry over HTTP on /metrics.
+type Server struct {
+	listener net.Listener
+}
+
+// Listen starts serving metrics on given TCP address, e.g. "127.0.0.1:9273".
+func Listen(address string, r *Registry) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	go http.Serve(listener, mux)
+	log.Infoln("Serving metrics on", listener.Addr())
+	return &Server{listener: listener}, nil
+}
+
+// Addr returns address that the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections. Scrapes that are in progress are finished.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// labelSet identifies a single sample of a metric with labels. Values are joined with a byte
+// that can't appear in them.
+type labelSet string
+
+func newLabelSet(names, values []string) labelSet {
+	if len(values) != len(names) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(names), len(values)))
+	}
+	return labelSet(strings.Join(values, "\xff"))
+}
+
+func (s labelSet) values() []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(string(s), "\xff")
+}
+
+// vec holds common parts of metrics with labels.
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+	mutex      sync.Mutex
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.kind)
+}
+
+// sortedKeys returns label sets in stable order, so that output doesn't change between scrapes.
+func sortedKeys(keys []string) []labelSet {
+	sort.Strings(keys)
+	sorted := make([]labelSet, len(keys))
+	for i, key := range keys {
+		sorted[i] = labelSet(key)
+	}
+	return sorted
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	values map[labelSet]float64
+}
+
+// NewCounterVec registers a new counter. Name should end with "_total".
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		vec:    vec{metricName: name, help: help, kind: "counter", labelNames: labelNames},
+		values: make(map[labelSet]float64),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter with given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := newLabelSet(c.labelNames, labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[key] += delta
+}
+
+// Value returns current value of the counter with given label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := newLabelSet(c.labelNames, labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeHeader(w)
+	var keys []string
+	for key := range c.values {
+		keys = append(keys, string(key))
+	}
+	for _, key := range sortedKeys(keys) {
+		writeSample(w, c.metricName, c.labelNames, key.values(), c.values[key])
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets    []float64
+	histograms map[labelSet]*histogram
+}
+
+type histogram struct {
+	// counts[i] counts observations in bucket i only, not cumulatively.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a new histogram with given bucket upper bounds.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64,
+	labelNames ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{
+		vec:        vec{metricName: name, help: help, kind: "histogram", labelNames: labelNames},
+		buckets:    sorted,
+		histograms: make(map[labelSet]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds a value to the histogram with given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := newLabelSet(h.labelNames, labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hist, exists := h.histograms[key]
+	if !exists {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+// ObserveDuration adds time elapsed since start, in seconds.
+func (h *HistogramVec) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns number of observations of the histogram with given label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := newLabelSet(h.labelNames, labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if hist, exists := h.histograms[key]; exists {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	var keys []string
+	for key := range h.histograms {
+		keys = append(keys, string(key))
+	}
+	bucketLabels := append(append([]string(nil), h.labelNames...), "le")
+	for _, key := range sortedKeys(keys) {
+		hist := h.histograms[key]
+		values := key.values()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			writeSample(w, h.metricName+"_bucket", bucketLabels,
+				append(append([]string(nil), values...), formatValue(bound)),
+				float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_bucket", bucketLabels,
+			append(append([]string(nil), values...), "+Inf"), float64(hist.count))
+		writeSample(w, h.metricName+"_sum", h.labelNames, values, hist.sum)
+		writeSample(w, h.metricName+"_count", h.labelNames, values, float64(hist.count))
+	}
+}
+
+// GaugeFunc is a gauge without labels whose value is computed when metrics are collected.
+type GaugeFunc struct {
+	vec
+	fn func() float64
+}
+
+// NewGaugeFunc registers a new gauge. Until a function is set, the gauge is not reported.
+func (r *Registry) NewGaugeFunc(name, help string) *GaugeFunc {
+	g := &GaugeFunc{vec: vec{metricName: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+// Set replaces the function that computes value of the gauge.
+func (g *GaugeFunc) Set(fn func() float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.fn = fn
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.mutex.Lock()
+	fn := g.fn
+	g.mutex.Unlock()
+	if fn == nil {
+		return
+	}
+	g.writeHeader(w)
+	writeSample(w, g.metricName, nil, nil, fn())
+}
+
+func writeSample(w io.Writer, name string, labelNames, labelValues []string, value float64) {
+	io.WriteString(w, name)
+	if len(labelNames) != 0 {
+		var pairs []string
+		for i, labelName := range labelNames {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labelName,
+				escapeLabelValue(labelValues[i])))
+		}
+		fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(w, " %s\n", formatValue(value))
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
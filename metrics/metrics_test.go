@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("metrics_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Metrics test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Metrics registry", func() {
+
+	var registry *Registry
+
+	BeforeEach(func() {
+		registry = NewRegistry()
+	})
+
+	text := func() string {
+		var buf bytes.Buffer
+		Expect(registry.WriteText(&buf)).To(Succeed())
+		return buf.String()
+	}
+
+	It("writes counters with labels in stable order", func() {
+		requests := registry.NewCounterVec("requests_total", "Number of requests.", "method")
+		requests.Inc("Join")
+		requests.Inc("CreateEndpoint")
+		requests.Add(2, "Join")
+
+		Expect(text()).To(Equal(`# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="CreateEndpoint"} 1
+requests_total{method="Join"} 3
+`))
+		Expect(requests.Value("Join")).To(Equal(3.0))
+	})
+
+	It("writes cumulative histogram buckets, sum and count", func() {
+		latency := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1},
+			"operation")
+		latency.Observe(0.05, "create")
+		latency.Observe(0.5, "create")
+		latency.Observe(5, "create")
+
+		Expect(text()).To(Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{operation="create",le="0.1"} 1
+latency_seconds_bucket{operation="create",le="1"} 2
+latency_seconds_bucket{operation="create",le="+Inf"} 3
+latency_seconds_sum{operation="create"} 5.55
+latency_seconds_count{operation="create"} 3
+`))
+		Expect(latency.Count("create")).To(Equal(uint64(3)))
+	})
+
+	It("escapes label values and help", func() {
+		errs := registry.NewCounterVec("errors_total", "Errors\nby type.", "type")
+		errs.Inc(`a "quoted\" name`)
+
+		Expect(text()).To(ContainSubstring(`# HELP errors_total Errors\nby type.`))
+		Expect(text()).To(ContainSubstring(`errors_total{type="a \"quoted\\\" name"} 1`))
+	})
+
+	It("reports gauge only after its function is set", func() {
+		networks := registry.NewGaugeFunc("networks", "Number of networks.")
+		Expect(text()).To(BeEmpty())
+
+		networks.Set(func() float64 { return 2 })
+		Expect(text()).To(Equal(`# HELP networks Number of networks.
+# TYPE networks gauge
+networks 2
+`))
+	})
+
+	It("refuses to register the same name twice", func() {
+		registry.NewCounterVec("requests_total", "Number of requests.")
+		Expect(func() {
+			registry.NewCounterVec("requests_total", "Number of requests.")
+		}).To(Panic())
+	})
+
+	It("panics on wrong number of label values", func() {
+		requests := registry.NewCounterVec("requests_total", "Number of requests.", "method")
+		Expect(func() { requests.Inc() }).To(Panic())
+	})
+
+	Context("served over HTTP", func() {
+		var server *Server
+
+		BeforeEach(func() {
+			registry.NewCounterVec("requests_total", "Number of requests.", "method").Inc("Join")
+			var err error
+			server, err = Listen("127.0.0.1:0", registry)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("serves text format on /metrics", func() {
+			resp, err := http.Get("http://" + server.Addr().String() + "/metrics")
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+			Expect(string(body)).To(ContainSubstring(`requests_total{method="Join"} 1`))
+		})
+	})
+})